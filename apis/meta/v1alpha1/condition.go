@@ -138,4 +138,10 @@ const (
 	// ConditionCanceled specifies that the resource is canceled.
 	// For resource which run to canceled.
 	ConditionCanceled ConditionType = "Canceled"
+	// ConditionPaused specifies that the reconciliation of the resource is paused.
+	// For resource paused using the PausedAnnotationKey annotation.
+	ConditionPaused ConditionType = "Paused"
+	// ConditionFinalizing specifies that the finalizers of the resource are running.
+	// For resource deleted and waiting for the cleanup of its finalizers.
+	ConditionFinalizing ConditionType = "Finalizing"
 )
@@ -42,4 +42,30 @@ const (
 
 	// UIDescriptorsAnnotationKey annotation for storing ui descriptors in resources
 	UIDescriptorsAnnotationKey = "ui.cpaas.io/descriptors"
+
+	// PausedAnnotationKey annotation to pause the reconciliation of objects when set to "true"
+	PausedAnnotationKey = "cpaas.io/paused"
+	// ResyncRequestedAnnotationKey annotation to request the reconciliation of objects,
+	// the value is usually a timestamp and the annotation is removed once the object is reconciled
+	ResyncRequestedAnnotationKey = "cpaas.io/resyncRequested"
+
+	// LogicalOwnersAnnotationKey annotation storing the owners of objects as a json list,
+	// used when owner references cannot be used, e.g. owners in another namespace or cluster
+	LogicalOwnersAnnotationKey = "cpaas.io/logicalOwners"
+
+	// ForceFinalizeAnnotationKey annotation to force the removal of the finalizers of deleted objects
+	// when set to "true", finalizers are removed without cleanup once the grace period of their controller is exceeded
+	ForceFinalizeAnnotationKey = "cpaas.io/forceFinalize"
+
+	// SpecHashAnnotationKey annotation storing the hash of the desired state of managed child objects,
+	// used to detect drifts without comparing fields defaulted by the server
+	SpecHashAnnotationKey = "cpaas.io/specHash"
+
+	// TTLAfterFinishedAnnotationKey annotation overriding the duration, e.g. "24h", after which finished objects
+	// are deleted by the garbage collector, "0s" deletes them as soon as they finish
+	TTLAfterFinishedAnnotationKey = "cpaas.io/ttlAfterFinished"
+
+	// ConversionDataAnnotationKey annotation storing the fields of an object which cannot be
+	// represented in the version it was converted to, so that they are restored when converted back
+	ConversionDataAnnotationKey = "cpaas.io/conversionData"
 )
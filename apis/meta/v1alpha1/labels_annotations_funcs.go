@@ -63,6 +63,22 @@ func HasAnnotationKey(obj metav1.Object, key string) bool {
 	return MapContainsKey(obj.GetAnnotations(), key)
 }
 
+// IsPaused returns true if the reconciliation of the object is paused using PausedAnnotationKey
+func IsPaused(obj metav1.Object) bool {
+	return HasAnnotation(obj, PausedAnnotationKey, "true")
+}
+
+// IsForceFinalizeRequested returns true if the removal of the finalizers of the object is forced
+// using ForceFinalizeAnnotationKey
+func IsForceFinalizeRequested(obj metav1.Object) bool {
+	return HasAnnotation(obj, ForceFinalizeAnnotationKey, "true")
+}
+
+// IsResyncRequested returns true if the object has the ResyncRequestedAnnotationKey annotation
+func IsResyncRequested(obj metav1.Object) bool {
+	return HasAnnotationKey(obj, ResyncRequestedAnnotationKey)
+}
+
 // HasLabel returns true if the object has the label and the values matches
 func HasLabel(obj metav1.Object, key, value string) bool {
 	return MapContainsKeyValue(obj.GetLabels(), key, value)
@@ -0,0 +1,158 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"fmt"
+	"strings"
+
+	apimachineryvalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"knative.dev/pkg/apis"
+)
+
+// Transitions are the allowed transitions of an enum field, from a value to the values it can change to.
+// Values without an entry cannot change, the empty value stands for an unset field.
+//
+//	Transitions{"": {"Pending"}, "Pending": {"Running", "Cancelled"}, "Running": {"Succeeded", "Failed"}}
+type Transitions map[string][]string
+
+// Allowed returns true if the field can change from the old value to the new value
+func (t Transitions) Allowed(oldValue, newValue string) bool {
+	if oldValue == newValue {
+		return true
+	}
+	for _, allowed := range t[oldValue] {
+		if allowed == newValue {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFieldPath returns an error if the dot separated path, e.g. "spec.type",
+// cannot select a field of the objects, e.g. when it is empty or it indexes a list
+func ValidateFieldPath(path string) error {
+	_, _, err := splitFieldPath(path)
+	return err
+}
+
+// ValidateImmutableFields returns an error for each field changed between old and obj.
+// Fields are selected by dot separated paths, e.g. "spec.type", a field set or unset by the update is changed.
+// Paths which cannot be resolved, see ValidateFieldPath, return an internal error.
+func ValidateImmutableFields(obj, old runtime.Object, paths ...string) field.ErrorList {
+	content, oldContent, errs := updateContents(obj, old)
+	if len(errs) > 0 {
+		return errs
+	}
+	for _, path := range paths {
+		fields, fldPath, err := splitFieldPath(path)
+		if err != nil {
+			errs = append(errs, field.InternalError(nil, err))
+			continue
+		}
+		value, _, err := unstructured.NestedFieldNoCopy(content, fields...)
+		if err != nil {
+			errs = append(errs, field.InternalError(fldPath, err))
+			continue
+		}
+		oldValue, _, err := unstructured.NestedFieldNoCopy(oldContent, fields...)
+		if err != nil {
+			errs = append(errs, field.InternalError(fldPath, err))
+			continue
+		}
+		errs = append(errs, apimachineryvalidation.ValidateImmutableField(value, oldValue, fldPath)...)
+	}
+	return errs
+}
+
+// ValidateFieldTransition returns an error if the enum field selected by the dot separated path,
+// e.g. "spec.state", changed between old and obj to a value not allowed by transitions.
+// A path which cannot be resolved, see ValidateFieldPath, returns an internal error.
+func ValidateFieldTransition(obj, old runtime.Object, path string, transitions Transitions) field.ErrorList {
+	content, oldContent, errs := updateContents(obj, old)
+	if len(errs) > 0 {
+		return errs
+	}
+	fields, fldPath, err := splitFieldPath(path)
+	if err != nil {
+		return field.ErrorList{field.InternalError(nil, err)}
+	}
+	value, err := stringField(content, fields)
+	if err != nil {
+		return field.ErrorList{field.InternalError(fldPath, err)}
+	}
+	oldValue, err := stringField(oldContent, fields)
+	if err != nil {
+		return field.ErrorList{field.InternalError(fldPath, err)}
+	}
+	if transitions.Allowed(oldValue, value) {
+		return nil
+	}
+	allowed := transitions[oldValue]
+	if len(allowed) == 0 {
+		return field.ErrorList{field.Invalid(fldPath, value, fmt.Sprintf("cannot change from %q", oldValue))}
+	}
+	return field.ErrorList{field.Invalid(fldPath, value, fmt.Sprintf("cannot change from %q, allowed values: %q", oldValue, allowed))}
+}
+
+// FieldErrorFromErrorList converts an error list to an apis.FieldError,
+// to be used by validations returning knative field errors
+func FieldErrorFromErrorList(errs field.ErrorList) (fieldErr *apis.FieldError) {
+	for _, err := range errs {
+		fieldErr = fieldErr.Also(&apis.FieldError{Message: err.ErrorBody(), Paths: []string{err.Field}})
+	}
+	return fieldErr
+}
+
+func updateContents(obj, old runtime.Object) (content, oldContent map[string]interface{}, errs field.ErrorList) {
+	var err error
+	if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+		return nil, nil, field.ErrorList{field.InternalError(nil, err)}
+	}
+	if oldContent, err = runtime.DefaultUnstructuredConverter.ToUnstructured(old); err != nil {
+		return nil, nil, field.ErrorList{field.InternalError(nil, err)}
+	}
+	return content, oldContent, nil
+}
+
+// splitFieldPath splits a dot separated path into the fields of the unstructured content,
+// only paths of nested maps are supported
+func splitFieldPath(path string) ([]string, *field.Path, error) {
+	if strings.ContainsAny(path, "[]*{}") {
+		return nil, nil, fmt.Errorf("invalid field path %q: only dot separated fields are supported", path)
+	}
+	fields := strings.Split(path, ".")
+	for _, name := range fields {
+		if name == "" {
+			return nil, nil, fmt.Errorf("invalid field path %q: empty field", path)
+		}
+	}
+	return fields, field.NewPath(fields[0], fields[1:]...), nil
+}
+
+// stringField returns the value of the field as a string, empty if it is not set,
+// returns an error if a parent of the field is not a map
+func stringField(content map[string]interface{}, fields []string) (string, error) {
+	value, ok, err := unstructured.NestedFieldNoCopy(content, fields...)
+	if err != nil || !ok || value == nil {
+		return "", err
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidateImmutableFields(t *testing.T) {
+	g := NewGomegaWithT(t)
+	old := &corev1.Pod{Spec: corev1.PodSpec{NodeName: "node-1", ServiceAccountName: "default"}}
+
+	updated := old.DeepCopy()
+	updated.Spec.Hostname = "changed"
+	g.Expect(ValidateImmutableFields(updated, old, "spec.nodeName", "spec.serviceAccountName")).To(BeEmpty())
+
+	updated.Spec.NodeName = "node-2"
+	updated.Spec.ServiceAccountName = ""
+	errs := ValidateImmutableFields(updated, old, "spec.nodeName", "spec.serviceAccountName")
+	g.Expect(errs).To(HaveLen(2))
+	g.Expect(errs[0].Type).To(Equal(field.ErrorTypeInvalid))
+	g.Expect(errs[0].Field).To(Equal("spec.nodeName"))
+	g.Expect(errs[1].Field).To(Equal("spec.serviceAccountName"))
+
+	fieldErr := FieldErrorFromErrorList(errs)
+	g.Expect(fieldErr.Error()).To(ContainSubstring("spec.nodeName"))
+	g.Expect(FieldErrorFromErrorList(nil)).To(BeNil())
+
+	// paths which cannot be resolved are not ignored
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "a"}}}}
+	for _, path := range []string{"spec.containers[0].image", "spec..nodeName", "", "spec.containers.image"} {
+		errs := ValidateImmutableFields(pod, pod, path)
+		g.Expect(errs).To(HaveLen(1), path)
+		g.Expect(errs[0].Type).To(Equal(field.ErrorTypeInternal), path)
+	}
+	g.Expect(ValidateFieldPath("spec.nodeName")).To(Succeed())
+	g.Expect(ValidateFieldPath("spec.containers[0].image")).NotTo(Succeed())
+}
+
+func TestValidateFieldTransition(t *testing.T) {
+	transitions := Transitions{
+		"":                        {string(corev1.PodPending)},
+		string(corev1.PodPending): {string(corev1.PodRunning), string(corev1.PodFailed)},
+		string(corev1.PodRunning): {string(corev1.PodSucceeded), string(corev1.PodFailed)},
+	}
+
+	table := map[string]struct {
+		From, To corev1.PodPhase
+		Valid    bool
+	}{
+		"set initial value":      {"", corev1.PodPending, true},
+		"unchanged":              {corev1.PodRunning, corev1.PodRunning, true},
+		"allowed transition":     {corev1.PodPending, corev1.PodRunning, true},
+		"not allowed transition": {corev1.PodRunning, corev1.PodPending, false},
+		"terminal value":         {corev1.PodSucceeded, corev1.PodRunning, false},
+		"unset":                  {corev1.PodPending, "", false},
+		"invalid initial value":  {"", corev1.PodRunning, false},
+	}
+	for name, item := range table {
+		t.Run(name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			old := &corev1.Pod{Status: corev1.PodStatus{Phase: item.From}}
+			updated := &corev1.Pod{Status: corev1.PodStatus{Phase: item.To}}
+			errs := ValidateFieldTransition(updated, old, "status.phase", transitions)
+			if item.Valid {
+				g.Expect(errs).To(BeEmpty())
+			} else {
+				g.Expect(errs).To(HaveLen(1))
+				g.Expect(errs[0].Field).To(Equal("status.phase"))
+			}
+		})
+	}
+
+	t.Run("invalid path", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "a"}}}}
+		for _, path := range []string{"status.conditions[0].type", "spec.containers.name"} {
+			errs := ValidateFieldTransition(pod, pod, path, transitions)
+			g.Expect(errs).To(HaveLen(1), path)
+			g.Expect(errs[0].Type).To(Equal(field.ErrorTypeInternal), path)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conditions provides a condition set computing the top level condition of objects from dependent conditions
+package conditions
+
+import (
+	"context"
+	"fmt"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	krecord "github.com/AlaudaDevops/pkg/record"
+	"github.com/AlaudaDevops/pkg/warnings"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/strings"
+	"knative.dev/pkg/apis"
+)
+
+// ConditionSet declares the top level condition of objects and the dependent conditions it is computed from.
+// Unlike apis.ConditionSet, dependents marked False with a Warning or Info severity do not flip the top level
+// condition, and warnings.WarningRecords are surfaced in the Warning condition which is not a dependent.
+type ConditionSet struct {
+	happy      apis.ConditionType
+	dependents []apis.ConditionType
+}
+
+// NewConditionSet returns a condition set with the top level condition happy computed from dependents
+func NewConditionSet(happy apis.ConditionType, dependents ...apis.ConditionType) ConditionSet {
+	set := ConditionSet{happy: happy}
+	for _, dependent := range dependents {
+		if dependent != happy && !set.IsDependent(dependent) {
+			set.dependents = append(set.dependents, dependent)
+		}
+	}
+	return set
+}
+
+// NewReadyConditionSet returns a condition set for long-running resources with metav1alpha1.ConditionReady as top level condition
+func NewReadyConditionSet(dependents ...apis.ConditionType) ConditionSet {
+	return NewConditionSet(apis.ConditionType(metav1alpha1.ConditionReady), dependents...)
+}
+
+// NewSucceededConditionSet returns a condition set for resources which run to completion
+// with metav1alpha1.ConditionSucceeded as top level condition
+func NewSucceededConditionSet(dependents ...apis.ConditionType) ConditionSet {
+	return NewConditionSet(apis.ConditionType(metav1alpha1.ConditionSucceeded), dependents...)
+}
+
+// GetTopLevelConditionType returns the type of the top level condition
+func (s ConditionSet) GetTopLevelConditionType() apis.ConditionType {
+	return s.happy
+}
+
+// IsDependent returns true if the top level condition depends on the condition
+func (s ConditionSet) IsDependent(t apis.ConditionType) bool {
+	for _, dependent := range s.dependents {
+		if dependent == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Manage returns a manager of the conditions of status,
+// object is the object owning status used to record events and may be nil
+func (s ConditionSet) Manage(object runtime.Object, status apis.ConditionsAccessor) *ConditionSetManager {
+	manager := &ConditionSetManager{
+		set:        s,
+		object:     object,
+		conditions: apis.NewLivingConditionSet().Manage(status),
+	}
+	if initial := manager.GetTopLevelCondition(); initial != nil {
+		manager.initial = initial.DeepCopy()
+	}
+	return manager
+}
+
+// ConditionSetManager sets the conditions of an object and recomputes its top level condition, see ConditionSet
+type ConditionSetManager struct {
+	set    ConditionSet
+	object runtime.Object
+	// conditions is only used to get, set and clear conditions without recomputing the happy condition
+	conditions apis.ConditionManager
+	// initial is the top level condition when the manager was created
+	initial *apis.Condition
+}
+
+// GetCondition returns the condition of the type or nil
+func (m *ConditionSetManager) GetCondition(t apis.ConditionType) *apis.Condition {
+	return m.conditions.GetCondition(t)
+}
+
+// GetTopLevelCondition returns the top level condition or nil
+func (m *ConditionSetManager) GetTopLevelCondition() *apis.Condition {
+	return m.GetCondition(m.set.happy)
+}
+
+// IsHappy returns true if the top level condition is True
+func (m *ConditionSetManager) IsHappy() bool {
+	return m.GetTopLevelCondition().IsTrue()
+}
+
+// InitializeConditions sets the dependents not set yet to Unknown and recomputes the top level condition
+func (m *ConditionSetManager) InitializeConditions() {
+	for _, dependent := range m.set.dependents {
+		if m.GetCondition(dependent) == nil {
+			m.conditions.SetCondition(apis.Condition{Type: dependent, Status: corev1.ConditionUnknown, Reason: metav1alpha1.ConditionReasonNotSet})
+		}
+	}
+	m.recompute()
+}
+
+// SetCondition sets a condition and recomputes the top level condition if the condition is a dependent
+func (m *ConditionSetManager) SetCondition(condition apis.Condition) {
+	m.conditions.SetCondition(condition)
+	if m.set.IsDependent(condition.Type) {
+		m.recompute()
+	}
+}
+
+// MarkTrue sets the condition to True
+func (m *ConditionSetManager) MarkTrue(t apis.ConditionType, reason, messageFormat string, messageA ...interface{}) {
+	m.mark(t, corev1.ConditionTrue, apis.ConditionSeverityError, reason, messageFormat, messageA...)
+}
+
+// MarkFalse sets the condition to False, the top level condition becomes False if the condition is a dependent
+func (m *ConditionSetManager) MarkFalse(t apis.ConditionType, reason, messageFormat string, messageA ...interface{}) {
+	m.mark(t, corev1.ConditionFalse, apis.ConditionSeverityError, reason, messageFormat, messageA...)
+}
+
+// MarkFalseWithSeverity sets the condition to False with a severity,
+// dependents with a Warning or Info severity do not flip the top level condition
+func (m *ConditionSetManager) MarkFalseWithSeverity(t apis.ConditionType, severity apis.ConditionSeverity, reason, messageFormat string, messageA ...interface{}) {
+	m.mark(t, corev1.ConditionFalse, severity, reason, messageFormat, messageA...)
+}
+
+// MarkUnknown sets the condition to Unknown, the top level condition becomes Unknown
+// if the condition is a dependent and no other dependent is False
+func (m *ConditionSetManager) MarkUnknown(t apis.ConditionType, reason, messageFormat string, messageA ...interface{}) {
+	m.mark(t, corev1.ConditionUnknown, apis.ConditionSeverityError, reason, messageFormat, messageA...)
+}
+
+// MarkError sets the condition to True if err is nil,
+// otherwise to False using the reason of the error, see metav1alpha1.SetConditionByError
+func (m *ConditionSetManager) MarkError(t apis.ConditionType, err error) {
+	if err == nil {
+		m.MarkTrue(t, "", "")
+		return
+	}
+	m.MarkFalse(t, metav1alpha1.ReasonForError(err), "%s", strings.ShortenString(err.Error(), metav1alpha1.MaxConditionMessageLength))
+}
+
+// MarkWarnings surfaces the warnings in the Warning condition with a Warning severity,
+// the condition is cleared if there are no warnings. The top level condition is not changed.
+func (m *ConditionSetManager) MarkWarnings(records warnings.WarningRecords) {
+	condition := records.MakeCondition()
+	if condition == nil {
+		// ClearCondition only fails for nil accessors
+		_ = m.conditions.ClearCondition(warnings.WarningConditionType)
+		return
+	}
+	m.SetCondition(*condition)
+}
+
+// RecordTransition records an event using the recorder in the context if the status or the reason
+// of the top level condition changed since the manager was created, returns true if it changed.
+// A Warning event is recorded when the top level condition becomes False, a Normal event otherwise.
+func (m *ConditionSetManager) RecordTransition(ctx context.Context) bool {
+	current := m.GetTopLevelCondition()
+	if current == nil || (m.initial != nil && m.initial.Status == current.Status && m.initial.Reason == current.Reason) {
+		return false
+	}
+	if m.initial == nil {
+		m.initial = &apis.Condition{}
+	}
+	previous := m.initial.Status
+	m.initial = current.DeepCopy()
+
+	recorder := krecord.FromContext(ctx)
+	if recorder == nil || m.object == nil {
+		return true
+	}
+	eventType := corev1.EventTypeNormal
+	if current.IsFalse() {
+		eventType = corev1.EventTypeWarning
+	}
+	reason := current.Reason
+	if reason == "" {
+		reason = string(current.Type) + string(current.Status)
+	}
+	message := fmt.Sprintf("%s changed from %q to %q", current.Type, previous, current.Status)
+	if current.Message != "" {
+		message += ": " + current.Message
+	}
+	recorder.Event(m.object, eventType, reason, message)
+	return true
+}
+
+func (m *ConditionSetManager) mark(t apis.ConditionType, status corev1.ConditionStatus, severity apis.ConditionSeverity, reason, messageFormat string, messageA ...interface{}) {
+	m.SetCondition(apis.Condition{
+		Type:     t,
+		Status:   status,
+		Severity: severity,
+		Reason:   reason,
+		Message:  fmt.Sprintf(messageFormat, messageA...),
+	})
+}
+
+// recompute sets the top level condition from the dependents:
+// False if any dependent with an Error severity is False, Unknown if any is Unknown or not set, True otherwise.
+// Dependents are checked in declaration order, the first unhappy dependent provides the reason and message.
+func (m *ConditionSetManager) recompute() {
+	if len(m.set.dependents) == 0 {
+		return
+	}
+	var unknown *apis.Condition
+	for _, dependent := range m.set.dependents {
+		condition := m.GetCondition(dependent)
+		switch {
+		case condition == nil:
+			if unknown == nil {
+				unknown = &apis.Condition{Reason: metav1alpha1.ConditionReasonNotSet, Message: fmt.Sprintf("condition %s is not set", dependent)}
+			}
+		case condition.Severity != apis.ConditionSeverityError:
+			// warnings and informational conditions never flip the top level condition
+		case condition.IsFalse():
+			m.conditions.SetCondition(apis.Condition{
+				Type: m.set.happy, Status: corev1.ConditionFalse, Reason: condition.Reason, Message: condition.Message,
+			})
+			return
+		case condition.IsUnknown():
+			if unknown == nil {
+				unknown = condition
+			}
+		}
+	}
+	if unknown != nil {
+		m.conditions.SetCondition(apis.Condition{
+			Type: m.set.happy, Status: corev1.ConditionUnknown, Reason: unknown.Reason, Message: unknown.Message,
+		})
+		return
+	}
+	m.conditions.SetCondition(apis.Condition{Type: m.set.happy, Status: corev1.ConditionTrue})
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conditions
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	krecord "github.com/AlaudaDevops/pkg/record"
+	"github.com/AlaudaDevops/pkg/warnings"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+)
+
+const (
+	DeployedCondition apis.ConditionType = "Deployed"
+	SyncedCondition   apis.ConditionType = "Synced"
+)
+
+func TestConditionSet(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recorder := record.NewFakeRecorder(10)
+	ctx := krecord.WithRecorder(context.TODO(), recorder)
+
+	set := NewReadyConditionSet(DeployedCondition, SyncedCondition)
+	g.Expect(set.GetTopLevelConditionType()).To(Equal(apis.ConditionType(metav1alpha1.ConditionReady)))
+	obj := &duckv1.KResource{}
+
+	manager := set.Manage(obj, &obj.Status)
+	manager.InitializeConditions()
+	g.Expect(manager.GetTopLevelCondition().IsUnknown()).To(BeTrue())
+	g.Expect(manager.RecordTransition(ctx)).To(BeTrue())
+	g.Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeNormal)))
+
+	manager.MarkTrue(DeployedCondition, "", "")
+	g.Expect(manager.IsHappy()).To(BeFalse())
+	manager.MarkError(SyncedCondition, nil)
+	g.Expect(manager.IsHappy()).To(BeTrue())
+	g.Expect(manager.RecordTransition(ctx)).To(BeTrue())
+	g.Expect(manager.RecordTransition(ctx)).To(BeFalse())
+	g.Expect(recorder.Events).To(Receive(ContainSubstring(`Ready changed from "Unknown" to "True"`)))
+
+	// dependents with a warning severity and warnings do not flip the top level condition
+	manager.MarkFalseWithSeverity(SyncedCondition, apis.ConditionSeverityWarning, "Lagging", "sync is lagging")
+	manager.MarkWarnings(warnings.NewWarningRecords(&warnings.WarningRecord{Reason: "Deprecated", Message: "deprecated field"}))
+	g.Expect(manager.IsHappy()).To(BeTrue())
+	g.Expect(manager.GetCondition(warnings.WarningConditionType).Reason).To(Equal("Deprecated"))
+	manager.MarkWarnings(nil)
+	g.Expect(manager.GetCondition(warnings.WarningConditionType)).To(BeNil())
+	g.Expect(manager.RecordTransition(ctx)).To(BeFalse())
+
+	// failed dependents flip the top level condition
+	manager.MarkError(DeployedCondition, errors.New("image pull failed"))
+	g.Expect(manager.GetTopLevelCondition().IsFalse()).To(BeTrue())
+	g.Expect(manager.GetTopLevelCondition().Message).To(Equal("image pull failed"))
+	g.Expect(manager.RecordTransition(ctx)).To(BeTrue())
+	g.Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeWarning)))
+
+	// non dependents do not change the top level condition
+	manager.MarkFalse("Other", "Failed", "")
+	g.Expect(manager.GetTopLevelCondition().Message).To(Equal("image pull failed"))
+}
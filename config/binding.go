@@ -0,0 +1,214 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AlaudaDevops/pkg/encoding"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Binding keeps a struct of type T decoded from the keys of the configuration,
+// the struct is decoded again each time a key with the prefix of the binding changes.
+//
+// Keys are decoded using encoding.JsonPath, fields are matched using `path` tags
+// and dots in keys are nested structs, e.g. the key "gc.ttl" is decoded to:
+//
+//	type Settings struct {
+//		GC struct {
+//			TTL time.Duration `path:"ttl"`
+//		} `path:"gc"`
+//	}
+type Binding[T any] struct {
+	manager      *Manager
+	prefix       string
+	defaults     T
+	defaultsJSON []byte // copy of defaults when T has no DeepCopy method
+	jsonPath     encoding.JsonPath
+	value        atomic.Pointer[T]
+	subscription *Subscription
+
+	// bindLock serializes the decoding of configurations
+	bindLock  sync.Mutex
+	lock      sync.Mutex
+	errors    map[string]error
+	listeners []func(old, new T)
+}
+
+// Bind binds the keys of the configuration starting with prefix to a struct of type T,
+// the prefix is removed from the keys before decoding.
+// Keys not set in the configuration keep the values of defaults, which are deep copied before each decoding
+// using the DeepCopy method of T if any, otherwise using a JSON round trip so fields not marshalled to JSON are reset.
+// Only one binding can exist for a type T, see Get.
+func Bind[T any](manager *Manager, prefix string, defaults T) (*Binding[T], error) {
+	b := &Binding[T]{
+		manager:  manager,
+		prefix:   prefix,
+		defaults: defaults,
+		jsonPath: encoding.JsonPath{DecodeHook: mapstructure.StringToTimeDurationHookFunc()},
+	}
+	if _, ok := any(&defaults).(interface{ DeepCopy() *T }); !ok {
+		data, err := json.Marshal(defaults)
+		if err != nil {
+			return nil, fmt.Errorf("defaults of type %T cannot be copied: %w", defaults, err)
+		}
+		b.defaultsJSON = data
+	}
+	b.value.Store(&defaults)
+
+	typ := reflect.TypeFor[T]()
+	manager.lock.Lock()
+	if _, ok := manager.bindings[typ]; ok {
+		manager.lock.Unlock()
+		return nil, fmt.Errorf("type %s is already bound to the configuration", typ)
+	}
+	if manager.bindings == nil {
+		manager.bindings = map[reflect.Type]any{}
+	}
+	manager.bindings[typ] = b
+	manager.lock.Unlock()
+
+	var opts []SubscribeOption
+	if prefix != "" {
+		opts = append(opts, WithPrefixes(prefix))
+	}
+	b.subscription = manager.Subscribe(fmt.Sprintf("binding-%s", typ), func(change ConfigChange) {
+		b.bind(change.New)
+	}, opts...)
+	// decodes the current configuration after subscribing to not miss any change
+	b.bind(nil)
+	return b, nil
+}
+
+// Get returns a snapshot of the struct bound to the configuration of the manager using Bind,
+// returns false if the type is not bound.
+func Get[T any](manager *Manager) (T, bool) {
+	var zero T
+	if manager == nil {
+		return zero, false
+	}
+	manager.lock.RLock()
+	binding, ok := manager.bindings[reflect.TypeFor[T]()]
+	manager.lock.RUnlock()
+	if !ok {
+		return zero, false
+	}
+	return binding.(*Binding[T]).Get(), true
+}
+
+// Get returns the last struct successfully decoded
+func (b *Binding[T]) Get() T {
+	return *b.value.Load()
+}
+
+// Errors returns the decoding errors of the latest configuration by key,
+// the last valid struct is kept while there is any error
+func (b *Binding[T]) Errors() map[string]error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	errs := make(map[string]error, len(b.errors))
+	for key, err := range b.errors {
+		errs[key] = err
+	}
+	return errs
+}
+
+// OnChange adds a listener invoked with the previous and the new struct each time
+// the decoded struct changes, listeners are invoked in order of the changes
+func (b *Binding[T]) OnChange(listener func(old, new T)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// Stop stops updating the struct and removes the binding from the manager
+func (b *Binding[T]) Stop() {
+	b.subscription.Cancel()
+	b.manager.lock.Lock()
+	defer b.manager.lock.Unlock()
+	delete(b.manager.bindings, reflect.TypeFor[T]())
+}
+
+// copyDefaults returns a deep copy of the defaults of the binding
+func (b *Binding[T]) copyDefaults() (value T, err error) {
+	if copier, ok := any(&b.defaults).(interface{ DeepCopy() *T }); ok {
+		return *copier.DeepCopy(), nil
+	}
+	err = json.Unmarshal(b.defaultsJSON, &value)
+	return value, err
+}
+
+// bind decodes the configuration and replaces the struct if all keys are valid,
+// the current configuration of the manager is decoded if config is nil
+func (b *Binding[T]) bind(config *Config) {
+	b.bindLock.Lock()
+	defer b.bindLock.Unlock()
+	if config == nil {
+		config = b.manager.GetConfig()
+	}
+
+	data := map[string]string{}
+	if config != nil {
+		for key, value := range config.Data {
+			if strings.HasPrefix(key, b.prefix) {
+				data[strings.TrimPrefix(key, b.prefix)] = value
+			}
+		}
+	}
+
+	// each key is decoded alone to report errors by key
+	errs := map[string]error{}
+	for key, value := range data {
+		if err := b.jsonPath.Decode(new(T), map[string]string{key: value}); err != nil {
+			errs[b.prefix+key] = err
+		}
+	}
+	// defaults are copied as decoding may modify their maps and slices
+	value, err := b.copyDefaults()
+	if err != nil {
+		errs[b.prefix] = err
+	} else if len(errs) == 0 {
+		if err := b.jsonPath.Decode(&value, data); err != nil {
+			errs[b.prefix] = err
+		}
+	}
+
+	b.lock.Lock()
+	b.errors = errs
+	listeners := append([]func(old, new T){}, b.listeners...)
+	b.lock.Unlock()
+	if len(errs) > 0 {
+		if b.manager.Logger != nil {
+			b.manager.Logger.Warnw("failed to decode configuration, keeping the last valid value", "prefix", b.prefix, "errs", errs)
+		}
+		return
+	}
+
+	old := b.value.Swap(&value)
+	if reflect.DeepEqual(*old, value) {
+		return
+	}
+	for _, listener := range listeners {
+		listener(*old, value)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+type gcSettings struct {
+	Enabled bool `path:"enabled"`
+	Policy  struct {
+		TTL     time.Duration `path:"ttl"`
+		Workers int           `path:"workers"`
+	} `path:"policy"`
+	Namespaces []string `path:"namespaces"`
+}
+
+func TestBind(t *testing.T) {
+	g := NewGomegaWithT(t)
+	manager := newTestManager()
+	applyData(manager, map[string]string{"gc.enabled": "true", "other": "x"})
+
+	defaults := gcSettings{}
+	defaults.Policy.Workers = 2
+	binding, err := Bind(manager, "gc.", defaults)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer binding.Stop()
+
+	settings, ok := Get[gcSettings](manager)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(settings.Enabled).To(BeTrue())
+	g.Expect(settings.Policy.Workers).To(Equal(2))
+
+	_, err = Bind(manager, "other.", gcSettings{})
+	g.Expect(err).To(HaveOccurred())
+
+	changes := make(chan gcSettings, 10)
+	binding.OnChange(func(old, new gcSettings) { changes <- new })
+
+	applyData(manager, map[string]string{
+		"gc.enabled":       "true",
+		"gc.policy.ttl":    "1h",
+		"gc.namespaces[0]": "default",
+		"gc.namespaces[1]": "system",
+	})
+	g.Eventually(changes).Should(Receive(And(
+		HaveField("Policy.TTL", time.Hour),
+		HaveField("Policy.Workers", 2),
+		HaveField("Namespaces", []string{"default", "system"}),
+	)))
+
+	// the last valid value is kept while a key is invalid
+	applyData(manager, map[string]string{"gc.enabled": "true", "gc.policy.ttl": "1x", "gc.policy.workers": "4"})
+	g.Eventually(binding.Errors).Should(HaveKey("gc.policy.ttl"))
+	g.Expect(binding.Errors()).To(HaveLen(1))
+	g.Consistently(changes).ShouldNot(Receive())
+	g.Expect(binding.Get().Policy.TTL).To(Equal(time.Hour))
+
+	applyData(manager, map[string]string{"gc.enabled": "false"})
+	g.Eventually(changes).Should(Receive(HaveField("Enabled", false)))
+	g.Expect(binding.Errors()).To(BeEmpty())
+	g.Expect(binding.Get().Policy.TTL).To(BeZero())
+
+	binding.Stop()
+	_, ok = Get[gcSettings](manager)
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestBindCopiesDefaults(t *testing.T) {
+	g := NewGomegaWithT(t)
+	manager := newTestManager()
+	applyData(manager, map[string]string{"gc.namespaces[0]": "x"})
+
+	defaults := gcSettings{Namespaces: []string{"a", "b"}}
+	binding, err := Bind(manager, "gc.", defaults)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer binding.Stop()
+	g.Expect(binding.Get().Namespaces).To(HaveExactElements("x", "b"))
+	g.Expect(defaults.Namespaces).To(HaveExactElements("a", "b"))
+
+	applyData(manager, map[string]string{"gc.enabled": "true"})
+	g.Eventually(func() []string { return binding.Get().Namespaces }).Should(HaveExactElements("a", "b"))
+}
@@ -16,9 +16,21 @@ limitations under the License.
 
 package config
 
+import "fmt"
+
 const (
 	// PprofEnabledKey indicates the configuration key of the /debug/pprof debugging api/
 	PprofEnabledKey = "pprof.enabled"
+
+	// DebugEnabledKey indicates the configuration key of the /debug/controllers and /debug/features debugging apis
+	DebugEnabledKey = "debug.enabled"
+
+	// LazyLoaderIntervalKey indicates the configuration key of the interval used by the
+	// controllers lazy loader to check pending controllers, e.g. "30s"
+	LazyLoaderIntervalKey = "lazyloader.interval"
+
+	// ControllerKeyPrefix is the prefix of the configuration keys of controllers, see ControllerEnabledKey
+	ControllerKeyPrefix = "controller."
 )
 
 const (
@@ -31,11 +43,67 @@ const (
 	// DefaultPprofEnabled stores the default value "false" for the "pprof.enabled" /debug/pprof debugging api.
 	// If the corresponding key does not exist, the default value is returned.
 	DefaultPprofEnabled FeatureValue = False
+
+	// DefaultDebugEnabled stores the default value "false" for the "debug.enabled" debugging apis.
+	// If the corresponding key does not exist, the default value is returned.
+	DefaultDebugEnabled FeatureValue = False
 )
 
-// defaultFeatureValue defines the default value for the feature switch.
-var defaultFeatureValue = map[string]FeatureValue{
-	PprofEnabledKey: DefaultPprofEnabled,
+func init() {
+	MustRegisterFlags(
+		FlagSpec{
+			Key:         PprofEnabledKey,
+			Type:        FlagTypeBool,
+			Default:     DefaultPprofEnabled,
+			Description: "enables the /debug/pprof debugging api",
+		},
+		FlagSpec{
+			Key:         DebugEnabledKey,
+			Type:        FlagTypeBool,
+			Default:     DefaultDebugEnabled,
+			Description: "enables the /debug/controllers and /debug/features debugging apis",
+		},
+		FlagSpec{
+			Key:         LazyLoaderIntervalKey,
+			Type:        FlagTypeDuration,
+			Description: "interval used by the controllers lazy loader to check pending controllers",
+			Min:         "1s",
+		},
+	)
+}
+
+// Settings of controllers stored in the configuration, see ControllerKey
+const (
+	// ControllerMaxConcurrentReconcilesSetting the maximum number of concurrent reconciles of a controller
+	ControllerMaxConcurrentReconcilesSetting = "maxConcurrentReconciles"
+	// ControllerBaseDelaySetting the base delay of the rate limiter of a controller
+	ControllerBaseDelaySetting = "baseDelay"
+	// ControllerMaxDelaySetting the maximum delay of the rate limiter of a controller
+	ControllerMaxDelaySetting = "maxDelay"
+	// ControllerQPSSetting the overall requeue rate of a controller
+	ControllerQPSSetting = "qps"
+	// ControllerBurstSetting the overall requeue burst of a controller
+	ControllerBurstSetting = "burst"
+	// ControllerIncludeNamespacesSetting the comma separated namespaces watched by a controller, all if empty
+	ControllerIncludeNamespacesSetting = "includeNamespaces"
+	// ControllerExcludeNamespacesSetting the comma separated namespaces ignored by a controller
+	ControllerExcludeNamespacesSetting = "excludeNamespaces"
+	// ControllerTTLAfterFinishedSetting the duration after which finished objects are deleted by a garbage collector
+	ControllerTTLAfterFinishedSetting = "ttlAfterFinished"
+	// ControllerOrphanCheckIntervalSetting the interval at which a garbage collector checks the objects are orphaned
+	ControllerOrphanCheckIntervalSetting = "orphanCheckInterval"
+)
+
+// ControllerKey returns the configuration key of a setting of a controller, e.g. "controller.foo.qps"
+func ControllerKey(name, setting string) string {
+	return fmt.Sprintf("%s%s.%s", ControllerKeyPrefix, name, setting)
+}
+
+// ControllerEnabledKey returns the configuration key used to enable or disable
+// a controller loaded by the controllers lazy loader at runtime, e.g. "controller.foo.enabled".
+// Controllers are enabled if the key is not set.
+func ControllerEnabledKey(name string) string {
+	return ControllerKey(name, "enabled")
 }
 
 // FeatureFlags holds the features configurations
@@ -45,7 +113,7 @@ type FeatureFlags struct {
 
 // FeatureValue returns the value of the implemented feature flag, or the default if not found.
 func (f *FeatureFlags) FeatureValue(flag string) FeatureValue {
-	defaultValue := defaultFeatureValue[flag]
+	defaultValue := DefaultRegistry.Default(flag)
 	if f == nil || f.Data == nil {
 		return defaultValue
 	}
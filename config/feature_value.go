@@ -22,6 +22,7 @@ import (
 	"strconv"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"knative.dev/pkg/logging"
 )
 
@@ -42,6 +43,15 @@ func (f FeatureValue) AsInt() (int, error) {
 	return v, nil
 }
 
+// AsFloat64 returns as a float64, or 0 if the conversion fails.
+func (f FeatureValue) AsFloat64() (float64, error) {
+	v, err := strconv.ParseFloat(f.String(), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed parsing feature flags config %q: %v", f.String(), err)
+	}
+	return v, nil
+}
+
 // AsBool returns as a Duration, or 0 if the conversion fails.
 func (f FeatureValue) AsDuration() (time.Duration, error) {
 	v, err := time.ParseDuration(f.String())
@@ -60,6 +70,15 @@ func (f FeatureValue) AsBool() (bool, error) {
 	return v, nil
 }
 
+// AsQuantity returns as a resource Quantity, e.g. "100Mi", or a zero Quantity if the conversion fails.
+func (f FeatureValue) AsQuantity() (resource.Quantity, error) {
+	v, err := resource.ParseQuantity(f.String())
+	if err != nil {
+		return resource.Quantity{}, fmt.Errorf("failed parsing feature flags config %q: %v", f.String(), err)
+	}
+	return v, nil
+}
+
 // GetDurationConfig return duration configuration store in manager and return default if not exist
 func GetDurationConfig(ctx context.Context, key string, defaultDuration time.Duration) time.Duration {
 	log := logging.FromContext(ctx)
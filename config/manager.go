@@ -20,7 +20,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/AlaudaDevops/pkg/maps"
 
@@ -30,6 +33,7 @@ import (
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"knative.dev/pkg/system"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -42,6 +46,9 @@ const (
 // Config store katanomi configuration
 type Config struct {
 	Data map[string]string
+
+	// Provenance stores where each value of Data comes from
+	Provenance map[string]Provenance
 }
 
 // GetBool will parse value in Config.Data["key"] to bool
@@ -66,19 +73,58 @@ type Manager struct {
 	// source store mange config source object.
 	configMapRef *corev1.ObjectReference
 
-	watchers []Watcher
+	subscriptions []*Subscription
+	// watchers counts the watchers added to name their subscriptions
+	watchers atomic.Int64
+	// publishLock keeps the order of the changes published to subscriptions
+	publishLock sync.Mutex
 	*Config
+
+	// Registry validates the configuration values and provides default values,
+	// DefaultRegistry is used if not set
+	Registry *Registry
+
+	// layers stores the values of each configuration source merged into Config
+	layers [layerCount]*configLayer
+	// namespaces stores the override values of each namespace
+	namespaces map[string]*configLayer
+	// bindings stores the structs bound to the configuration by type, see Bind
+	bindings map[reflect.Type]any
+
+	overlayName           string
+	secretName            string
+	namespaceOverrideName string
+	client                kubernetes.Interface
+}
+
+// rejectedValue is an invalid value set in the configuration
+type rejectedValue struct {
+	value FeatureValue
+	err   error
+}
+
+func (manager *Manager) registry() *Registry {
+	if manager == nil || manager.Registry == nil {
+		return DefaultRegistry
+	}
+	return manager.Registry
 }
 
-// NewManager will instantiate a manager that watch configmap for core component configuration
-func NewManager(informer watcher.DefaultingWatcherWithOnChange, logger *zap.SugaredLogger, cmName string) *Manager {
+// NewManager will instantiate a manager that watch configmap for core component configuration.
+// Additional configuration sources can be layered on top of the configmap using options,
+// see WithOverlayConfigMap, WithSecret and WithNamespaceOverrides.
+func NewManager(informer watcher.DefaultingWatcherWithOnChange, logger *zap.SugaredLogger, cmName string, opts ...ManagerOption) *Manager {
 	manager := Manager{
 		Informer: informer,
 		Logger:   logger,
 		Config: &Config{
-			Data: make(map[string]string),
+			Data:       make(map[string]string),
+			Provenance: make(map[string]Provenance),
 		},
 	}
+	for _, opt := range opts {
+		opt(&manager)
+	}
 	coreCM := &corev1.ConfigMap{}
 	coreCM.Namespace = system.Namespace()
 	coreCM.Name = cmName
@@ -92,6 +138,14 @@ func NewManager(informer watcher.DefaultingWatcherWithOnChange, logger *zap.Suga
 	watcher.AddWatch(coreCM.GetName(), configmap.NewConfigConstructor(coreCM, func(cm *corev1.ConfigMap) {
 		manager.applyConfig(cm)
 	}))
+	if manager.overlayName != "" {
+		overlayCM := &corev1.ConfigMap{}
+		overlayCM.Namespace = coreCM.Namespace
+		overlayCM.Name = manager.overlayName
+		watcher.AddWatch(overlayCM.GetName(), configmap.NewConfigConstructor(overlayCM, func(cm *corev1.ConfigMap) {
+			manager.applyLayer(layerOverlay, cm.Data)
+		}))
+	}
 	watcher.Run()
 	return &manager
 }
@@ -104,11 +158,13 @@ func (manager *Manager) isSameConfigMap(obj metav1.Object) bool {
 }
 
 // AddWatcher add a watcher to manager
-// the watcher will be called when the configmap is changed
+// the watcher will be called in order each time the configuration is updated,
+// use Subscribe to be notified only when keys of interest change.
 func (manager *Manager) AddWatcher(w Watcher) {
-	manager.lock.Lock()
-	defer manager.lock.Unlock()
-	manager.watchers = append(manager.watchers, w)
+	name := fmt.Sprintf("watcher-%d", manager.watchers.Add(1)-1)
+	manager.Subscribe(name, func(change ConfigChange) {
+		w.Watch(change.New)
+	}, withEveryUpdate())
 }
 
 // GetConfig will return the config of manager
@@ -122,11 +178,12 @@ func (manager *Manager) GetConfig() *Config {
 }
 
 // GetFeatureFlagByClient get the config configuration by requesting configmap from the client.
+// Only the base configmap is requested, the other configuration sources are ignored.
 // prioritize the use of GetFeatureFlag, and use the current function in scenarios that require high real-time data.
 func (manager *Manager) GetFeatureFlagByClient(ctx context.Context, flag string) FeatureValue {
 	if manager == nil || manager.configMapRef == nil {
 		// returns the default value of flag.
-		return getFeatureFlag(flag, nil)
+		return getFeatureFlag(flag, nil, manager.registry())
 	}
 
 	clt := kclient.Client(ctx)
@@ -141,24 +198,23 @@ func (manager *Manager) GetFeatureFlagByClient(ctx context.Context, flag string)
 		// When getting configmap and reporting an error, it behaves the same as GetFeatureFlag.
 		return manager.GetFeatureFlag(flag)
 	}
-	return getFeatureFlag(flag, &Config{Data: cm.Data})
+	return getFeatureFlag(flag, &Config{Data: cm.Data}, manager.registry())
 }
 
 // GetFeatureFlag get the function switch data, if the function switch is not set,
 // return the default value of the switch.
 func (manager *Manager) GetFeatureFlag(flag string) FeatureValue {
-	defaultValue := defaultFeatureValue[flag]
 	if manager == nil {
-		return defaultValue
+		return DefaultRegistry.Default(flag)
 	}
 
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
-	return getFeatureFlag(flag, manager.Config)
+	return getFeatureFlag(flag, manager.Config, manager.registry())
 }
 
-func getFeatureFlag(flag string, config *Config) FeatureValue {
-	defaultValue := defaultFeatureValue[flag]
+func getFeatureFlag(flag string, config *Config, registry *Registry) FeatureValue {
+	defaultValue := registry.Default(flag)
 	if config == nil || config.Data == nil {
 		return defaultValue
 	}
@@ -181,22 +237,83 @@ func (manager *Manager) applyConfig(cm *corev1.ConfigMap) {
 		return
 	}
 
-	newConfig := &Config{
-		Data: cm.Data,
+	manager.applyLayer(layerBase, cm.Data)
+}
+
+// EffectiveFlag describes the value of a flag currently used
+type EffectiveFlag struct {
+	FlagSpec   `json:",inline"`
+	Provenance `json:",inline"`
+
+	// Registered is false for keys set in the configuration without a registered spec
+	Registered bool `json:"registered"`
+	// Value is the effective value of the flag, values read from secrets are redacted
+	Value FeatureValue `json:"value"`
+	// RejectedValue is the invalid value set in the configuration, if any
+	RejectedValue FeatureValue `json:"rejectedValue,omitempty"`
+	// Error is the validation error of the rejected value
+	Error string `json:"error,omitempty"`
+}
+
+// EffectiveFlags returns the effective values of all registered flags
+// and of the keys set in the configuration, sorted by key
+func (manager *Manager) EffectiveFlags() []EffectiveFlag {
+	return manager.EffectiveFlagsForNamespace("")
+}
+
+// EffectiveFlagsForNamespace returns the effective values of flags for a namespace
+// including the values of its override configmap, see EffectiveFlags.
+func (manager *Manager) EffectiveFlagsForNamespace(namespace string) []EffectiveFlag {
+	registry := manager.registry()
+	var layers []configLayer
+	if manager != nil {
+		manager.lock.Lock()
+		for i := range manager.layers {
+			layers = append(layers, *manager.layer(i))
+		}
+		if layer, ok := manager.namespaces[namespace]; ok && namespace != "" {
+			layers = append(layers, *layer)
+		}
+		manager.lock.Unlock()
 	}
 
-	manager.lock.Lock()
-	defer manager.lock.Unlock()
-	if len(manager.watchers) > 0 {
-		watchers := append([]Watcher{}, manager.watchers...)
-		go func() {
-			for _, f := range watchers {
-				f.Watch(newConfig)
+	flags := map[string]*EffectiveFlag{}
+	get := func(key string) *EffectiveFlag {
+		flag, ok := flags[key]
+		if !ok {
+			flag = &EffectiveFlag{FlagSpec: FlagSpec{Key: key}, Provenance: Provenance{Source: FlagSourceDefault}}
+			flags[key] = flag
+		}
+		return flag
+	}
+	for _, spec := range registry.Flags() {
+		flags[spec.Key] = &EffectiveFlag{
+			FlagSpec:   spec,
+			Provenance: Provenance{Source: FlagSourceDefault},
+			Registered: true,
+			Value:      spec.Default,
+		}
+	}
+	for _, layer := range layers {
+		for key, value := range layer.data {
+			flag := get(key)
+			flag.Value, flag.Provenance = FeatureValue(value), layer.provenance
+			if layer.provenance.Source == FlagSourceSecret {
+				flag.Value = redactedValue
 			}
-		}()
+		}
+		for key, value := range layer.rejected {
+			flag := get(key)
+			flag.RejectedValue, flag.Error = value.value, value.err.Error()
+		}
+	}
+
+	result := make([]EffectiveFlag, 0, len(flags))
+	for _, flag := range flags {
+		result = append(result, *flag)
 	}
-	// whole replacement
-	manager.Config = newConfig
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
 }
 
 // Name return config name for configuration
@@ -0,0 +1,350 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// FlagType is the type of the value of a feature flag
+type FlagType string
+
+const (
+	// FlagTypeBool flag value is a bool, e.g. "true"
+	FlagTypeBool FlagType = "bool"
+	// FlagTypeInt flag value is an integer, e.g. "10"
+	FlagTypeInt FlagType = "int"
+	// FlagTypeFloat flag value is a floating point number, e.g. "0.5"
+	FlagTypeFloat FlagType = "float"
+	// FlagTypeDuration flag value is a duration, e.g. "30s"
+	FlagTypeDuration FlagType = "duration"
+	// FlagTypeEnum flag value is one of the values declared in FlagSpec.Enum
+	FlagTypeEnum FlagType = "enum"
+	// FlagTypeString flag value is any string
+	FlagTypeString FlagType = "string"
+	// FlagTypeQuantity flag value is a resource quantity, e.g. "100Mi"
+	FlagTypeQuantity FlagType = "quantity"
+)
+
+// FlagSpec describes a feature flag stored in the configuration
+type FlagSpec struct {
+	// Key of the flag in the configuration
+	Key string `json:"key"`
+	// Type of the flag value
+	Type FlagType `json:"type"`
+	// Default value used when the key is not set or its value is invalid.
+	// An empty default means the flag is not set by default.
+	Default FeatureValue `json:"default"`
+	// Description of the flag
+	Description string `json:"description,omitempty"`
+	// Min is the inclusive minimum of int, float, duration and quantity flags, empty means no minimum
+	Min FeatureValue `json:"min,omitempty"`
+	// Max is the inclusive maximum of int, float, duration and quantity flags, empty means no maximum
+	Max FeatureValue `json:"max,omitempty"`
+	// Enum lists the allowed values of enum flags
+	Enum []string `json:"enum,omitempty"`
+}
+
+// Validate checks if the value is valid for the flag
+func (s FlagSpec) Validate(value FeatureValue) error {
+	switch s.Type {
+	case FlagTypeBool:
+		_, err := value.AsBool()
+		return err
+	case FlagTypeString:
+		return nil
+	case FlagTypeEnum:
+		for _, allowed := range s.Enum {
+			if value.String() == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid value %q, allowed values are %v", value, s.Enum)
+	case FlagTypeInt, FlagTypeFloat, FlagTypeDuration, FlagTypeQuantity:
+		return s.validateRange(value)
+	default:
+		return fmt.Errorf("unknown flag type %q", s.Type)
+	}
+}
+
+// validateRange parses and compares the value with Min and Max
+func (s FlagSpec) validateRange(value FeatureValue) error {
+	compare, err := s.comparer()
+	if err != nil {
+		return err
+	}
+	if _, err = compare(value, value); err != nil {
+		return err
+	}
+	if s.Min != "" {
+		if result, err := compare(value, s.Min); err != nil {
+			return err
+		} else if result < 0 {
+			return fmt.Errorf("invalid value %q, should be greater than or equal to %q", value, s.Min)
+		}
+	}
+	if s.Max != "" {
+		if result, err := compare(value, s.Max); err != nil {
+			return err
+		} else if result > 0 {
+			return fmt.Errorf("invalid value %q, should be less than or equal to %q", value, s.Max)
+		}
+	}
+	return nil
+}
+
+// comparer returns a function comparing two values of the flag type
+func (s FlagSpec) comparer() (func(a, b FeatureValue) (int, error), error) {
+	switch s.Type {
+	case FlagTypeInt:
+		return compareWith(FeatureValue.AsInt, cmp.Compare[int]), nil
+	case FlagTypeFloat:
+		return compareWith(FeatureValue.AsFloat64, cmp.Compare[float64]), nil
+	case FlagTypeDuration:
+		return compareWith(FeatureValue.AsDuration, cmp.Compare[time.Duration]), nil
+	case FlagTypeQuantity:
+		return compareWith(FeatureValue.AsQuantity, func(a, b resource.Quantity) int { return a.Cmp(b) }), nil
+	default:
+		return nil, fmt.Errorf("flag type %q does not support ranges", s.Type)
+	}
+}
+
+func compareWith[T any](parse func(FeatureValue) (T, error), compare func(a, b T) int) func(a, b FeatureValue) (int, error) {
+	return func(a, b FeatureValue) (int, error) {
+		parsedA, err := parse(a)
+		if err != nil {
+			return 0, err
+		}
+		parsedB, err := parse(b)
+		if err != nil {
+			return 0, err
+		}
+		return compare(parsedA, parsedB), nil
+	}
+}
+
+// validateSpec checks the spec itself is consistent
+func (s FlagSpec) validateSpec() error {
+	if s.Key == "" {
+		return fmt.Errorf("flag key is empty")
+	}
+	switch s.Type {
+	case FlagTypeBool, FlagTypeString, FlagTypeInt, FlagTypeFloat, FlagTypeDuration, FlagTypeQuantity:
+	case FlagTypeEnum:
+		if len(s.Enum) == 0 {
+			return fmt.Errorf("enum flag %q does not declare any value", s.Key)
+		}
+	default:
+		return fmt.Errorf("flag %q: unknown flag type %q", s.Key, s.Type)
+	}
+	if s.Min != "" || s.Max != "" {
+		compare, err := s.comparer()
+		if err != nil {
+			return fmt.Errorf("flag %q: %w", s.Key, err)
+		}
+		for _, bound := range []FeatureValue{s.Min, s.Max} {
+			if _, err := compare(bound, bound); bound != "" && err != nil {
+				return fmt.Errorf("flag %q: invalid range: %w", s.Key, err)
+			}
+		}
+		if s.Min != "" && s.Max != "" {
+			if result, _ := compare(s.Min, s.Max); result > 0 {
+				return fmt.Errorf("flag %q: min %q is greater than max %q", s.Key, s.Min, s.Max)
+			}
+		}
+	}
+	if s.Default != "" {
+		if err := s.Validate(s.Default); err != nil {
+			return fmt.Errorf("flag %q: invalid default: %w", s.Key, err)
+		}
+	}
+	return nil
+}
+
+// Registry stores the specs of the known feature flags
+// used to validate the configuration and to return default values
+type Registry struct {
+	lock  sync.RWMutex
+	flags map[string]FlagSpec
+}
+
+// NewRegistry returns an empty flag registry
+func NewRegistry() *Registry {
+	return &Registry{flags: map[string]FlagSpec{}}
+}
+
+// DefaultRegistry is the registry used by the config Manager unless specified otherwise
+var DefaultRegistry = NewRegistry()
+
+// RegisterFlags registers flags in the DefaultRegistry
+func RegisterFlags(specs ...FlagSpec) error {
+	return DefaultRegistry.Register(specs...)
+}
+
+// MustRegisterFlags registers flags in the DefaultRegistry and panics on error,
+// mostly used during package initialization
+func MustRegisterFlags(specs ...FlagSpec) {
+	DefaultRegistry.MustRegister(specs...)
+}
+
+// Register adds flags to the registry.
+// Registering the same spec twice is allowed, registering a different spec with the same key is an error.
+func (r *Registry) Register(specs ...FlagSpec) error {
+	for _, spec := range specs {
+		if err := spec.validateSpec(); err != nil {
+			return err
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, spec := range specs {
+		if existing, ok := r.flags[spec.Key]; ok && !reflect.DeepEqual(existing, spec) {
+			return fmt.Errorf("flag %q is already registered with a different spec", spec.Key)
+		}
+	}
+	for _, spec := range specs {
+		r.flags[spec.Key] = spec
+	}
+	return nil
+}
+
+// MustRegister adds flags to the registry and panics on error
+func (r *Registry) MustRegister(specs ...FlagSpec) {
+	if err := r.Register(specs...); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the spec of a flag
+func (r *Registry) Lookup(key string) (FlagSpec, bool) {
+	if r == nil {
+		return FlagSpec{}, false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	spec, ok := r.flags[key]
+	return spec, ok
+}
+
+// Flags returns all registered flags sorted by key
+func (r *Registry) Flags() []FlagSpec {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	specs := make([]FlagSpec, 0, len(r.flags))
+	for _, spec := range r.flags {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Key < specs[j].Key })
+	return specs
+}
+
+// Default returns the default value of a flag, or empty if the flag is not registered
+func (r *Registry) Default(key string) FeatureValue {
+	spec, _ := r.Lookup(key)
+	return spec.Default
+}
+
+// Validate validates the value of a flag, values of unregistered flags are always valid
+func (r *Registry) Validate(key string, value FeatureValue) error {
+	spec, ok := r.Lookup(key)
+	if !ok {
+		return nil
+	}
+	return spec.Validate(value)
+}
+
+// ValidateData validates all values of a configuration
+// returning errors indexed by key, or nil if all values are valid
+func (r *Registry) ValidateData(data map[string]string) map[string]error {
+	var errs map[string]error
+	for key, value := range data {
+		if err := r.Validate(key, FeatureValue(value)); err != nil {
+			if errs == nil {
+				errs = map[string]error{}
+			}
+			errs[key] = err
+		}
+	}
+	return errs
+}
+
+// FlagValueType are the types a feature flag value can be converted to
+type FlagValueType interface {
+	bool | int | float64 | time.Duration | string | resource.Quantity
+}
+
+// GetFlag returns the value of a flag converted to T.
+// Invalid values of registered flags fallback to the default value of the flag.
+func GetFlag[T FlagValueType](manager ManagerInterface, key string) (T, error) {
+	var value FeatureValue
+	if manager == nil {
+		value = DefaultRegistry.Default(key)
+	} else {
+		value = manager.GetFeatureFlag(key)
+	}
+	registry := DefaultRegistry
+	if m, ok := manager.(*Manager); ok {
+		registry = m.registry()
+	}
+	if spec, ok := registry.Lookup(key); ok && spec.Validate(value) != nil {
+		value = spec.Default
+	}
+	return convertFlag[T](value)
+}
+
+// GetFlagFromContext returns the value of a flag converted to T
+// using the config Manager in the context, see GetFlag.
+func GetFlagFromContext[T FlagValueType](ctx context.Context, key string) (T, error) {
+	if manager := ConfigManager(ctx); manager != nil {
+		return GetFlag[T](manager, key)
+	}
+	return GetFlag[T](nil, key)
+}
+
+func convertFlag[T FlagValueType](value FeatureValue) (result T, err error) {
+	var converted any
+	switch any(result).(type) {
+	case bool:
+		converted, err = value.AsBool()
+	case int:
+		converted, err = value.AsInt()
+	case float64:
+		converted, err = value.AsFloat64()
+	case time.Duration:
+		converted, err = value.AsDuration()
+	case resource.Quantity:
+		converted, err = value.AsQuantity()
+	default:
+		converted = value.String()
+	}
+	if err != nil {
+		return result, err
+	}
+	return converted.(T), nil
+}
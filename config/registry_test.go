@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestFlagSpec_Validate(t *testing.T) {
+	tests := map[string]struct {
+		spec    FlagSpec
+		value   FeatureValue
+		wantErr bool
+	}{
+		"valid bool":             {spec: FlagSpec{Type: FlagTypeBool}, value: "true"},
+		"invalid bool":           {spec: FlagSpec{Type: FlagTypeBool}, value: "yes please", wantErr: true},
+		"int in range":           {spec: FlagSpec{Type: FlagTypeInt, Min: "1", Max: "10"}, value: "10"},
+		"int out of range":       {spec: FlagSpec{Type: FlagTypeInt, Min: "1", Max: "10"}, value: "11", wantErr: true},
+		"invalid int":            {spec: FlagSpec{Type: FlagTypeInt}, value: "ten", wantErr: true},
+		"duration below minimum": {spec: FlagSpec{Type: FlagTypeDuration, Min: "1s"}, value: "500ms", wantErr: true},
+		"duration in range":      {spec: FlagSpec{Type: FlagTypeDuration, Min: "1s"}, value: "1m"},
+		"quantity over maximum":  {spec: FlagSpec{Type: FlagTypeQuantity, Max: "1Gi"}, value: "2Gi", wantErr: true},
+		"quantity in range":      {spec: FlagSpec{Type: FlagTypeQuantity, Max: "1Gi"}, value: "512Mi"},
+		"allowed enum":           {spec: FlagSpec{Type: FlagTypeEnum, Enum: []string{"a", "b"}}, value: "b"},
+		"not allowed enum":       {spec: FlagSpec{Type: FlagTypeEnum, Enum: []string{"a", "b"}}, value: "c", wantErr: true},
+		"any string":             {spec: FlagSpec{Type: FlagTypeString}, value: "anything"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			err := tt.spec.Validate(tt.value)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	g := NewGomegaWithT(t)
+	registry := NewRegistry()
+	spec := FlagSpec{Key: "workers", Type: FlagTypeInt, Default: "2", Min: "1"}
+
+	g.Expect(registry.Register(spec)).To(Succeed())
+	// registering the same spec again is allowed
+	g.Expect(registry.Register(spec)).To(Succeed())
+	g.Expect(registry.Register(FlagSpec{Key: "workers", Type: FlagTypeInt, Default: "3"})).NotTo(Succeed())
+
+	g.Expect(registry.Register(FlagSpec{Key: "invalid.default", Type: FlagTypeInt, Default: "0", Min: "1"})).NotTo(Succeed())
+	g.Expect(registry.Register(FlagSpec{Key: "invalid.range", Type: FlagTypeInt, Min: "2", Max: "1"})).NotTo(Succeed())
+	g.Expect(registry.Register(FlagSpec{Key: "bool.range", Type: FlagTypeBool, Min: "1"})).NotTo(Succeed())
+	g.Expect(registry.Register(FlagSpec{Key: "empty.enum", Type: FlagTypeEnum})).NotTo(Succeed())
+	g.Expect(registry.Register(FlagSpec{Key: "unknown", Type: "complex"})).NotTo(Succeed())
+	g.Expect(registry.Register(FlagSpec{Key: "float.range", Type: FlagTypeFloat, Min: "0.5", Max: "0.1"})).NotTo(Succeed())
+
+	g.Expect(registry.Flags()).To(Equal([]FlagSpec{spec}))
+	g.Expect(registry.Default("workers")).To(Equal(FeatureValue("2")))
+	g.Expect(registry.ValidateData(map[string]string{"workers": "0", "other": "x"})).To(HaveKey("workers"))
+}
+
+func TestGetFlag(t *testing.T) {
+	g := NewGomegaWithT(t)
+	registry := NewRegistry()
+	registry.MustRegister(
+		FlagSpec{Key: "workers", Type: FlagTypeInt, Default: "2", Min: "1"},
+		FlagSpec{Key: "timeout", Type: FlagTypeDuration, Default: "1m"},
+		FlagSpec{Key: "memory", Type: FlagTypeQuantity, Default: "128Mi"},
+	)
+	manager := &Manager{
+		Registry: registry,
+		Config:   &Config{Data: map[string]string{"workers": "0", "timeout": "30s"}},
+	}
+
+	// invalid value falls back to the default
+	workers, err := GetFlag[int](manager, "workers")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(workers).To(Equal(2))
+
+	timeout, err := GetFlag[time.Duration](manager, "timeout")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(timeout).To(Equal(30 * time.Second))
+
+	memory, err := GetFlag[resource.Quantity](manager, "memory")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(memory.Equal(resource.MustParse("128Mi"))).To(BeTrue())
+
+	pprof, err := GetFlag[bool](nil, PprofEnabledKey)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pprof).To(BeFalse())
+
+	_, err = GetFlag[int](manager, "timeout")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestManagerRejectsInvalidValues(t *testing.T) {
+	g := NewGomegaWithT(t)
+	registry := NewRegistry()
+	registry.MustRegister(
+		FlagSpec{Key: "workers", Type: FlagTypeInt, Default: "2", Min: "1"},
+		FlagSpec{Key: "mode", Type: FlagTypeEnum, Default: "fast", Enum: []string{"fast", "safe"}},
+	)
+	manager := &Manager{
+		Registry: registry,
+		Logger:   zap.NewNop().Sugar(),
+		Config:   &Config{Data: map[string]string{}},
+	}
+
+	manager.applyConfig(&corev1.ConfigMap{Data: map[string]string{"workers": "4", "other": "x"}})
+	g.Expect(manager.GetFeatureFlag("workers")).To(Equal(FeatureValue("4")))
+
+	// the last valid value is kept, keys without a valid value use the default
+	manager.applyConfig(&corev1.ConfigMap{Data: map[string]string{"workers": "0", "mode": "unknown"}})
+	g.Expect(manager.GetFeatureFlag("workers")).To(Equal(FeatureValue("4")))
+	g.Expect(manager.GetFeatureFlag("mode")).To(Equal(FeatureValue("fast")))
+
+	flags := manager.EffectiveFlags()
+	g.Expect(flags).To(HaveLen(2))
+	g.Expect(flags[0].Key).To(Equal("mode"))
+	g.Expect(flags[0].Source).To(Equal(FlagSourceDefault))
+	g.Expect(flags[0].Value).To(Equal(FeatureValue("fast")))
+	g.Expect(flags[0].RejectedValue).To(Equal(FeatureValue("unknown")))
+	g.Expect(flags[1].Key).To(Equal("workers"))
+	g.Expect(flags[1].Source).To(Equal(FlagSourceConfigMap))
+	g.Expect(flags[1].Value).To(Equal(FeatureValue("4")))
+	g.Expect(flags[1].RejectedValue).To(Equal(FeatureValue("0")))
+	g.Expect(flags[1].Error).NotTo(BeEmpty())
+
+	manager.applyConfig(&corev1.ConfigMap{Data: map[string]string{"other": "y"}})
+	flags = manager.EffectiveFlags()
+	g.Expect(flags).To(HaveLen(3))
+	g.Expect(flags[1]).To(Equal(EffectiveFlag{FlagSpec: FlagSpec{Key: "other"}, Provenance: Provenance{Source: FlagSourceConfigMap}, Value: "y"}))
+}
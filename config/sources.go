@@ -0,0 +1,402 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// FlagSource indicates where the effective value of a flag comes from
+type FlagSource string
+
+const (
+	// FlagSourceDefault the value is the default value of the flag
+	FlagSourceDefault FlagSource = "default"
+	// FlagSourceConfigMap the value is set in the base configmap
+	FlagSourceConfigMap FlagSource = "configmap"
+	// FlagSourceOverlay the value is set in the overlay configmap
+	FlagSourceOverlay FlagSource = "overlay"
+	// FlagSourceSecret the value is set in the secret
+	FlagSourceSecret FlagSource = "secret"
+	// FlagSourceNamespace the value is set in the override configmap of a namespace
+	FlagSourceNamespace FlagSource = "namespace"
+)
+
+// redactedValue replaces values read from secrets when exposed or logged
+const redactedValue = "<redacted>"
+
+// Provenance describes where a configuration value comes from
+type Provenance struct {
+	// Source kind of the value
+	Source FlagSource `json:"source"`
+	// Namespace of the object storing the value, empty for default values
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the object storing the value, empty for default values
+	Name string `json:"name,omitempty"`
+}
+
+// String returns the provenance as source:namespace/name
+func (p Provenance) String() string {
+	if p.Name == "" {
+		return string(p.Source)
+	}
+	return fmt.Sprintf("%s:%s/%s", p.Source, p.Namespace, p.Name)
+}
+
+// ManagerOption configures additional configuration sources of a Manager
+type ManagerOption func(*Manager)
+
+// WithOverlayConfigMap adds an optional ConfigMap in the system namespace
+// whose values override the values of the base ConfigMap
+func WithOverlayConfigMap(name string) ManagerOption {
+	return func(m *Manager) {
+		m.overlayName = name
+	}
+}
+
+// WithSecret adds an optional Secret in the system namespace storing sensitive values,
+// its values override the values of the base and overlay ConfigMaps.
+// Values read from the Secret are redacted in logs and in EffectiveFlags.
+// Requires a kubernetes client, see WithKubeClient.
+func WithSecret(name string) ManagerOption {
+	return func(m *Manager) {
+		m.secretName = name
+	}
+}
+
+// WithNamespaceOverrides adds optional ConfigMaps with the given name in any namespace
+// whose values override the configuration for that namespace only,
+// see GetFeatureFlagForNamespace and WithNamespaceChanges. Requires a kubernetes client, see WithKubeClient.
+func WithNamespaceOverrides(name string) ManagerOption {
+	return func(m *Manager) {
+		m.namespaceOverrideName = name
+	}
+}
+
+// WithKubeClient sets the client used to watch the Secret and the namespace override ConfigMaps
+func WithKubeClient(client kubernetes.Interface) ManagerOption {
+	return func(m *Manager) {
+		m.client = client
+	}
+}
+
+// WithRegistry sets the flag registry used to validate values, see Manager.Registry
+func WithRegistry(registry *Registry) ManagerOption {
+	return func(m *Manager) {
+		m.Registry = registry
+	}
+}
+
+// layers of the merged configuration, from the lowest to the highest priority
+const (
+	layerBase = iota
+	layerOverlay
+	layerSecret
+	layerCount
+)
+
+// configLayer stores the valid values of a configuration source
+type configLayer struct {
+	provenance Provenance
+	data       map[string]string
+	rejected   map[string]rejectedValue
+}
+
+// update validates the values of the source,
+// invalid values are rejected keeping the last valid value of the layer if any
+func (l *configLayer) update(data map[string]string, registry *Registry, logger *zap.SugaredLogger) {
+	valid := make(map[string]string, len(data))
+	rejected := map[string]rejectedValue{}
+	for key, value := range data {
+		err := registry.Validate(key, FeatureValue(value))
+		if err == nil {
+			valid[key] = value
+			continue
+		}
+		lastValue, ok := l.data[key]
+		if ok {
+			valid[key] = lastValue
+		}
+		if l.provenance.Source == FlagSourceSecret {
+			spec, _ := registry.Lookup(key)
+			value, lastValue = redactedValue, redactedValue
+			err = fmt.Errorf("invalid %s value", spec.Type)
+		}
+		rejected[key] = rejectedValue{value: FeatureValue(value), err: err}
+		if logger != nil {
+			logger.Warnw("rejected invalid config value", "source", l.provenance.String(), "key", key, "value", value,
+				"lastValue", lastValue, "err", err)
+		}
+	}
+	l.data, l.rejected = valid, rejected
+}
+
+// layer returns the layer at index, creating it if necessary
+// should be invoked holding the lock
+func (manager *Manager) layer(index int) *configLayer {
+	if manager.layers[index] != nil {
+		return manager.layers[index]
+	}
+	provenance := Provenance{Namespace: manager.systemNamespace()}
+	switch index {
+	case layerBase:
+		provenance.Source = FlagSourceConfigMap
+		if manager.configMapRef != nil {
+			provenance.Name = manager.configMapRef.Name
+		}
+	case layerOverlay:
+		provenance.Source, provenance.Name = FlagSourceOverlay, manager.overlayName
+	case layerSecret:
+		provenance.Source, provenance.Name = FlagSourceSecret, manager.secretName
+	}
+	manager.layers[index] = &configLayer{provenance: provenance}
+	return manager.layers[index]
+}
+
+func (manager *Manager) systemNamespace() string {
+	if manager.configMapRef == nil {
+		return ""
+	}
+	return manager.configMapRef.Namespace
+}
+
+// applyLayer updates the values of a layer and publishes the merged configuration
+func (manager *Manager) applyLayer(index int, data map[string]string) {
+	manager.lock.Lock()
+	oldConfig, newConfig := manager.updateLayer(index, data)
+	subscriptions := append([]*Subscription{}, manager.subscriptions...)
+
+	// the publish lock is taken before releasing the lock to keep the order of the changes,
+	// changes are published without holding the lock so subscriptions can read the configuration
+	manager.publishLock.Lock()
+	defer manager.publishLock.Unlock()
+	manager.lock.Unlock()
+
+	keys := diffConfig(oldConfig, newConfig)
+	for _, subscription := range subscriptions {
+		subscription.publish("", oldConfig, newConfig, keys)
+	}
+}
+
+// updateLayer updates the values of a layer and replaces the merged configuration
+// should be invoked holding the lock
+func (manager *Manager) updateLayer(index int, data map[string]string) (oldConfig, newConfig *Config) {
+	manager.layer(index).update(data, manager.registry(), manager.Logger)
+	newConfig = &Config{
+		Data:       map[string]string{},
+		Provenance: map[string]Provenance{},
+	}
+	for i := range manager.layers {
+		layer := manager.layer(i)
+		for key, value := range layer.data {
+			newConfig.Data[key] = value
+			newConfig.Provenance[key] = layer.provenance
+		}
+	}
+	// whole replacement
+	oldConfig, manager.Config = manager.Config, newConfig
+	return oldConfig, newConfig
+}
+
+// applyNamespaceOverride updates the override values of a namespace, nil data removes the overrides.
+// The configuration of the namespace is published to the subscriptions using WithNamespaceChanges.
+func (manager *Manager) applyNamespaceOverride(namespace string, data map[string]string) {
+	manager.lock.Lock()
+	oldConfig := manager.namespaceConfig(namespace)
+	manager.updateNamespaceOverride(namespace, data)
+	newConfig := manager.namespaceConfig(namespace)
+	subscriptions := append([]*Subscription{}, manager.subscriptions...)
+
+	// see applyLayer
+	manager.publishLock.Lock()
+	defer manager.publishLock.Unlock()
+	manager.lock.Unlock()
+
+	keys := diffConfig(oldConfig, newConfig)
+	for _, subscription := range subscriptions {
+		subscription.publish(namespace, oldConfig, newConfig, keys)
+	}
+}
+
+// updateNamespaceOverride updates the override values of a namespace
+// should be invoked holding the lock
+func (manager *Manager) updateNamespaceOverride(namespace string, data map[string]string) {
+	if data == nil {
+		delete(manager.namespaces, namespace)
+		return
+	}
+	if manager.namespaces == nil {
+		manager.namespaces = map[string]*configLayer{}
+	}
+	layer, ok := manager.namespaces[namespace]
+	if !ok {
+		layer = &configLayer{provenance: Provenance{
+			Source:    FlagSourceNamespace,
+			Namespace: namespace,
+			Name:      manager.namespaceOverrideName,
+		}}
+		manager.namespaces[namespace] = layer
+	}
+	layer.update(data, manager.registry(), manager.Logger)
+}
+
+// namespaceConfig returns the cluster wide configuration with the override values of the namespace
+// should be invoked holding the lock
+func (manager *Manager) namespaceConfig(namespace string) *Config {
+	namespaced := &Config{
+		Data:       map[string]string{},
+		Provenance: map[string]Provenance{},
+	}
+	if manager.Config != nil {
+		for key, value := range manager.Config.Data {
+			namespaced.Data[key] = value
+		}
+		for key, provenance := range manager.Config.Provenance {
+			namespaced.Provenance[key] = provenance
+		}
+	}
+	if layer, ok := manager.namespaces[namespace]; ok {
+		for key, value := range layer.data {
+			namespaced.Data[key] = value
+			namespaced.Provenance[key] = layer.provenance
+		}
+	}
+	return namespaced
+}
+
+// GetFeatureFlagForNamespace returns the value of a flag for a namespace,
+// the override ConfigMap of the namespace is used first if configured using WithNamespaceOverrides,
+// otherwise behaves the same as GetFeatureFlag.
+func (manager *Manager) GetFeatureFlagForNamespace(flag, namespace string) FeatureValue {
+	if manager == nil {
+		return DefaultRegistry.Default(flag)
+	}
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	if layer, ok := manager.namespaces[namespace]; ok {
+		if value, ok := layer.data[flag]; ok {
+			return FeatureValue(value)
+		}
+	}
+	return getFeatureFlag(flag, manager.Config, manager.registry())
+}
+
+// Start watches the Secret and the namespace override ConfigMaps until ctx is done,
+// returns immediately if none is configured.
+// The base and overlay ConfigMaps are watched using the Informer of the Manager.
+func (manager *Manager) Start(ctx context.Context) error {
+	if manager.secretName == "" && manager.namespaceOverrideName == "" {
+		return nil
+	}
+	if manager.client == nil {
+		return fmt.Errorf("a kubernetes client is required to watch the config secret and namespace overrides")
+	}
+
+	var factories []informers.SharedInformerFactory
+	if manager.secretName != "" {
+		factory := informers.NewSharedInformerFactoryWithOptions(manager.client, 0,
+			informers.WithNamespace(manager.systemNamespace()),
+			informers.WithTweakListOptions(nameSelector(manager.secretName)))
+		if _, err := factory.Core().V1().Secrets().Informer().AddEventHandler(manager.secretEventHandler()); err != nil {
+			return err
+		}
+		factories = append(factories, factory)
+	}
+	if manager.namespaceOverrideName != "" {
+		factory := informers.NewSharedInformerFactoryWithOptions(manager.client, 0,
+			informers.WithNamespace(metav1.NamespaceAll),
+			informers.WithTweakListOptions(nameSelector(manager.namespaceOverrideName)))
+		if _, err := factory.Core().V1().ConfigMaps().Informer().AddEventHandler(manager.namespaceOverrideEventHandler()); err != nil {
+			return err
+		}
+		factories = append(factories, factory)
+	}
+
+	for _, factory := range factories {
+		factory.Start(ctx.Done())
+	}
+	<-ctx.Done()
+	for _, factory := range factories {
+		factory.Shutdown()
+	}
+	return nil
+}
+
+func nameSelector(name string) func(*metav1.ListOptions) {
+	return func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	}
+}
+
+func (manager *Manager) secretEventHandler() cache.ResourceEventHandlerFuncs {
+	apply := func(obj interface{}, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		secret, ok := obj.(*corev1.Secret)
+		if !ok || secret.Name != manager.secretName || secret.Namespace != manager.systemNamespace() {
+			return
+		}
+		data := map[string]string{}
+		if !deleted {
+			for key, value := range secret.Data {
+				data[key] = string(value)
+			}
+		}
+		manager.applyLayer(layerSecret, data)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { apply(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { apply(obj, false) },
+		DeleteFunc: func(obj interface{}) { apply(obj, true) },
+	}
+}
+
+func (manager *Manager) namespaceOverrideEventHandler() cache.ResourceEventHandlerFuncs {
+	apply := func(obj interface{}, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		configMap, ok := obj.(*corev1.ConfigMap)
+		if !ok || configMap.Name != manager.namespaceOverrideName || configMap.Namespace == manager.systemNamespace() {
+			return
+		}
+		data := configMap.Data
+		if data == nil {
+			data = map[string]string{}
+		}
+		if deleted {
+			data = nil
+		}
+		manager.applyNamespaceOverride(configMap.Namespace, data)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { apply(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { apply(obj, false) },
+		DeleteFunc: func(obj interface{}) { apply(obj, true) },
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/pkg/configmap/informer"
+	"knative.dev/pkg/system"
+)
+
+func TestManagerLayeredSources(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ns := system.Namespace()
+	client := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: ns},
+			Data:       map[string]string{"a": "base", "b": "base", "c": "base"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cm-overlay", Namespace: ns},
+			Data:       map[string]string{"b": "overlay"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cm-secret", Namespace: ns},
+			Data:       map[string][]byte{"c": []byte("secret")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cm-override", Namespace: "team"},
+			Data:       map[string]string{"a": "team"},
+		},
+	)
+	watcher := informer.NewInformedWatcher(client, ns)
+	manager := NewManager(watcher, zap.NewNop().Sugar(), "cm",
+		WithOverlayConfigMap("cm-overlay"),
+		WithSecret("cm-secret"),
+		WithNamespaceOverrides("cm-override"),
+		WithKubeClient(client),
+		WithRegistry(NewRegistry()),
+	)
+	g.Expect(watcher.Start(ctx.Done())).To(Succeed())
+	go manager.Start(ctx)
+
+	g.Eventually(func() FeatureValue { return manager.GetFeatureFlag("c") }).Should(Equal(FeatureValue("secret")))
+	g.Eventually(func() FeatureValue { return manager.GetFeatureFlag("b") }).Should(Equal(FeatureValue("overlay")))
+	g.Expect(manager.GetFeatureFlag("a")).To(Equal(FeatureValue("base")))
+	g.Expect(manager.GetConfig().Provenance).To(Equal(map[string]Provenance{
+		"a": {Source: FlagSourceConfigMap, Namespace: ns, Name: "cm"},
+		"b": {Source: FlagSourceOverlay, Namespace: ns, Name: "cm-overlay"},
+		"c": {Source: FlagSourceSecret, Namespace: ns, Name: "cm-secret"},
+	}))
+
+	g.Eventually(func() FeatureValue { return manager.GetFeatureFlagForNamespace("a", "team") }).Should(Equal(FeatureValue("team")))
+	g.Expect(manager.GetFeatureFlagForNamespace("b", "team")).To(Equal(FeatureValue("overlay")))
+	g.Expect(manager.GetFeatureFlagForNamespace("a", "other")).To(Equal(FeatureValue("base")))
+
+	flags := manager.EffectiveFlagsForNamespace("team")
+	g.Expect(flags).To(HaveLen(3))
+	g.Expect(flags[0].Provenance).To(Equal(Provenance{Source: FlagSourceNamespace, Namespace: "team", Name: "cm-override"}))
+	g.Expect(flags[2].Value).To(Equal(FeatureValue(redactedValue)))
+
+	// removed sources do not override anymore
+	g.Expect(client.CoreV1().Secrets(ns).Delete(ctx, "cm-secret", metav1.DeleteOptions{})).To(Succeed())
+	g.Eventually(func() FeatureValue { return manager.GetFeatureFlag("c") }).Should(Equal(FeatureValue("base")))
+	g.Expect(client.CoreV1().ConfigMaps("team").Delete(ctx, "cm-override", metav1.DeleteOptions{})).To(Succeed())
+	g.Eventually(func() FeatureValue { return manager.GetFeatureFlagForNamespace("a", "team") }).Should(Equal(FeatureValue("base")))
+}
+
+func TestManagerNamespaceOverrideChanges(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ns := system.Namespace()
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: ns},
+		Data:       map[string]string{"a": "base", "b": "base"},
+	})
+	watcher := informer.NewInformedWatcher(client, ns)
+	manager := NewManager(watcher, zap.NewNop().Sugar(), "cm",
+		WithNamespaceOverrides("cm-override"),
+		WithKubeClient(client),
+		WithRegistry(NewRegistry()),
+	)
+	g.Expect(watcher.Start(ctx.Done())).To(Succeed())
+
+	changes := make(chan ConfigChange, 10)
+	manager.Subscribe("namespaces", func(change ConfigChange) { changes <- change }, WithNamespaceChanges())
+	clusterChanges := make(chan ConfigChange, 10)
+	manager.Subscribe("cluster", func(change ConfigChange) { clusterChanges <- change })
+	go manager.Start(ctx)
+
+	override := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm-override", Namespace: "team"},
+		Data:       map[string]string{"a": "team"},
+	}
+	_, err := client.CoreV1().ConfigMaps("team").Create(ctx, override, metav1.CreateOptions{})
+	g.Expect(err).To(BeNil())
+	var change ConfigChange
+	g.Eventually(changes).Should(Receive(&change))
+	g.Expect(change.Namespace).To(Equal("team"))
+	g.Expect(change.Keys).To(Equal([]string{"a"}))
+	g.Expect(change.Old.Data).To(HaveKeyWithValue("a", "base"))
+	g.Expect(change.New.Data).To(HaveKeyWithValue("a", "team"))
+	g.Expect(change.New.Data).To(HaveKeyWithValue("b", "base"))
+	g.Expect(change.New.Provenance["a"]).To(Equal(Provenance{Source: FlagSourceNamespace, Namespace: "team", Name: "cm-override"}))
+	g.Expect(change.New.Provenance["b"]).To(Equal(Provenance{Source: FlagSourceConfigMap, Namespace: ns, Name: "cm"}))
+
+	// removed overrides fallback to the cluster wide configuration
+	g.Expect(client.CoreV1().ConfigMaps("team").Delete(ctx, "cm-override", metav1.DeleteOptions{})).To(Succeed())
+	g.Eventually(changes).Should(Receive(&change))
+	g.Expect(change.Namespace).To(Equal("team"))
+	g.Expect(change.New.Data).To(HaveKeyWithValue("a", "base"))
+
+	// subscriptions without WithNamespaceChanges only see the cluster wide configuration
+	g.Consistently(clusterChanges, "100ms").ShouldNot(Receive(HaveField("Namespace", Not(BeEmpty()))))
+}
+
+func TestManagerStartWithoutClient(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect((&Manager{}).Start(context.Background())).To(Succeed())
+	g.Expect((&Manager{secretName: "secret"}).Start(context.Background())).NotTo(Succeed())
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultSubscriptionQueueSize is the default number of changes queued for a subscription
+const DefaultSubscriptionQueueSize = 100
+
+var (
+	subscriptionDroppedChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_subscription_dropped_changes_total",
+		Help: "Number of configuration changes dropped because the queue of a non-blocking subscription was full",
+	}, []string{"subscription"})
+	subscriptionCallbackDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "config_subscription_callback_duration_seconds",
+		Help:    "Duration of the callbacks of configuration subscriptions",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"subscription"})
+	subscriptionQueueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "config_subscription_queue_length",
+		Help: "Number of configuration changes waiting to be delivered to a subscription",
+	}, []string{"subscription"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(subscriptionDroppedChanges, subscriptionCallbackDuration, subscriptionQueueLength)
+}
+
+// ConfigChange describes the changes between two versions of the configuration
+type ConfigChange struct {
+	// Namespace is the namespace whose overrides changed, see WithNamespaceChanges,
+	// empty for changes of the cluster wide configuration
+	Namespace string
+	// Old is the previous configuration
+	Old *Config
+	// New is the current configuration
+	New *Config
+	// Keys lists the added, updated and removed keys matching the subscription, sorted
+	Keys []string
+}
+
+// Changed returns true if the key was added, updated or removed
+func (c ConfigChange) Changed(key string) bool {
+	index := sort.SearchStrings(c.Keys, key)
+	return index < len(c.Keys) && c.Keys[index] == key
+}
+
+// OldValue returns the value of the key in the previous configuration
+func (c ConfigChange) OldValue(key string) (value string, ok bool) {
+	if c.Old != nil {
+		value, ok = c.Old.Data[key]
+	}
+	return
+}
+
+// NewValue returns the value of the key in the current configuration
+func (c ConfigChange) NewValue(key string) (value string, ok bool) {
+	if c.New != nil {
+		value, ok = c.New.Data[key]
+	}
+	return
+}
+
+// diffConfig returns the sorted keys whose values differ between both configurations
+func diffConfig(old, new *Config) []string {
+	var oldData, newData map[string]string
+	if old != nil {
+		oldData = old.Data
+	}
+	if new != nil {
+		newData = new.Data
+	}
+	keys := []string{}
+	for key, value := range newData {
+		if oldValue, ok := oldData[key]; !ok || oldValue != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range oldData {
+		if _, ok := newData[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ChangeFunc is the callback of a subscription
+type ChangeFunc func(change ConfigChange)
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*Subscription)
+
+// WithKeys only delivers the changes of the given keys
+func WithKeys(keys ...string) SubscribeOption {
+	return func(s *Subscription) {
+		for _, key := range keys {
+			s.keys[key] = struct{}{}
+		}
+	}
+}
+
+// WithPrefixes only delivers the changes of the keys with any of the given prefixes
+func WithPrefixes(prefixes ...string) SubscribeOption {
+	return func(s *Subscription) {
+		s.prefixes = append(s.prefixes, prefixes...)
+	}
+}
+
+// WithNonBlocking never blocks the manager when delivering changes,
+// changes are dropped when the queue of the given size is full
+func WithNonBlocking(size int) SubscribeOption {
+	return func(s *Subscription) {
+		s.nonBlocking = true
+		if size > 0 {
+			s.size = size
+		}
+	}
+}
+
+// WithNamespaceChanges also delivers the changes of the namespace overrides,
+// see WithNamespaceOverrides. The configurations of the change are the cluster wide configuration
+// with the overrides of ConfigChange.Namespace, changes of the cluster wide configuration
+// are only delivered once with an empty namespace.
+func WithNamespaceChanges() SubscribeOption {
+	return func(s *Subscription) {
+		s.namespaces = true
+	}
+}
+
+// withEveryUpdate delivers every update of the configuration even if no key changed,
+// used to keep the behavior of Watchers
+func withEveryUpdate() SubscribeOption {
+	return func(s *Subscription) {
+		s.everyUpdate = true
+	}
+}
+
+// Subscription delivers configuration changes to a callback in the order they happened.
+// By default the manager waits when the queue of the subscription is full,
+// see WithNonBlocking to drop changes instead.
+type Subscription struct {
+	name        string
+	callback    ChangeFunc
+	keys        map[string]struct{}
+	prefixes    []string
+	nonBlocking bool
+	everyUpdate bool
+	namespaces  bool
+	size        int
+
+	queue     chan ConfigChange
+	done      chan struct{}
+	closeOnce sync.Once
+	manager   *Manager
+}
+
+// Subscribe adds a subscription to the configuration changes, name identifies the subscription in metrics.
+// Without WithKeys or WithPrefixes all changes are delivered.
+// The callback is invoked in a dedicated goroutine until the subscription is cancelled.
+func (manager *Manager) Subscribe(name string, callback ChangeFunc, opts ...SubscribeOption) *Subscription {
+	s := &Subscription{
+		name:     name,
+		callback: callback,
+		keys:     map[string]struct{}{},
+		size:     DefaultSubscriptionQueueSize,
+		done:     make(chan struct{}),
+		manager:  manager,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.queue = make(chan ConfigChange, s.size)
+	go s.run()
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.subscriptions = append(manager.subscriptions, s)
+	return s
+}
+
+// Cancel stops delivering changes to the subscription
+func (s *Subscription) Cancel() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.manager == nil {
+			return
+		}
+		s.manager.lock.Lock()
+		defer s.manager.lock.Unlock()
+		for i, subscription := range s.manager.subscriptions {
+			if subscription == s {
+				s.manager.subscriptions = append(s.manager.subscriptions[:i:i], s.manager.subscriptions[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+// matches returns true if the subscription is interested in the key
+func (s *Subscription) matches(key string) bool {
+	if len(s.keys) == 0 && len(s.prefixes) == 0 {
+		return true
+	}
+	if _, ok := s.keys[key]; ok {
+		return true
+	}
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish queues the change if any of its keys matches the subscription,
+// changes of a namespace are only queued if the subscription uses WithNamespaceChanges
+func (s *Subscription) publish(namespace string, old, new *Config, keys []string) {
+	if namespace != "" && !s.namespaces {
+		return
+	}
+	change := ConfigChange{Namespace: namespace, Old: old, New: new, Keys: []string{}}
+	for _, key := range keys {
+		if s.matches(key) {
+			change.Keys = append(change.Keys, key)
+		}
+	}
+	if len(change.Keys) == 0 && !s.everyUpdate {
+		return
+	}
+
+	if s.nonBlocking {
+		select {
+		case s.queue <- change:
+		case <-s.done:
+		default:
+			subscriptionDroppedChanges.WithLabelValues(s.name).Inc()
+			return
+		}
+	} else {
+		select {
+		case s.queue <- change:
+		case <-s.done:
+			return
+		}
+	}
+	subscriptionQueueLength.WithLabelValues(s.name).Set(float64(len(s.queue)))
+}
+
+func (s *Subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case change := <-s.queue:
+			subscriptionQueueLength.WithLabelValues(s.name).Set(float64(len(s.queue)))
+			start := time.Now()
+			s.callback(change)
+			subscriptionCallbackDuration.WithLabelValues(s.name).Observe(time.Since(start).Seconds())
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newTestManager() *Manager {
+	return &Manager{
+		Registry: NewRegistry(),
+		Logger:   zap.NewNop().Sugar(),
+		Config:   &Config{Data: map[string]string{}},
+	}
+}
+
+func applyData(manager *Manager, data map[string]string) {
+	manager.applyConfig(&corev1.ConfigMap{Data: data})
+}
+
+func TestDiffConfig(t *testing.T) {
+	g := NewGomegaWithT(t)
+	old := &Config{Data: map[string]string{"same": "1", "updated": "1", "removed": "1"}}
+	new := &Config{Data: map[string]string{"same": "1", "updated": "2", "added": "1"}}
+
+	g.Expect(diffConfig(old, new)).To(Equal([]string{"added", "removed", "updated"}))
+	g.Expect(diffConfig(nil, nil)).To(BeEmpty())
+
+	change := ConfigChange{Old: old, New: new, Keys: diffConfig(old, new)}
+	g.Expect(change.Changed("updated")).To(BeTrue())
+	g.Expect(change.Changed("same")).To(BeFalse())
+	value, ok := change.OldValue("removed")
+	g.Expect(value).To(Equal("1"))
+	g.Expect(ok).To(BeTrue())
+	_, ok = change.NewValue("removed")
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestSubscriptionFilters(t *testing.T) {
+	g := NewGomegaWithT(t)
+	manager := newTestManager()
+
+	changes := make(chan ConfigChange, 10)
+	byKey := manager.Subscribe("key", func(change ConfigChange) { changes <- change }, WithKeys("a"))
+	defer byKey.Cancel()
+	prefixed := make(chan ConfigChange, 10)
+	byPrefix := manager.Subscribe("prefix", func(change ConfigChange) { prefixed <- change }, WithPrefixes("controller."))
+	defer byPrefix.Cancel()
+
+	applyData(manager, map[string]string{"a": "1", "b": "1"})
+	g.Eventually(changes).Should(Receive(WithTransform(func(c ConfigChange) []string { return c.Keys }, Equal([]string{"a"}))))
+
+	// unrelated changes are not delivered
+	applyData(manager, map[string]string{"a": "1", "b": "2"})
+	applyData(manager, map[string]string{"a": "1", "b": "2", "controller.foo.enabled": "false"})
+	g.Eventually(prefixed).Should(Receive(WithTransform(func(c ConfigChange) []string { return c.Keys }, Equal([]string{"controller.foo.enabled"}))))
+	g.Consistently(changes).ShouldNot(Receive())
+
+	// cancelled subscriptions do not receive changes
+	byKey.Cancel()
+	applyData(manager, map[string]string{"a": "2"})
+	g.Consistently(changes).ShouldNot(Receive())
+}
+
+func TestSubscriptionInOrder(t *testing.T) {
+	g := NewGomegaWithT(t)
+	manager := newTestManager()
+
+	var (
+		lock     sync.Mutex
+		received []string
+	)
+	subscription := manager.Subscribe("ordered", func(change ConfigChange) {
+		value, _ := change.NewValue("counter")
+		lock.Lock()
+		received = append(received, value)
+		lock.Unlock()
+	}, WithKeys("counter"))
+	defer subscription.Cancel()
+
+	expected := []string{}
+	for i := 0; i < 3*DefaultSubscriptionQueueSize; i++ {
+		applyData(manager, map[string]string{"counter": strconv.Itoa(i)})
+		expected = append(expected, strconv.Itoa(i))
+	}
+	g.Eventually(func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, received...)
+	}).Should(Equal(expected))
+}
+
+func TestSubscriptionNonBlocking(t *testing.T) {
+	g := NewGomegaWithT(t)
+	manager := newTestManager()
+
+	block := make(chan struct{})
+	received := make(chan string, 10)
+	subscription := manager.Subscribe("non-blocking", func(change ConfigChange) {
+		value, _ := change.NewValue("a")
+		received <- value
+		<-block
+	}, WithNonBlocking(1))
+	defer subscription.Cancel()
+
+	applyData(manager, map[string]string{"a": "1"})
+	g.Eventually(received).Should(Receive(Equal("1")))
+	// the callback is blocked, the queue can only store one more change
+	applyData(manager, map[string]string{"a": "2"})
+	applyData(manager, map[string]string{"a": "3"})
+	applyData(manager, map[string]string{"a": "4"})
+	g.Expect(testutil.ToFloat64(subscriptionDroppedChanges.WithLabelValues("non-blocking"))).To(Equal(float64(2)))
+
+	close(block)
+	g.Eventually(received).Should(Receive(Equal("2")))
+	g.Consistently(received).ShouldNot(Receive())
+}
+
+func TestAddWatcher(t *testing.T) {
+	g := NewGomegaWithT(t)
+	manager := newTestManager()
+
+	configs := make(chan *Config, 10)
+	manager.AddWatcher(NewConfigWatcher(func(config *Config) { configs <- config }))
+
+	// watchers are notified on every update
+	applyData(manager, map[string]string{"a": "1"})
+	applyData(manager, map[string]string{"a": "1"})
+	g.Eventually(configs).Should(Receive(HaveField("Data", HaveKeyWithValue("a", "1"))))
+	g.Eventually(configs).Should(Receive(HaveField("Data", HaveKeyWithValue("a", "1"))))
+
+	// names are unique even after subscriptions are canceled
+	other := manager.Subscribe("other", func(ConfigChange) {})
+	manager.AddWatcher(NewConfigWatcher(func(*Config) {}))
+	other.Cancel()
+	manager.AddWatcher(NewConfigWatcher(func(*Config) {}))
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	names := []string{}
+	for _, subscription := range manager.subscriptions {
+		names = append(names, subscription.name)
+	}
+	g.Expect(names).To(Equal([]string{"watcher-0", "watcher-1", "watcher-2"}))
+}
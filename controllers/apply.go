@@ -0,0 +1,264 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+
+	pkgerrors "github.com/AlaudaDevops/pkg/errors"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ApplyResult is the result of CreateOrPatch, CreateOrUpdate and CreateOrApply
+type ApplyResult struct {
+	// Operation is the operation performed on the object: created, updated or unchanged
+	Operation controllerutil.OperationResult
+	// Diff is a human readable diff of the object before and after the operation,
+	// empty if the object was created or unchanged
+	Diff string
+}
+
+// Changed returns true if the object was created or updated
+func (r ApplyResult) Changed() bool {
+	return r.Operation != controllerutil.OperationResultNone
+}
+
+// CreateOrPatch creates the object or patches the existing object so that it matches the desired state.
+// The current object is read into obj and mutate sets the desired fields, fields not set by mutate are kept,
+// including the fields owned by other managers. The object is patched using a merge patch with an
+// optimistic lock, conflicts, objects created concurrently and temporary errors are retried reading the object again.
+func CreateOrPatch(ctx context.Context, clt client.Client, obj client.Object, mutate controllerutil.MutateFn) (result ApplyResult, err error) {
+	if clt == nil || obj == nil {
+		return result, fmt.Errorf("client or obj is nil")
+	}
+	return createOrModify(ctx, clt, obj, mutate, "object patched", func(original client.Object) error {
+		return clt.Patch(ctx, obj, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}))
+	})
+}
+
+// CreateOrUpdate creates the object or updates the existing object so that it matches the desired state,
+// like CreateOrPatch but the whole object is replaced by an update using the resource version read as optimistic lock.
+// Prefer CreateOrPatch or CreateOrApply when the object is also modified by others,
+// as an update drops the fields unknown to the version of the type used by the client.
+func CreateOrUpdate(ctx context.Context, clt client.Client, obj client.Object, mutate controllerutil.MutateFn) (result ApplyResult, err error) {
+	if clt == nil || obj == nil {
+		return result, fmt.Errorf("client or obj is nil")
+	}
+	return createOrModify(ctx, clt, obj, mutate, "object updated", func(client.Object) error {
+		return clt.Update(ctx, obj)
+	})
+}
+
+// createOrModify creates the object or modifies the existing object using modify when mutate changed it,
+// modify is invoked with the object read before mutate
+func createOrModify(ctx context.Context, clt client.Client, obj client.Object, mutate controllerutil.MutateFn, logMessage string, modify func(original client.Object) error) (result ApplyResult, err error) {
+	key := client.ObjectKeyFromObject(obj)
+	err = retry.OnError(retry.DefaultBackoff, retriableApplyError(ctx), func() error {
+		if err := clt.Get(ctx, key, obj); err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			if err := mutateObject(mutate, key, obj); err != nil {
+				return err
+			}
+			if err := clt.Create(ctx, obj); err != nil {
+				return err
+			}
+			result = ApplyResult{Operation: controllerutil.OperationResultCreated}
+			return nil
+		}
+
+		original := obj.DeepCopyObject().(client.Object)
+		if err := mutateObject(mutate, key, obj); err != nil {
+			return err
+		}
+		diff, err := diffObjects(original, obj)
+		if err != nil {
+			return err
+		}
+		if diff == "" {
+			result = ApplyResult{Operation: controllerutil.OperationResultNone}
+			return nil
+		}
+		if err := modify(original); err != nil {
+			return err
+		}
+		result = ApplyResult{Operation: controllerutil.OperationResultUpdated, Diff: diff}
+		return nil
+	})
+	if err == nil && result.Changed() {
+		logging.FromContext(ctx).Debugw(logMessage, "object", key, "operation", result.Operation, "diff", result.Diff)
+	}
+	return result, err
+}
+
+// CreateOrApply creates or updates the object using server-side apply with fieldOwner as field manager,
+// obj should only contain the fields owned by fieldOwner and is updated with the applied object.
+// Fields owned by other managers are respected: applying a different value returns a conflict error
+// unless force is true. Conflicts with other appliers are not retried, temporary errors are.
+func CreateOrApply(ctx context.Context, clt client.Client, obj client.Object, fieldOwner string, force bool) (result ApplyResult, err error) {
+	if clt == nil || obj == nil {
+		return result, fmt.Errorf("client or obj is nil")
+	}
+	if obj.GetObjectKind().GroupVersionKind().Empty() {
+		gvk, err := apiutil.GVKForObject(obj, clt.Scheme())
+		if err != nil {
+			return result, err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+	}
+	opts := []client.PatchOption{client.FieldOwner(fieldOwner)}
+	if force {
+		opts = append(opts, client.ForceOwnership)
+	}
+
+	key := client.ObjectKeyFromObject(obj)
+	desired := obj.DeepCopyObject().(client.Object)
+	err = retry.OnError(retry.DefaultBackoff, retriableApplyError(ctx), func() error {
+		current := desired.DeepCopyObject().(client.Object)
+		exists := true
+		if err := clt.Get(ctx, key, current); err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			exists = false
+		}
+
+		applied := desired.DeepCopyObject().(client.Object)
+		applied.SetManagedFields(nil)
+		if err := clt.Patch(ctx, applied, client.Apply, opts...); err != nil {
+			return err
+		}
+		result = ApplyResult{Operation: controllerutil.OperationResultCreated}
+		if exists {
+			diff, err := diffObjects(current, applied)
+			if err != nil {
+				return err
+			}
+			result = ApplyResult{Operation: controllerutil.OperationResultNone}
+			if diff != "" {
+				result = ApplyResult{Operation: controllerutil.OperationResultUpdated, Diff: diff}
+			}
+		}
+		return copyInto(applied, obj)
+	})
+	if err == nil && result.Changed() {
+		logging.FromContext(ctx).Debugw("object applied", "object", key, "operation", result.Operation, "diff", result.Diff)
+	}
+	return result, err
+}
+
+// IsFieldManagerConflict returns true if the error is a server-side apply conflict with another field manager
+func IsFieldManagerConflict(err error) bool {
+	if !errors.IsConflict(err) {
+		return false
+	}
+	if status, ok := err.(errors.APIStatus); ok && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			if cause.Type == metav1.CauseTypeFieldManagerConflict {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retriableApplyError retries conflicts, objects created concurrently and temporary errors until ctx is done,
+// conflicts with other field managers are not retried
+func retriableApplyError(ctx context.Context) func(error) bool {
+	return func(err error) bool {
+		var mutateErr *mutateError
+		if ctx.Err() != nil || IsFieldManagerConflict(err) || goerrors.As(err, &mutateErr) {
+			return false
+		}
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err) || pkgerrors.IsTemporaryError(err)
+	}
+}
+
+// mutateError is an error of the mutate function, which is not retried
+type mutateError struct {
+	err error
+}
+
+func (e *mutateError) Error() string { return e.err.Error() }
+func (e *mutateError) Unwrap() error { return e.err }
+
+// mutateObject invokes mutate and checks it does not change the name or namespace of the object
+func mutateObject(mutate controllerutil.MutateFn, key client.ObjectKey, obj client.Object) error {
+	if mutate == nil {
+		return nil
+	}
+	if err := mutate(); err != nil {
+		return &mutateError{err: err}
+	}
+	if client.ObjectKeyFromObject(obj) != key {
+		return &mutateError{err: fmt.Errorf("mutate must not change the name or namespace of the object")}
+	}
+	return nil
+}
+
+// diffObjects returns a human readable diff of the objects, ignoring the metadata updated by the server
+func diffObjects(before, after client.Object) (string, error) {
+	beforeContent, err := prunedContent(before)
+	if err != nil {
+		return "", err
+	}
+	afterContent, err := prunedContent(after)
+	if err != nil {
+		return "", err
+	}
+	return cmp.Diff(beforeContent, afterContent), nil
+}
+
+// prunedContent returns the unstructured content of the object without the metadata updated by the server
+func prunedContent(obj client.Object) (map[string]interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	delete(content, "apiVersion")
+	delete(content, "kind")
+	if metadata, ok := content["metadata"].(map[string]interface{}); ok {
+		for _, field := range []string{"resourceVersion", "generation", "managedFields", "creationTimestamp", "uid"} {
+			delete(metadata, field)
+		}
+	}
+	return content, nil
+}
+
+// copyInto copies the content of src into dst of the same type
+func copyInto(src, dst client.Object) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(src)
+	if err != nil {
+		return err
+	}
+	if u, ok := dst.(runtime.Unstructured); ok {
+		u.SetUnstructuredContent(content)
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(content, dst)
+}
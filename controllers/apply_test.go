@@ -0,0 +1,195 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestCreateOrPatch(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+
+	conflicts := 1
+	clt := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, clt client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if conflicts > 0 {
+				conflicts--
+				return apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, obj.GetName(), nil)
+			}
+			return clt.Patch(ctx, obj, patch, opts...)
+		},
+	}).Build()
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "child"}}
+	desired := map[string]string{"a": "1"}
+	mutate := func() error {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		for key, value := range desired {
+			cm.Data[key] = value
+		}
+		return nil
+	}
+
+	result, err := CreateOrPatch(ctx, clt, cm, mutate)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Operation).To(Equal(controllerutil.OperationResultCreated))
+
+	result, err = CreateOrPatch(ctx, clt, cm, mutate)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Changed()).To(BeFalse())
+
+	// fields set by others are kept and conflicts are retried
+	current := &corev1.ConfigMap{}
+	g.Expect(clt.Get(ctx, client.ObjectKeyFromObject(cm), current)).To(Succeed())
+	current.Data["other"] = "kept"
+	g.Expect(clt.Update(ctx, current)).To(Succeed())
+
+	desired["a"] = "2"
+	result, err = CreateOrPatch(ctx, clt, cm, mutate)
+	g.Expect(err).To(BeNil())
+	g.Expect(conflicts).To(Equal(0))
+	g.Expect(result.Operation).To(Equal(controllerutil.OperationResultUpdated))
+	g.Expect(result.Diff).To(ContainSubstring(`"1"`))
+	g.Expect(result.Diff).To(ContainSubstring(`"2"`))
+	g.Expect(clt.Get(ctx, client.ObjectKeyFromObject(cm), current)).To(Succeed())
+	g.Expect(current.Data).To(Equal(map[string]string{"a": "2", "other": "kept"}))
+
+	_, err = CreateOrPatch(ctx, clt, cm, func() error {
+		cm.Name = "renamed"
+		return nil
+	})
+	g.Expect(err).NotTo(BeNil())
+}
+
+func TestCreateOrUpdate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+
+	// another client creates the object between the get and the create
+	created := 0
+	clt := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, clt client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			created++
+			if created == 1 {
+				other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: obj.GetNamespace(), Name: obj.GetName()}, Data: map[string]string{"other": "kept"}}
+				g.Expect(clt.Create(ctx, other)).To(Succeed())
+			}
+			return clt.Create(ctx, obj, opts...)
+		},
+	}).Build()
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "child"}}
+	mutate := func() error {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data["a"] = "1"
+		return nil
+	}
+	result, err := CreateOrUpdate(ctx, clt, cm, mutate)
+	g.Expect(err).To(BeNil())
+	g.Expect(created).To(Equal(1))
+	g.Expect(result.Operation).To(Equal(controllerutil.OperationResultUpdated))
+	g.Expect(result.Diff).To(ContainSubstring(`"1"`))
+
+	current := &corev1.ConfigMap{}
+	g.Expect(clt.Get(ctx, client.ObjectKeyFromObject(cm), current)).To(Succeed())
+	g.Expect(current.Data).To(Equal(map[string]string{"a": "1", "other": "kept"}))
+
+	result, err = CreateOrUpdate(ctx, clt, cm, mutate)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Changed()).To(BeFalse())
+}
+
+func TestCreateOrApply(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+
+	var failure error
+	patches := 0
+	clt := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithInterceptorFuncs(interceptor.Funcs{
+		// the fake client does not support server-side apply, applied data replaces the existing data
+		Patch: func(ctx context.Context, clt client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			patches++
+			g.Expect(patch.Type()).To(Equal(types.ApplyPatchType))
+			if failure != nil {
+				err := failure
+				failure = nil
+				return err
+			}
+			applied := obj.(*corev1.ConfigMap)
+			current := &corev1.ConfigMap{}
+			if err := clt.Get(ctx, client.ObjectKeyFromObject(obj), current); apierrors.IsNotFound(err) {
+				current = applied.DeepCopy()
+				current.ResourceVersion = ""
+				if err := clt.Create(ctx, current); err != nil {
+					return err
+				}
+			} else {
+				current.Data = applied.Data
+				if err := clt.Update(ctx, current); err != nil {
+					return err
+				}
+			}
+			return clt.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		},
+	}).Build()
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "child"}, Data: map[string]string{"a": "1"}}
+	result, err := CreateOrApply(ctx, clt, cm.DeepCopy(), "owner", false)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Operation).To(Equal(controllerutil.OperationResultCreated))
+
+	result, err = CreateOrApply(ctx, clt, cm.DeepCopy(), "owner", false)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Changed()).To(BeFalse())
+
+	// temporary errors are retried
+	failure = apierrors.NewServiceUnavailable("unavailable")
+	cm.Data["a"] = "2"
+	applied := cm.DeepCopy()
+	result, err = CreateOrApply(ctx, clt, applied, "owner", false)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Operation).To(Equal(controllerutil.OperationResultUpdated))
+	g.Expect(result.Diff).NotTo(BeEmpty())
+	g.Expect(applied.ResourceVersion).NotTo(BeEmpty())
+
+	// conflicts with other field managers are not retried
+	patches = 0
+	failure = apierrors.NewApplyConflict([]metav1.StatusCause{{
+		Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "other"`, Field: ".data.a",
+	}}, "conflict")
+	_, err = CreateOrApply(ctx, clt, cm.DeepCopy(), "owner", false)
+	g.Expect(IsFieldManagerConflict(err)).To(BeTrue())
+	g.Expect(patches).To(Equal(1))
+}
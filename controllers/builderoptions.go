@@ -61,3 +61,12 @@ func RateLimiter(rl workqueue.TypedRateLimiter[reconcile.Request]) BuilderOption
 		return options
 	}
 }
+
+// NeedLeaderElection sets whether the controller needs leader election,
+// controllers sharing their work across replicas, e.g. using sharding, should not need it
+func NeedLeaderElection(need bool) BuilderOptionFunc {
+	return func(options controller.Options) controller.Options {
+		options.NeedLeaderElection = &need
+		return options
+	}
+}
@@ -21,6 +21,7 @@ import (
 
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type rateLimiterKey struct{}
@@ -56,6 +57,19 @@ func ReconcileRequestCtx(ctx context.Context) ctrl.Request {
 	return val.(ctrl.Request)
 }
 
+type reconcileObjectKey struct{}
+
+// WithReconcileObject stores the object of the reconciled request into context
+func WithReconcileObject(ctx context.Context, obj client.Object) context.Context {
+	return context.WithValue(ctx, reconcileObjectKey{}, obj)
+}
+
+// ReconcileObjectCtx retrieves the object of the reconciled request from context. Returns nil if none
+func ReconcileObjectCtx(ctx context.Context) client.Object {
+	obj, _ := ctx.Value(reconcileObjectKey{}).(client.Object)
+	return obj
+}
+
 // numRequeuesCtxKey returns back how many failures the item has had
 type numRequeuesCtxKey struct{}
 
@@ -69,3 +83,29 @@ func GetNumRequeues(ctx context.Context) int {
 	num, _ := ctx.Value(numRequeuesCtxKey{}).(int)
 	return num
 }
+
+type leaderElectionOptionsKey struct{}
+
+// WithLeaderElectionOptions stores the LeaderElectionOptions of controllers declaring their own election ID into context
+func WithLeaderElectionOptions(ctx context.Context, options LeaderElectionOptions) context.Context {
+	return context.WithValue(ctx, leaderElectionOptionsKey{}, &options)
+}
+
+// LeaderElectionOptionsCtx retrieves the LeaderElectionOptions from context. Returns nil if none
+func LeaderElectionOptionsCtx(ctx context.Context) *LeaderElectionOptions {
+	options, _ := ctx.Value(leaderElectionOptionsKey{}).(*LeaderElectionOptions)
+	return options
+}
+
+type lazyLoaderKey struct{}
+
+// WithLazyLoader stores a LazyLoader into context
+func WithLazyLoader(ctx context.Context, loader LazyLoader) context.Context {
+	return context.WithValue(ctx, lazyLoaderKey{}, loader)
+}
+
+// LazyLoaderCtx retrieves a LazyLoader from context. Returns nil if none
+func LazyLoaderCtx(ctx context.Context) LazyLoader {
+	loader, _ := ctx.Value(lazyLoaderKey{}).(LazyLoader)
+	return loader
+}
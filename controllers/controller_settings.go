@@ -0,0 +1,197 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/AlaudaDevops/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	ctrlmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// DefaultBaseDelay is the default base delay of the per item exponential backoff
+	DefaultBaseDelay = 2 * time.Second
+	// DefaultMaxDelay is the default maximum delay of the per item exponential backoff
+	DefaultMaxDelay = 1000 * time.Second
+	// DefaultQPS is the default overall requeue rate of a controller
+	DefaultQPS = 10
+	// DefaultBurst is the default overall requeue burst of a controller
+	DefaultBurst = 100
+)
+
+// ControllerSettings are the concurrency and rate limiting settings of a controller
+type ControllerSettings struct {
+	// MaxConcurrentReconciles is the maximum number of concurrent reconciles
+	MaxConcurrentReconciles int
+	// BaseDelay is the base delay of the per item exponential backoff
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay of the per item exponential backoff
+	MaxDelay time.Duration
+	// QPS is the overall requeue rate, it only limits the retry speed of the controller
+	QPS float64
+	// Burst is the overall requeue burst
+	Burst int
+}
+
+// DefaultControllerSettings returns the settings used by DefaultOptions
+func DefaultControllerSettings() ControllerSettings {
+	return ControllerSettings{
+		MaxConcurrentReconciles: DefaultMaxConcurrentReconciles,
+		BaseDelay:               DefaultBaseDelay,
+		MaxDelay:                DefaultMaxDelay,
+		QPS:                     DefaultQPS,
+		Burst:                   DefaultBurst,
+	}
+}
+
+// ControllerFlags returns the specs of the settings of the controller,
+// e.g. "controller.<name>.maxConcurrentReconciles", defaulting to DefaultControllerSettings
+func ControllerFlags(name string) []config.FlagSpec {
+	defaults := DefaultControllerSettings()
+	return []config.FlagSpec{
+		{
+			Key:         config.ControllerKey(name, config.ControllerMaxConcurrentReconcilesSetting),
+			Type:        config.FlagTypeInt,
+			Default:     config.FeatureValue(strconv.Itoa(defaults.MaxConcurrentReconciles)),
+			Min:         "1",
+			Description: "Maximum number of concurrent reconciles, applied when the controller is set up",
+		},
+		{
+			Key:         config.ControllerKey(name, config.ControllerBaseDelaySetting),
+			Type:        config.FlagTypeDuration,
+			Default:     config.FeatureValue(defaults.BaseDelay.String()),
+			Min:         "1ms",
+			Description: "Base delay of the per item exponential backoff of the controller",
+		},
+		{
+			Key:         config.ControllerKey(name, config.ControllerMaxDelaySetting),
+			Type:        config.FlagTypeDuration,
+			Default:     config.FeatureValue(defaults.MaxDelay.String()),
+			Min:         "1ms",
+			Description: "Maximum delay of the per item exponential backoff of the controller",
+		},
+		{
+			Key:         config.ControllerKey(name, config.ControllerQPSSetting),
+			Type:        config.FlagTypeFloat,
+			Default:     config.FeatureValue(strconv.FormatFloat(defaults.QPS, 'f', -1, 64)),
+			Min:         "0.01",
+			Description: "Overall requeue rate of the controller",
+		},
+		{
+			Key:         config.ControllerKey(name, config.ControllerBurstSetting),
+			Type:        config.FlagTypeInt,
+			Default:     config.FeatureValue(strconv.Itoa(defaults.Burst)),
+			Min:         "1",
+			Description: "Overall requeue burst of the controller",
+		},
+	}
+}
+
+// GetControllerSettings returns the settings of the controller from the configuration,
+// settings not set or invalid use DefaultControllerSettings
+func GetControllerSettings(manager config.ManagerInterface, name string) ControllerSettings {
+	settings := DefaultControllerSettings()
+	if value, err := config.GetFlag[int](manager, config.ControllerKey(name, config.ControllerMaxConcurrentReconcilesSetting)); err == nil && value > 0 {
+		settings.MaxConcurrentReconciles = value
+	}
+	if value, err := config.GetFlag[time.Duration](manager, config.ControllerKey(name, config.ControllerBaseDelaySetting)); err == nil && value > 0 {
+		settings.BaseDelay = value
+	}
+	if value, err := config.GetFlag[time.Duration](manager, config.ControllerKey(name, config.ControllerMaxDelaySetting)); err == nil && value > 0 {
+		settings.MaxDelay = value
+	}
+	if value, err := config.GetFlag[float64](manager, config.ControllerKey(name, config.ControllerQPSSetting)); err == nil && value > 0 {
+		settings.QPS = value
+	}
+	if value, err := config.GetFlag[int](manager, config.ControllerKey(name, config.ControllerBurstSetting)); err == nil && value > 0 {
+		settings.Burst = value
+	}
+	return settings
+}
+
+// RegisterControllerFlags registers the ControllerFlags of the controller in the registry of manager,
+// DefaultRegistry is used if manager is nil or has no registry.
+// The flags of the controllers added to the app are registered before the controllers are set up.
+func RegisterControllerFlags(manager *config.Manager, name string) error {
+	return registryOf(manager).Register(ControllerFlags(name)...)
+}
+
+// registryOf returns the registry of manager, DefaultRegistry if it has none
+func registryOf(manager *config.Manager) *config.Registry {
+	if manager != nil && manager.Registry != nil {
+		return manager.Registry
+	}
+	return config.DefaultRegistry
+}
+
+// ConfigurableOptions resolves the concurrency and rate limiting of the controller
+// from the configuration of manager, see ControllerFlags for the keys.
+// The rate limiter is updated when the configuration changes while the controller runs in mgr,
+// MaxConcurrentReconciles is only applied when the controller is set up.
+// Should be used after options setting MaxConcurrentReconciles or RateLimiter.
+func ConfigurableOptions(mgr ctrlmanager.Manager, manager *config.Manager, name string) BuilderOptionFunc {
+	return func(options controller.Options) controller.Options {
+		// a conflicting spec only disables the validation of the values
+		if err := RegisterControllerFlags(manager, name); err != nil && manager != nil && manager.Logger != nil {
+			manager.Logger.Warnw("failed to register controller flags", "controller", name, "err", err)
+		}
+
+		settings := GetControllerSettings(manager, name)
+		limiter := NewConfigurableRateLimiter[reconcile.Request](settings)
+		options.MaxConcurrentReconciles = settings.MaxConcurrentReconciles
+		options.RateLimiter = limiter
+		if mgr == nil || manager == nil {
+			return options
+		}
+		// the limiter follows the lifecycle of the controller,
+		// a scoped manager stops it when the controller is unloaded
+		if err := mgr.Add(&rateLimiterUpdater{manager: manager, name: name, limiter: limiter}); err != nil && manager.Logger != nil {
+			manager.Logger.Warnw("failed to watch controller settings", "controller", name, "err", err)
+		}
+		return options
+	}
+}
+
+// rateLimiterUpdater updates the rate limiter of a controller when its settings change
+type rateLimiterUpdater struct {
+	manager *config.Manager
+	name    string
+	limiter *ConfigurableRateLimiter[reconcile.Request]
+}
+
+// Start subscribes to the settings of the controller until ctx is done
+func (u *rateLimiterUpdater) Start(ctx context.Context) error {
+	subscription := u.manager.Subscribe("ratelimiter-"+u.name, func(config.ConfigChange) {
+		u.limiter.Update(GetControllerSettings(u.manager, u.name))
+	}, config.WithPrefixes(config.ControllerKey(u.name, "")))
+	defer subscription.Cancel()
+
+	// settings changed between the setup and the start are applied
+	u.limiter.Update(GetControllerSettings(u.manager, u.name))
+	<-ctx.Done()
+	return nil
+}
+
+// NeedLeaderElection returns false as the limiter is updated on every replica
+func (u *rateLimiterUpdater) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AlaudaDevops/pkg/config"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"knative.dev/pkg/configmap/informer"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestConfigurableOptions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	manager := &config.Manager{
+		Registry: config.NewRegistry(),
+		Config: &config.Config{Data: map[string]string{
+			config.ControllerKey("noisy", config.ControllerMaxConcurrentReconcilesSetting): "2",
+			config.ControllerKey("noisy", config.ControllerBaseDelaySetting):               "5s",
+			config.ControllerKey("noisy", config.ControllerQPSSetting):                     "0.5",
+			config.ControllerKey("noisy", config.ControllerBurstSetting):                   "0",
+		}},
+	}
+	options := BuilderOptions(ConfigurableOptions(nil, manager, "noisy"))
+	g.Expect(options.MaxConcurrentReconciles).To(Equal(2))
+
+	// invalid values fallback to the defaults
+	settings := GetControllerSettings(manager, "noisy")
+	g.Expect(settings).To(Equal(ControllerSettings{
+		MaxConcurrentReconciles: 2,
+		BaseDelay:               5 * time.Second,
+		MaxDelay:                DefaultMaxDelay,
+		QPS:                     0.5,
+		Burst:                   DefaultBurst,
+	}))
+	g.Expect(GetControllerSettings(manager, "other")).To(Equal(DefaultControllerSettings()))
+
+	_, ok := options.RateLimiter.(*ConfigurableRateLimiter[reconcile.Request])
+	g.Expect(ok).To(BeTrue())
+}
+
+// runnablesManager keeps the runnables added to it
+type runnablesManager struct {
+	manager.Manager
+	runnables []manager.Runnable
+}
+
+func (m *runnablesManager) Add(r manager.Runnable) error {
+	m.runnables = append(m.runnables, r)
+	return nil
+}
+
+func TestConfigurableOptionsUpdatesRateLimiter(t *testing.T) {
+	g := NewGomegaWithT(t)
+	t.Setenv("SYSTEM_NAMESPACE", "default")
+
+	watcher := informer.NewInformedWatcher(fake.NewSimpleClientset(), "default")
+	manager := config.NewManager(watcher, zap.NewNop().Sugar(), "cm", config.WithRegistry(config.NewRegistry()))
+	g.Expect(watcher.Start(make(chan struct{}))).To(Succeed())
+	setMaxDelay := func(value string) {
+		watcher.OnChange(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"},
+			Data:       map[string]string{config.ControllerKey("noisy", config.ControllerMaxDelaySetting): value},
+		})
+	}
+
+	mgr := &runnablesManager{}
+	options := BuilderOptions(ConfigurableOptions(mgr, manager, "noisy"))
+	limiter := options.RateLimiter.(*ConfigurableRateLimiter[reconcile.Request])
+	g.Expect(mgr.runnables).To(HaveLen(1))
+	maxDelay := func() time.Duration {
+		limiter.lock.Lock()
+		defer limiter.lock.Unlock()
+		return limiter.maxDelay
+	}
+
+	// changes before the start are applied
+	setMaxDelay("1m")
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- mgr.runnables[0].Start(ctx) }()
+	g.Eventually(maxDelay).Should(Equal(time.Minute))
+
+	setMaxDelay("2m")
+	g.Eventually(maxDelay).Should(Equal(2 * time.Minute))
+
+	// the subscription is canceled when the controller stops
+	cancel()
+	g.Eventually(stopped).Should(Receive(BeNil()))
+	setMaxDelay("3m")
+	g.Consistently(maxDelay, "100ms").Should(Equal(2 * time.Minute))
+}
+
+func TestRegisterControllerFlags(t *testing.T) {
+	g := NewGomegaWithT(t)
+	core, logs := observer.New(zap.WarnLevel)
+	manager := &config.Manager{Registry: config.NewRegistry(), Logger: zap.New(core).Sugar()}
+
+	g.Expect(RegisterControllerFlags(manager, "a")).To(Succeed())
+	_, ok := manager.Registry.Lookup(config.ControllerKey("a", config.ControllerBurstSetting))
+	g.Expect(ok).To(BeTrue())
+	g.Expect(RegisterControllerFlags(manager, "a")).To(Succeed())
+
+	// conflicting specs are reported
+	g.Expect(manager.Registry.Register(config.FlagSpec{
+		Key:  config.ControllerKey("b", config.ControllerBurstSetting),
+		Type: config.FlagTypeString,
+	})).To(Succeed())
+	g.Expect(RegisterControllerFlags(manager, "b")).NotTo(Succeed())
+	BuilderOptions(ConfigurableOptions(nil, manager, "b"))
+	g.Expect(logs.FilterMessage("failed to register controller flags").Len()).To(Equal(1))
+}
+
+func TestConfigurableRateLimiter(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	settings := DefaultControllerSettings()
+	settings.BaseDelay = time.Second
+	settings.MaxDelay = 3 * time.Second
+	limiter := NewConfigurableRateLimiter[reconcile.Request](settings)
+	item := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "a"}}
+
+	g.Expect(limiter.When(item)).To(Equal(time.Second))
+	g.Expect(limiter.When(item)).To(Equal(2 * time.Second))
+	g.Expect(limiter.When(item)).To(Equal(3 * time.Second))
+	g.Expect(limiter.NumRequeues(item)).To(Equal(3))
+
+	limiter.Forget(item)
+	g.Expect(limiter.NumRequeues(item)).To(Equal(0))
+
+	// the bucket limiter throttles all items once the burst is consumed
+	settings.QPS = 0.01
+	settings.Burst = 1
+	limiter.Update(settings)
+	g.Expect(limiter.When(item)).To(Equal(time.Second))
+	g.Expect(limiter.When(reconcile.Request{NamespacedName: types.NamespacedName{Name: "b"}})).To(BeNumerically(">", time.Minute))
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	"github.com/AlaudaDevops/pkg/hash"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// SpecHash returns the hash of the desired state of the object.
+// The state is normalized ignoring the status, the metadata except labels and annotations,
+// and the metav1alpha1.SpecHashAnnotationKey annotation itself.
+func SpecHash(obj client.Object) (string, error) {
+	content, err := desiredContent(obj)
+	if err != nil {
+		return "", err
+	}
+	return hash.ComputeHash(content), nil
+}
+
+// StampSpecHash sets the metav1alpha1.SpecHashAnnotationKey annotation of the desired object to its SpecHash
+func StampSpecHash(obj client.Object) (string, error) {
+	specHash, err := SpecHash(obj)
+	if err != nil {
+		return "", err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[metav1alpha1.SpecHashAnnotationKey] = specHash
+	obj.SetAnnotations(annotations)
+	return specHash, nil
+}
+
+// DriftDetector detects when the live object of a managed child must be applied again.
+// A live object drifted when its hash annotation differs from the hash of the desired object,
+// or when one of the relevant fields set in the desired object has a different value in the live object.
+// Fields not set in the desired object are ignored, so that fields defaulted by the server do not cause drifts.
+type DriftDetector struct {
+	fields *FieldPathChangedPredicate
+}
+
+// NewDriftDetector returns a drift detector comparing the relevant fields selected by JSONPath expressions,
+// e.g. "{.spec.replicas}", in addition to the hash annotation
+func NewDriftDetector(relevantFields ...string) (*DriftDetector, error) {
+	fields, err := NewFieldPathChangedPredicate(relevantFields...)
+	if err != nil {
+		return nil, err
+	}
+	return &DriftDetector{fields: fields}, nil
+}
+
+// Drifted returns true if the live object must be applied again to match the desired object
+func (d *DriftDetector) Drifted(desired, live client.Object) (bool, error) {
+	specHash, err := SpecHash(desired)
+	if err != nil {
+		return false, err
+	}
+	if live.GetAnnotations()[metav1alpha1.SpecHashAnnotationKey] != specHash {
+		return true, nil
+	}
+	if d == nil || d.fields == nil || len(d.fields.paths) == 0 {
+		return false, nil
+	}
+
+	desiredContent, err := unstructuredContent(desired)
+	if err != nil {
+		return false, err
+	}
+	liveContent, err := unstructuredContent(live)
+	if err != nil {
+		return false, err
+	}
+	d.fields.lock.Lock()
+	defer d.fields.lock.Unlock()
+	for _, path := range d.fields.paths {
+		desiredValues, err := findValues(path, desiredContent)
+		if err != nil {
+			return false, err
+		}
+		if len(desiredValues) == 0 {
+			continue
+		}
+		liveValues, err := findValues(path, liveContent)
+		if err != nil {
+			return false, err
+		}
+		if !equality.Semantic.DeepEqual(desiredValues, liveValues) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Predicate returns a predicate accepting updates of children whose hash annotation or relevant fields changed,
+// to be used when watching the children so that manual changes are reverted
+func (d *DriftDetector) Predicate() predicate.Predicate {
+	annotation := AnnotationChangedPredicate{Keys: []string{metav1alpha1.SpecHashAnnotationKey}}
+	if d == nil || d.fields == nil || len(d.fields.paths) == 0 {
+		return annotation
+	}
+	return predicate.Or[client.Object](annotation, d.fields)
+}
+
+// ApplyIfDrifted creates the desired object or patches the live object if it drifted, see DriftDetector.
+// The hash annotation is stamped on desired, and the live object is patched with the labels, annotations
+// and other top level fields of desired, fields set only in the live object are kept.
+// detector may be nil to only compare the hash annotation.
+func ApplyIfDrifted(ctx context.Context, clt client.Client, desired client.Object, detector *DriftDetector) (ApplyResult, error) {
+	if _, err := StampSpecHash(desired); err != nil {
+		return ApplyResult{}, err
+	}
+	content, err := desiredContent(desired)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	// the hash annotation is removed from the content hashed and must be applied
+	setNestedValue(content, desired.GetAnnotations()[metav1alpha1.SpecHashAnnotationKey], "metadata", "annotations", metav1alpha1.SpecHashAnnotationKey)
+
+	live := desired.DeepCopyObject().(client.Object)
+	return CreateOrPatch(ctx, clt, live, func() error {
+		drifted, err := detector.Drifted(desired, live)
+		if err != nil || !drifted {
+			return err
+		}
+		liveContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+		if err != nil {
+			return err
+		}
+		mergeContent(liveContent, content)
+		if u, ok := live.(runtime.Unstructured); ok {
+			u.SetUnstructuredContent(liveContent)
+			return nil
+		}
+		return runtime.DefaultUnstructuredConverter.FromUnstructured(liveContent, live)
+	})
+}
+
+// desiredContent returns the normalized unstructured content of the desired state of the object
+func desiredContent(obj client.Object) (map[string]interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("convert object to unstructured: %w", err)
+	}
+	delete(content, "status")
+	metadata := map[string]interface{}{}
+	if labels := obj.GetLabels(); len(labels) > 0 {
+		metadata["labels"] = toInterfaceMap(labels)
+	}
+	annotations := toInterfaceMap(obj.GetAnnotations())
+	delete(annotations, metav1alpha1.SpecHashAnnotationKey)
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	content["metadata"] = metadata
+	return content, nil
+}
+
+func toInterfaceMap(values map[string]string) map[string]interface{} {
+	result := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+	return result
+}
+
+// setNestedValue sets a value in nested maps, creating the missing maps
+func setNestedValue(content map[string]interface{}, value interface{}, fields ...string) {
+	for _, field := range fields[:len(fields)-1] {
+		nested, ok := content[field].(map[string]interface{})
+		if !ok {
+			nested = map[string]interface{}{}
+			content[field] = nested
+		}
+		content = nested
+	}
+	content[fields[len(fields)-1]] = value
+}
+
+// mergeContent sets the values of src into dst, maps are merged recursively and other values are replaced
+func mergeContent(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeContent(dstMap, srcMap)
+			continue
+		}
+		dst[key] = runtime.DeepCopyJSONValue(value)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestSpecHash(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"app": "a"}}, Data: map[string]string{"a": "1"}}
+	specHash, err := StampSpecHash(cm)
+	g.Expect(err).To(BeNil())
+	g.Expect(cm.Annotations).To(HaveKeyWithValue(metav1alpha1.SpecHashAnnotationKey, specHash))
+
+	// volatile metadata and the hash annotation are ignored
+	live := cm.DeepCopy()
+	live.ResourceVersion = "10"
+	live.UID = "uid"
+	live.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "other"}}
+	g.Expect(SpecHash(live)).To(Equal(specHash))
+
+	live.Data["a"] = "2"
+	g.Expect(SpecHash(live)).NotTo(Equal(specHash))
+}
+
+func TestApplyIfDrifted(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	clt := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build()
+	detector, err := NewDriftDetector("{.data.a}")
+	g.Expect(err).To(BeNil())
+
+	desired := func() *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "child"}, Data: map[string]string{"a": "1"}}
+	}
+	result, err := ApplyIfDrifted(ctx, clt, desired(), detector)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Operation).To(Equal(controllerutil.OperationResultCreated))
+
+	// fields set by the server or by others do not cause a drift
+	live := &corev1.ConfigMap{}
+	g.Expect(clt.Get(ctx, client.ObjectKeyFromObject(desired()), live)).To(Succeed())
+	live.Data["defaulted"] = "value"
+	g.Expect(clt.Update(ctx, live)).To(Succeed())
+	result, err = ApplyIfDrifted(ctx, clt, desired(), detector)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Changed()).To(BeFalse())
+
+	// relevant fields changed manually are reverted
+	g.Expect(clt.Get(ctx, client.ObjectKeyFromObject(desired()), live)).To(Succeed())
+	live.Data["a"] = "manual"
+	g.Expect(clt.Update(ctx, live)).To(Succeed())
+	result, err = ApplyIfDrifted(ctx, clt, desired(), detector)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Operation).To(Equal(controllerutil.OperationResultUpdated))
+	g.Expect(clt.Get(ctx, client.ObjectKeyFromObject(desired()), live)).To(Succeed())
+	g.Expect(live.Data).To(Equal(map[string]string{"a": "1", "defaulted": "value"}))
+
+	// changes of the desired state are applied
+	changed := desired()
+	changed.Data["a"] = "2"
+	result, err = ApplyIfDrifted(ctx, clt, changed, nil)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Operation).To(Equal(controllerutil.OperationResultUpdated))
+	g.Expect(clt.Get(ctx, client.ObjectKeyFromObject(desired()), live)).To(Succeed())
+	g.Expect(live.Data["a"]).To(Equal("2"))
+	g.Expect(live.Annotations[metav1alpha1.SpecHashAnnotationKey]).To(Equal(changed.Annotations[metav1alpha1.SpecHashAnnotationKey]))
+
+	// the zero value only compares the hash annotation
+	drifted, err := (&DriftDetector{}).Drifted(changed, live)
+	g.Expect(err).To(BeNil())
+	g.Expect(drifted).To(BeFalse())
+}
+
+func TestDriftDetectorPredicate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	detector, err := NewDriftDetector("{.data.a}")
+	g.Expect(err).To(BeNil())
+	pred := detector.Predicate()
+
+	old := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{metav1alpha1.SpecHashAnnotationKey: "1"}}, Data: map[string]string{"a": "1"}}
+	other := old.DeepCopy()
+	other.Data["b"] = "2"
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: other})).To(BeFalse())
+
+	relevant := old.DeepCopy()
+	relevant.Data["a"] = "2"
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: relevant})).To(BeTrue())
+
+	removed := old.DeepCopy()
+	removed.Annotations = nil
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: removed})).To(BeTrue())
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	"github.com/AlaudaDevops/pkg/config"
+	"github.com/AlaudaDevops/pkg/references"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// TTLExpiredReason is the reason of the event of an object deleted after its TTL
+	TTLExpiredReason = "TTLExpired"
+	// OrphanedReason is the reason of the event of an object deleted because its logical owner no longer exists
+	OrphanedReason = "Orphaned"
+
+	// DefaultGarbageCollectorDeletionsPerSecond is the default rate of deletions of a garbage collector
+	DefaultGarbageCollectorDeletionsPerSecond = 5
+	// DefaultGarbageCollectorOrphanCheckInterval is the default interval at which
+	// a garbage collector checks the logical owners of an object still exist
+	DefaultGarbageCollectorOrphanCheckInterval = 10 * time.Minute
+)
+
+// GarbageCollector is a controller deleting the objects of a kind after a TTL once they finished,
+// and optionally the objects whose logical owner no longer exists, see references.GetLogicalOwners.
+// It can be added to an app using AppBuilder.Controllers, one collector per kind:
+//
+//	sharedmain.App("controller").Controllers(&controllers.GarbageCollector{
+//		GroupVersionKind: v1alpha1.SchemeGroupVersion.WithKind("PipelineRun"),
+//		TTLAfterFinished: 24 * time.Hour,
+//	})
+//
+// An object finished when one of ConditionTypes in its status is True or False, and the TTL
+// starts from the last transition of this condition. The TTL is resolved in order from the
+// metav1alpha1.TTLAfterFinishedAnnotationKey annotation of the object, the
+// config.ControllerTTLAfterFinishedSetting setting of the controller, and TTLAfterFinished.
+type GarbageCollector struct {
+	// GroupVersionKind of the collected objects
+	GroupVersionKind schema.GroupVersionKind
+	// ControllerName overrides the name of the controller, defaults to "gc-" followed by the group kind
+	ControllerName string
+	// TTLAfterFinished is the default duration after which finished objects are deleted,
+	// zero or negative values only delete the objects with a TTL set by annotation or configuration
+	TTLAfterFinished time.Duration
+	// ConditionTypes are the condition types marking the objects as finished, defaults to Succeeded
+	ConditionTypes []apis.ConditionType
+	// DeleteOrphans deletes the objects whose logical owner in the same cluster no longer exists
+	DeleteOrphans bool
+	// OrphanCheckInterval is the interval at which the logical owners of an object are checked
+	// when DeleteOrphans is true, defaults to DefaultGarbageCollectorOrphanCheckInterval.
+	// Deleting an owner does not trigger the collector, orphans are found by the next check.
+	OrphanCheckInterval time.Duration
+	// DeletionsPerSecond limits the rate of deletions,
+	// defaults to DefaultGarbageCollectorDeletionsPerSecond
+	DeletionsPerSecond float64
+
+	client        client.Client
+	apiReader     client.Reader
+	recorder      record.EventRecorder
+	logger        *zap.SugaredLogger
+	configManager *config.Manager
+	limiter       *rate.Limiter
+	now           func() time.Time
+}
+
+var _ SetupChecker = &GarbageCollector{}
+var _ DependentKindsDeclarer = &GarbageCollector{}
+var _ reconcile.Reconciler = &GarbageCollector{}
+
+// Name returns the name of the controller
+func (c *GarbageCollector) Name() string {
+	if c.ControllerName != "" {
+		return c.ControllerName
+	}
+	return "gc-" + strings.ToLower(c.GroupVersionKind.GroupKind().String())
+}
+
+// DependentKinds returns the collected kind
+func (c *GarbageCollector) DependentKinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{c.GroupVersionKind}
+}
+
+// CheckSetup checks the collected kind is served
+func (c *GarbageCollector) CheckSetup(ctx context.Context, mgr manager.Manager, logger *zap.SugaredLogger) error {
+	_, err := mgr.GetRESTMapper().RESTMapping(c.GroupVersionKind.GroupKind(), c.GroupVersionKind.Version)
+	return err
+}
+
+// Setup adds the controller to the manager
+func (c *GarbageCollector) Setup(ctx context.Context, mgr manager.Manager, logger *zap.SugaredLogger) error {
+	c.init(ctx, mgr.GetClient(), mgr.GetAPIReader(), mgr.GetEventRecorderFor(c.Name()), logger)
+
+	options := BuilderOptions()
+	if c.configManager != nil {
+		if err := registryOf(c.configManager).Register(GarbageCollectorFlags(c.Name())...); err != nil {
+			logger.Warnw("failed to register garbage collector flags", "err", err)
+		}
+		options = BuilderOptions(ConfigurableOptions(mgr, c.configManager, c.Name()))
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(c.Name()).
+		For(c.newObject()).
+		WithOptions(options).
+		Complete(c)
+}
+
+// GarbageCollectorFlags returns the specs of the settings of the garbage collector in addition to ControllerFlags,
+// e.g. "controller.<name>.ttlAfterFinished", not set by default
+func GarbageCollectorFlags(name string) []config.FlagSpec {
+	return []config.FlagSpec{
+		{
+			Key:         config.ControllerKey(name, config.ControllerTTLAfterFinishedSetting),
+			Type:        config.FlagTypeDuration,
+			Min:         "0s",
+			Description: "Duration after which finished objects are deleted, overridden by the object annotation",
+		},
+		{
+			Key:         config.ControllerKey(name, config.ControllerOrphanCheckIntervalSetting),
+			Type:        config.FlagTypeDuration,
+			Min:         "1s",
+			Description: "Interval at which the logical owners of the objects are checked when orphans are deleted",
+		},
+	}
+}
+
+func (c *GarbageCollector) init(ctx context.Context, clt client.Client, apiReader client.Reader, recorder record.EventRecorder, logger *zap.SugaredLogger) {
+	c.client = clt
+	c.apiReader = apiReader
+	c.recorder = recorder
+	c.logger = logger
+	c.configManager = config.ConfigManager(ctx)
+	deletionsPerSecond := c.DeletionsPerSecond
+	if deletionsPerSecond <= 0 {
+		deletionsPerSecond = DefaultGarbageCollectorDeletionsPerSecond
+	}
+	c.limiter = rate.NewLimiter(rate.Limit(deletionsPerSecond), int(deletionsPerSecond)+1)
+	if c.now == nil {
+		c.now = time.Now
+	}
+}
+
+// Reconcile deletes the object if it is orphaned or if its TTL expired,
+// and requeues it until the TTL expires or the next orphan check otherwise
+func (c *GarbageCollector) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := c.logger.With("key", req)
+
+	obj := c.newObject()
+	if err := c.client.Get(ctx, req.NamespacedName, obj); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	result := reconcile.Result{}
+	if c.DeleteOrphans {
+		owner, orphaned, err := c.orphanedBy(ctx, obj)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if orphaned {
+			logger.Infow("deleting orphaned object", "owner", owner)
+			return reconcile.Result{}, c.delete(ctx, obj, OrphanedReason,
+				fmt.Sprintf("Logical owner %s %s no longer exists", owner.Kind, client.ObjectKey{Namespace: owner.Namespace, Name: owner.Name}))
+		}
+		// owner deletions are not watched, the owners are checked again later
+		result.RequeueAfter = c.orphanCheckInterval()
+	}
+
+	ttl, ok := c.ttl(obj, logger)
+	if !ok {
+		return result, nil
+	}
+	finishedAt, finished := c.finishedAt(obj)
+	if !finished {
+		return result, nil
+	}
+	if remaining := finishedAt.Add(ttl).Sub(c.now()); remaining > 0 {
+		if result.RequeueAfter == 0 || remaining < result.RequeueAfter {
+			result.RequeueAfter = remaining
+		}
+		return result, nil
+	}
+	logger.Infow("deleting expired object", "finishedAt", finishedAt, "ttl", ttl)
+	return reconcile.Result{}, c.delete(ctx, obj, TTLExpiredReason, fmt.Sprintf("Finished at %s, TTL %s expired", finishedAt.Format(time.RFC3339), ttl))
+}
+
+func (c *GarbageCollector) newObject() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(c.GroupVersionKind)
+	return obj
+}
+
+// ttl returns the TTL of the object, false if the object has no TTL
+func (c *GarbageCollector) ttl(obj client.Object, logger *zap.SugaredLogger) (time.Duration, bool) {
+	if value, ok := obj.GetAnnotations()[metav1alpha1.TTLAfterFinishedAnnotationKey]; ok {
+		ttl, err := time.ParseDuration(value)
+		if err == nil && ttl >= 0 {
+			return ttl, true
+		}
+		logger.Warnw("invalid ttl annotation", "annotation", metav1alpha1.TTLAfterFinishedAnnotationKey, "value", value)
+	}
+	if c.configManager != nil {
+		ttl, err := config.GetFlag[time.Duration](c.configManager, config.ControllerKey(c.Name(), config.ControllerTTLAfterFinishedSetting))
+		if err == nil && ttl >= 0 {
+			return ttl, true
+		}
+	}
+	return c.TTLAfterFinished, c.TTLAfterFinished > 0
+}
+
+// orphanCheckInterval returns the interval of the orphan checks from the configuration,
+// OrphanCheckInterval or DefaultGarbageCollectorOrphanCheckInterval
+func (c *GarbageCollector) orphanCheckInterval() time.Duration {
+	if c.configManager != nil {
+		interval, err := config.GetFlag[time.Duration](c.configManager, config.ControllerKey(c.Name(), config.ControllerOrphanCheckIntervalSetting))
+		if err == nil && interval > 0 {
+			return interval
+		}
+	}
+	if c.OrphanCheckInterval > 0 {
+		return c.OrphanCheckInterval
+	}
+	return DefaultGarbageCollectorOrphanCheckInterval
+}
+
+// finishedAt returns the last transition time of the first condition in ConditionTypes which is True or False
+func (c *GarbageCollector) finishedAt(obj *unstructured.Unstructured) (time.Time, bool) {
+	resource := &duckv1.KResource{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, resource); err != nil {
+		return time.Time{}, false
+	}
+	conditionTypes := c.ConditionTypes
+	if len(conditionTypes) == 0 {
+		conditionTypes = []apis.ConditionType{apis.ConditionSucceeded}
+	}
+	for _, conditionType := range conditionTypes {
+		condition := resource.Status.GetCondition(conditionType)
+		if condition == nil || condition.IsUnknown() {
+			continue
+		}
+		finishedAt := condition.LastTransitionTime.Inner.Time
+		if finishedAt.IsZero() {
+			finishedAt = obj.GetCreationTimestamp().Time
+		}
+		return finishedAt, true
+	}
+	return time.Time{}, false
+}
+
+// orphanedBy returns the first logical owner in the same cluster which no longer exists.
+// Owners are read from the API server: the cache may not contain owners created recently,
+// and would start an informer for each owner kind.
+func (c *GarbageCollector) orphanedBy(ctx context.Context, obj client.Object) (references.LogicalOwnerReference, bool, error) {
+	owners, err := references.GetLogicalOwners(obj)
+	if err != nil {
+		c.logger.Warnw("invalid logical owners", "key", client.ObjectKeyFromObject(obj), "err", err)
+		return references.LogicalOwnerReference{}, false, nil
+	}
+	for _, owner := range owners {
+		if owner.Cluster != "" {
+			continue
+		}
+		current := &metav1.PartialObjectMetadata{}
+		current.SetGroupVersionKind(schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind))
+		err := c.apiReader.Get(ctx, client.ObjectKey{Namespace: owner.Namespace, Name: owner.Name}, current)
+		if errors.IsNotFound(err) {
+			return owner, true, nil
+		}
+		if meta.IsNoMatchError(err) {
+			// the kind of the owner is not served, it may be installed later
+			c.logger.Debugw("logical owner kind not served", "key", client.ObjectKeyFromObject(obj), "owner", owner)
+			continue
+		}
+		if err != nil {
+			return owner, false, err
+		}
+		if owner.UID != "" && owner.UID != current.GetUID() {
+			return owner, true, nil
+		}
+	}
+	return references.LogicalOwnerReference{}, false, nil
+}
+
+// delete deletes the object respecting the deletion rate and records an event
+func (c *GarbageCollector) delete(ctx context.Context, obj client.Object, reason, message string) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	uid := obj.GetUID()
+	err := c.client.Delete(ctx, obj,
+		client.PropagationPolicy(metav1.DeletePropagationBackground),
+		client.Preconditions{UID: &uid},
+	)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	c.recorder.Event(obj, corev1.EventTypeNormal, reason, message)
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	"github.com/AlaudaDevops/pkg/references"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestGarbageCollector(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	gvk := schema.GroupVersionKind{Group: "test.io", Version: "v1", Kind: "Run"}
+	scheme := runtime.NewScheme()
+	g.Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	scheme.AddKnownTypeWithName(gvk, &duckv1.KResource{})
+
+	run := func(name string, status corev1.ConditionStatus, finishedAgo time.Duration, annotations map[string]string) *duckv1.KResource {
+		obj := &duckv1.KResource{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations}}
+		obj.Status.SetConditions(apis.Conditions{{
+			Type:               apis.ConditionSucceeded,
+			Status:             status,
+			LastTransitionTime: apis.VolatileTime{Inner: metav1.NewTime(now.Add(-finishedAgo))},
+		}})
+		return obj
+	}
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner"}}
+	ownerRef, err := references.NewLogicalOwnerReference(owner, scheme)
+	g.Expect(err).To(BeNil())
+	owned := run("owned", corev1.ConditionUnknown, 0, nil)
+	_, err = references.SetLogicalOwner(owned, ownerRef)
+	g.Expect(err).To(BeNil())
+	orphan := run("orphan", corev1.ConditionUnknown, 0, nil)
+	_, err = references.SetLogicalOwner(orphan, references.LogicalOwnerReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "deleted"})
+	g.Expect(err).To(BeNil())
+	unknownOwner := run("unknown-owner", corev1.ConditionUnknown, 0, nil)
+	_, err = references.SetLogicalOwner(unknownOwner, references.LogicalOwnerReference{APIVersion: "unknown.io/v1", Kind: "Unknown", Namespace: "default", Name: "owner"})
+	g.Expect(err).To(BeNil())
+
+	clt := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		owner,
+		owned,
+		orphan,
+		unknownOwner,
+		run("running", corev1.ConditionUnknown, 0, nil),
+		run("recent", corev1.ConditionTrue, time.Hour, nil),
+		run("expired", corev1.ConditionFalse, 3*time.Hour, nil),
+		run("annotated", corev1.ConditionTrue, time.Hour, map[string]string{metav1alpha1.TTLAfterFinishedAnnotationKey: "30m"}),
+	).WithInterceptorFuncs(interceptor.Funcs{
+		// the fake client does not check the kinds of metadata objects are served
+		Get: func(ctx context.Context, clt client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if gvk := obj.GetObjectKind().GroupVersionKind(); gvk.Group == "unknown.io" {
+				return &meta.NoKindMatchError{GroupKind: gvk.GroupKind(), SearchedVersions: []string{gvk.Version}}
+			}
+			return clt.Get(ctx, key, obj, opts...)
+		},
+	}).Build()
+	recorder := record.NewFakeRecorder(10)
+
+	gc := &GarbageCollector{GroupVersionKind: gvk, TTLAfterFinished: 2 * time.Hour, DeleteOrphans: true, OrphanCheckInterval: 3 * time.Hour, now: func() time.Time { return now }}
+	gc.init(ctx, clt, clt, recorder, zap.NewNop().Sugar())
+	g.Expect(gc.Name()).To(Equal("gc-run.test.io"))
+
+	reconcileRun := func(name string) (reconcile.Result, bool) {
+		key := client.ObjectKey{Namespace: "default", Name: name}
+		result, err := gc.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		g.Expect(err).To(BeNil())
+		err = clt.Get(ctx, key, &duckv1.KResource{})
+		g.Expect(err == nil || apierrors.IsNotFound(err)).To(BeTrue())
+		return result, apierrors.IsNotFound(err)
+	}
+
+	// the owners are checked again after the interval
+	for _, name := range []string{"running", "owned", "unknown-owner"} {
+		result, deleted := reconcileRun(name)
+		g.Expect(deleted).To(BeFalse(), name)
+		g.Expect(result.RequeueAfter).To(Equal(3*time.Hour), name)
+	}
+
+	// the earliest of the TTL and the orphan check is used
+	result, deleted := reconcileRun("recent")
+	g.Expect(deleted).To(BeFalse())
+	g.Expect(result.RequeueAfter).To(Equal(time.Hour))
+	gc.OrphanCheckInterval = 0
+	result, _ = reconcileRun("recent")
+	g.Expect(result.RequeueAfter).To(Equal(DefaultGarbageCollectorOrphanCheckInterval))
+	gc.DeleteOrphans = false
+	result, _ = reconcileRun("running")
+	g.Expect(result.RequeueAfter).To(BeZero())
+	gc.DeleteOrphans = true
+
+	for _, name := range []string{"expired", "annotated"} {
+		_, deleted := reconcileRun(name)
+		g.Expect(deleted).To(BeTrue(), name)
+		g.Expect(recorder.Events).To(Receive(ContainSubstring(TTLExpiredReason)))
+	}
+
+	_, deleted = reconcileRun("orphan")
+	g.Expect(deleted).To(BeTrue())
+	g.Expect(recorder.Events).To(Receive(ContainSubstring(OrphanedReason)))
+
+	// objects already deleted are ignored
+	_, err = gc.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKey{Namespace: "default", Name: "orphan"}})
+	g.Expect(err).To(BeNil())
+}
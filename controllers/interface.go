@@ -19,8 +19,10 @@ package controllers
 
 import (
 	"context"
+	"net/http"
 
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
@@ -30,6 +32,15 @@ type LazyLoader interface {
 	Start(context.Context) error
 }
 
+// LazyLoaderStatusReporter lazy loaders implementing this interface report the loading status of controllers
+type LazyLoaderStatusReporter interface {
+	// Status returns the loading status of all controllers
+	Status() LazyLoaderStatus
+	// ReadyzCheck returns an error until all controllers implementing
+	// RequiredController are loaded, can be used as a healthz.Checker
+	ReadyzCheck(req *http.Request) error
+}
+
 // Interface is a basic interface that every reconciler should implement to create
 // a new controller and startup in the controller manager
 type Interface interface {
@@ -43,3 +54,16 @@ type SetupChecker interface {
 	Interface
 	CheckSetup(context.Context, manager.Manager, *zap.SugaredLogger) error
 }
+
+// DependentKindsDeclarer controllers implementing this interface declare the kinds they watch.
+// The LazyLoader checks them as soon as a CRD serving any of these kinds is established,
+// stops them when the CRD is removed and sets them up again once it is installed back.
+type DependentKindsDeclarer interface {
+	DependentKinds() []schema.GroupVersionKind
+}
+
+// RequiredController controllers implementing this interface and returning true
+// will keep the app not ready until they are loaded by the LazyLoader
+type RequiredController interface {
+	RequiredForReadiness() bool
+}
@@ -18,30 +18,62 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/AlaudaDevops/pkg/config"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
+// DefaultLazyLoaderMaxBackoff the maximum duration between two checks of the same pending controller
+const DefaultLazyLoaderMaxBackoff = 10 * time.Minute
+
 // controllerLazyLoader implementation of LazyLoader
 type controllerLazyLoader struct {
 	ctx context.Context
 	mgr manager.Manager
 	*zap.SugaredLogger
-	interval time.Duration
-	pending  []lazyItem
-	done     []lazyItem
+	interval   time.Duration
+	maxBackoff time.Duration
+
+	lock    sync.RWMutex
+	pending []*lazyItem
+	done    []*lazyItem
+	// runCtx is the context given to Start, used to run scoped controllers
+	runCtx context.Context
+	// recheck is notified when the configuration of the config manager changes
+	// or a controller finished stopping, to check pending controllers again
+	recheck chan struct{}
+	// removedKinds are the kinds of removed CRDs which are not established again,
+	// guarded by removedLock as they are read while lock is held
+	removedLock  sync.RWMutex
+	removedKinds map[schema.GroupKind]struct{}
 }
 
+var _ LazyLoaderStatusReporter = &controllerLazyLoader{}
+
 // NewLazyLoader constructs new LazyLoader for controllers
+// interval is used to check pending controllers and can be overridden by
+// the config.LazyLoaderIntervalKey key of the config manager in the context.
+// Each pending controller is checked with an exponential backoff based on interval,
+// controllers implementing DependentKindsDeclarer are also checked whenever a CRD
+// serving their kinds is established.
+// Loaded controllers run in their own context and can be disabled and enabled again at runtime
+// using the config.ControllerEnabledKey key of the config manager.
 func NewLazyLoader(ctx context.Context, interval time.Duration) LazyLoader {
 	return &controllerLazyLoader{
 		interval:      interval,
+		maxBackoff:    DefaultLazyLoaderMaxBackoff,
 		ctx:           ctx,
-		pending:       []lazyItem{},
-		done:          []lazyItem{},
+		pending:       []*lazyItem{},
+		done:          []*lazyItem{},
+		recheck:       make(chan struct{}, 1),
 		SugaredLogger: logging.FromContext(ctx).Named("lazyloader"),
 	}
 }
@@ -49,21 +81,118 @@ func NewLazyLoader(ctx context.Context, interval time.Duration) LazyLoader {
 type lazyItem struct {
 	logger  *zap.SugaredLogger
 	checker SetupChecker
+
+	// scoped keeps the runnables added by the controller setup
+	scoped *scopedManager
+	// setups is the number of times the controller was set up
+	setups int
+	// stopping is closed once a previously running scoped controller stopped
+	stopping chan struct{}
+	// disabled is true when the controller was disabled in the configuration
+	disabled bool
+
+	attempts      int
+	lastError     error
+	lastCheckTime time.Time
+	nextCheckTime time.Time
+	loadedTime    time.Time
+}
+
+// isStopping returns true while a previous run of the item is still stopping
+func (i *lazyItem) isStopping() bool {
+	if i.stopping == nil {
+		return false
+	}
+	select {
+	case <-i.stopping:
+		return false
+	default:
+		return true
+	}
+}
+
+// required returns true if the app should not be ready before the item is loaded
+func (i *lazyItem) required() bool {
+	required, ok := i.checker.(RequiredController)
+	return ok && required.RequiredForReadiness()
+}
+
+// status returns the ControllerStatus of the item
+func (i *lazyItem) status(loaded bool) ControllerStatus {
+	status := ControllerStatus{
+		Name:     i.checker.Name(),
+		Loaded:   loaded,
+		Disabled: i.disabled,
+		Required: i.required(),
+		Attempts: i.attempts,
+	}
+	if i.lastError != nil {
+		status.LastError = i.lastError.Error()
+	}
+	if !i.lastCheckTime.IsZero() {
+		status.LastCheckTime = i.lastCheckTime.Format(time.RFC3339)
+	}
+	if !loaded && !i.disabled && !i.nextCheckTime.IsZero() {
+		status.NextCheckTime = i.nextCheckTime.Format(time.RFC3339)
+	}
+	if loaded && !i.loadedTime.IsZero() {
+		status.LoadedTime = i.loadedTime.Format(time.RFC3339)
+	}
+	return status
+}
+
+// ControllerStatus describes the loading status of a controller
+type ControllerStatus struct {
+	Name string `json:"name"`
+	// Loaded is true once the controller setup was invoked
+	Loaded bool `json:"loaded"`
+	// Disabled is true when the controller is disabled in the configuration
+	Disabled bool `json:"disabled"`
+	// Required is true if the app will not be ready before the controller is loaded
+	Required bool `json:"required"`
+	// Attempts is the number of times the controller dependencies were checked
+	Attempts int `json:"attempts"`
+	// LastError is the last error returned by DependentCrdInstalled, CheckSetup or Setup
+	LastError     string `json:"lastError,omitempty"`
+	LastCheckTime string `json:"lastCheckTime,omitempty"`
+	NextCheckTime string `json:"nextCheckTime,omitempty"`
+	LoadedTime    string `json:"loadedTime,omitempty"`
+}
+
+// LazyLoaderStatus describes the status of all controllers of a LazyLoader
+type LazyLoaderStatus struct {
+	Pending []ControllerStatus `json:"pending"`
+	Done    []ControllerStatus `json:"done"`
 }
 
 // LazyLoad loads items to lazy load if any error found
 func (c *controllerLazyLoader) LazyLoad(ctx context.Context, mgr manager.Manager, logger *zap.SugaredLogger, checker SetupChecker) error {
 	c.ctx = ctx
 	c.mgr = mgr
-	item := lazyItem{
+	if mgr != nil {
+		c.mgr = &discoveryAwareManager{Manager: mgr, loader: c}
+	}
+	item := &lazyItem{
 		logger:  logger,
 		checker: checker,
 	}
 
+	if !c.isEnabled(item) {
+		c.Infow("controller is disabled", "ctrl", checker.Name())
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		item.disabled = true
+		c.pending = append(c.pending, item)
+		return nil
+	}
+
 	ok, err := c.checkPending(item)
 	if err != nil {
 		return err
 	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	if !ok {
 		c.pending = append(c.pending, item)
 	} else {
@@ -73,16 +202,37 @@ func (c *controllerLazyLoader) LazyLoad(ctx context.Context, mgr manager.Manager
 	return nil
 }
 
-func (c *controllerLazyLoader) checkPending(item lazyItem) (ok bool, err error) {
+func (c *controllerLazyLoader) checkPending(item *lazyItem) (ok bool, err error) {
+	now := time.Now()
+	defer func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		item.attempts++
+		item.lastCheckTime = now
+		if ok {
+			item.loadedTime = now
+		} else {
+			item.nextCheckTime = now.Add(c.backoff(item.attempts))
+		}
+	}()
+
+	if missing := c.kindsInstalled(declaredKinds(item)); len(missing) > 0 {
+		c.Debugw("controller setup is pending by kinds", "ctrl", item.checker.Name(), "kinds", missing)
+		c.setLastError(item, fmt.Errorf("dependent kinds are not installed: %v", missing))
+		return false, nil
+	}
+
 	if controllerChecker, ok := item.checker.(ControllerChecker); ok {
 		c.Debugw("checking crds", "ctrl", item.checker.Name())
 		checkCrdInstalled, err := controllerChecker.DependentCrdInstalled(c.ctx, c.SugaredLogger)
 		if err != nil {
 			c.Errorw("failed to check crds", "ctrl", item.checker.Name(), "err", err)
+			c.setLastError(item, err)
 			return false, err
 		}
 		if !checkCrdInstalled {
 			c.Debugw("controller setup is pending by crds", "ctrl", item.checker.Name(), "err", err)
+			c.setLastError(item, fmt.Errorf("dependent crds are not installed"))
 			return false, nil
 		}
 	}
@@ -91,52 +241,408 @@ func (c *controllerLazyLoader) checkPending(item lazyItem) (ok bool, err error)
 
 	if err = item.checker.CheckSetup(c.ctx, c.mgr, item.logger); err != nil {
 		c.Debugw("controller setup is pending", "ctrl", item.checker.Name(), "err", err)
+		c.setLastError(item, err)
 		// errors returned by this function will cause an fatal error in the application
 		// therefore here we set a nil to avoid crashing
 		err = nil
 	} else {
 		c.Infow("controller setup started", "ctrl", item.checker.Name())
-		if err = item.checker.Setup(c.ctx, c.mgr, item.logger); err != nil {
+		var mgr manager.Manager = c.mgr
+		var scoped *scopedManager
+		if c.mgr != nil {
+			scoped = newScopedManager(c.mgr, item.setups > 0)
+			scoped.elector, err = c.leaderElector(item)
+			mgr = scoped
+		}
+		if err != nil {
+			c.Errorw("controller leader election setup failed with error", "ctrl", item.checker.Name(), "err", err)
+		} else if err = item.checker.Setup(c.ctx, mgr, item.logger); err != nil {
 			c.Errorw("controller setup failed with error", "ctrl", item.checker.Name(), "err", err)
 		}
+		c.setLastError(item, err)
+		c.lock.Lock()
+		item.setups++
+		item.scoped = scoped
+		runCtx := c.runCtx
+		c.lock.Unlock()
+		if scoped != nil && runCtx != nil {
+			c.startScoped(runCtx, item)
+		}
 		ok = true
 	}
 	return
 }
 
+// leaderElector returns an elector for controllers declaring their own election ID,
+// returns nil if the controller uses the election of the manager or leader election is disabled
+func (c *controllerLazyLoader) leaderElector(item *lazyItem) (*leaderElector, error) {
+	declarer, ok := item.checker.(LeaderElectionDeclarer)
+	if !ok || declarer.LeaderElectionID() == "" {
+		return nil, nil
+	}
+	options := LeaderElectionOptionsCtx(c.ctx)
+	if options == nil || !options.Enabled {
+		return nil, nil
+	}
+	return newLeaderElector(c.mgr, declarer.LeaderElectionID(), *options)
+}
+
+// startScoped starts the runnables of a scoped item
+// if they fail the item is unloaded and will be set up again later
+func (c *controllerLazyLoader) startScoped(ctx context.Context, item *lazyItem) {
+	scoped := item.scoped
+	scoped.start(ctx, func(err error) {
+		if err == nil || errors.Is(err, context.Canceled) {
+			return
+		}
+		c.Errorw("controller stopped with error", "ctrl", item.checker.Name(), "err", err)
+		c.unload(ctx, item, scoped, err, nil)
+	})
+}
+
+// unload stops a scoped item and moves it back to pending,
+// reason is recorded as the last error of the item.
+// The informers of removedKinds are removed from the cache of the manager once the item stopped,
+// except the kinds still declared by other loaded items, as the cache is shared with them.
+func (c *controllerLazyLoader) unload(ctx context.Context, item *lazyItem, scoped *scopedManager, reason error, removedKinds []schema.GroupVersionKind) {
+	c.lock.Lock()
+	if item.scoped != scoped {
+		// already unloaded
+		c.lock.Unlock()
+		return
+	}
+	item.scoped = nil
+	item.lastError = reason
+	item.attempts = 0
+	item.nextCheckTime = time.Now().Add(c.backoff(1))
+	stopping := make(chan struct{})
+	item.stopping = stopping
+	for i := range c.done {
+		if c.done[i] == item {
+			c.done = append(c.done[:i], c.done[i+1:]...)
+			c.pending = append(c.pending, item)
+			break
+		}
+	}
+	c.lock.Unlock()
+
+	// stops asynchronously because unload can be invoked by the runnables themselves
+	go func() {
+		scoped.stop()
+		c.removeInformers(ctx, c.unusedKinds(removedKinds))
+		close(stopping)
+		c.notifyRecheck()
+	}()
+}
+
+// notifyRecheck asks Start to check pending controllers again
+// notifications are coalesced while the previous one is not handled yet
+func (c *controllerLazyLoader) notifyRecheck() {
+	select {
+	case c.recheck <- struct{}{}:
+	default:
+	}
+}
+
+func (c *controllerLazyLoader) setLastError(item *lazyItem, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	item.lastError = err
+}
+
+// backoff returns the duration to wait before checking an item again
+// after the given number of attempts
+func (c *controllerLazyLoader) backoff(attempts int) time.Duration {
+	backoff := c.getInterval()
+	for i := 1; i < attempts && backoff < c.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+	return backoff
+}
+
+// getInterval returns the interval configured in the config manager if any
+// otherwise returns the interval given on construction
+func (c *controllerLazyLoader) getInterval() time.Duration {
+	manager := config.ConfigManager(c.ctx)
+	if manager == nil {
+		return c.interval
+	}
+	value := manager.GetFeatureFlag(config.LazyLoaderIntervalKey)
+	if value == "" {
+		return c.interval
+	}
+	interval, err := value.AsDuration()
+	if err != nil || interval <= 0 {
+		c.Warnw("invalid lazy loader interval, using default", "value", value, "default", c.interval, "err", err)
+		return c.interval
+	}
+	return interval
+}
+
 // Start starts to check and load controllers
 // this method will block execution and should be runned in a goroutine
 func (c *controllerLazyLoader) Start(ctx context.Context) error {
-	ticker := time.NewTicker(c.interval)
+	c.lock.Lock()
+	c.runCtx = ctx
+	scopedItems := []*lazyItem{}
+	watchKinds := false
+	for _, item := range append(append([]*lazyItem{}, c.pending...), c.done...) {
+		watchKinds = watchKinds || len(declaredKinds(item)) > 0
+		if item.scoped != nil {
+			scopedItems = append(scopedItems, item)
+		}
+	}
+	c.lock.Unlock()
+
+	for _, item := range scopedItems {
+		c.startScoped(ctx, item)
+	}
+
+	if manager := config.ConfigManager(c.ctx); manager != nil {
+		subscription := manager.Subscribe("lazyloader", func(config.ConfigChange) {
+			c.notifyRecheck()
+		}, config.WithPrefixes(config.ControllerKeyPrefix))
+		defer subscription.Cancel()
+	}
+
+	var crdChanged <-chan struct{}
+	var events *crdEventQueue
+	if watchKinds && c.mgr != nil {
+		var err error
+		if events, err = c.watchCRDs(ctx); err != nil {
+			c.Warnw("failed to watch crds, will only check pending controllers periodically", "err", err)
+		} else {
+			crdChanged = events.notify
+		}
+	}
+
+	timer := time.NewTimer(c.getInterval())
+	defer timer.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			if len(c.pending) > 0 {
-				c.Infow("layloader controller setup check", "len(pending)", len(c.pending), "len(done)", len(c.done))
+		case <-timer.C:
+			if err := c.checkAllPending(); err != nil {
+				return err
 			}
-			names := []string{}
-			for i := 0; i < len(c.pending); i++ {
-				item := c.pending[i]
-				c.Debugw("checking controller", "ctrl", item.checker.Name())
-				ok, err := c.checkPending(item)
-				if err != nil {
+			timer.Reset(c.getInterval())
+
+		case <-crdChanged:
+			for _, event := range events.drain() {
+				if err := c.handleCRDEvent(ctx, event); err != nil {
 					return err
 				}
-				if ok {
-					c.pending = append(c.pending[:i], c.pending[i+1:]...)
-					i--
-					c.done = append(c.done, item)
-				} else {
-					names = append(names, item.checker.Name())
-				}
 			}
-			if len(names) > 0 {
-				c.Infow("still have pending controllers", "ctrls", names)
+
+		case <-c.recheck:
+			if err := c.applyEnabled(ctx); err != nil {
+				return err
 			}
 
 		case <-ctx.Done():
 			c.Infow("shutting down lazy loader")
+			c.stopAll()
+			return nil
+		}
+	}
+}
+
+// checkAllPending checks all pending items which reached their next check time
+func (c *controllerLazyLoader) checkAllPending() error {
+	c.lock.RLock()
+	pending := append([]*lazyItem{}, c.pending...)
+	doneCount := len(c.done)
+	c.lock.RUnlock()
+
+	if len(pending) > 0 {
+		c.Infow("layloader controller setup check", "len(pending)", len(pending), "len(done)", doneCount)
+	}
+	now := time.Now()
+	names := []string{}
+	for _, item := range pending {
+		c.lock.RLock()
+		nextCheckTime, stopping, disabled := item.nextCheckTime, item.isStopping(), item.disabled
+		c.lock.RUnlock()
+		if disabled {
+			continue
+		}
+		if stopping || now.Before(nextCheckTime) {
+			names = append(names, item.checker.Name())
+			continue
+		}
+
+		c.Debugw("checking controller", "ctrl", item.checker.Name())
+		ok, err := c.checkPending(item)
+		if err != nil {
+			return err
+		}
+		if ok {
+			c.markDone(item)
+		} else {
+			names = append(names, item.checker.Name())
+		}
+	}
+	if len(names) > 0 {
+		c.Infow("still have pending controllers", "ctrls", names)
+	}
+	return nil
+}
+
+// handleCRDEvent checks pending items depending on kinds which just got established
+// and unloads running items depending on kinds which were removed
+func (c *controllerLazyLoader) handleCRDEvent(ctx context.Context, event crdEvent) error {
+	c.setKindsRemoved(event.kinds, !event.established)
+	if event.established {
+		c.lock.Lock()
+		found := false
+		for _, item := range c.pending {
+			if dependsOnAny(item, event.kinds) {
+				// checks the item right away
+				item.nextCheckTime = time.Time{}
+				found = true
+			}
+		}
+		c.lock.Unlock()
+		if !found {
 			return nil
 		}
+		c.Infow("dependent kinds established, checking pending controllers", "kinds", event.kinds)
+		return c.checkAllPending()
+	}
+
+	c.lock.RLock()
+	unloading := map[*lazyItem]*scopedManager{}
+	for _, item := range c.done {
+		if item.scoped != nil && dependsOnAny(item, event.kinds) {
+			unloading[item] = item.scoped
+		}
+	}
+	c.lock.RUnlock()
+	for item, scoped := range unloading {
+		c.Infow("dependent kinds removed, stopping controller", "ctrl", item.checker.Name(), "kinds", event.kinds)
+		c.unload(ctx, item, scoped, fmt.Errorf("dependent kinds were removed: %v", event.kinds), event.kinds)
+	}
+	return nil
+}
+
+// isEnabled returns false if the controller is disabled in the config manager
+func (c *controllerLazyLoader) isEnabled(item *lazyItem) bool {
+	manager := config.ConfigManager(c.ctx)
+	if manager == nil {
+		return true
+	}
+	key := config.ControllerEnabledKey(item.checker.Name())
+	value := manager.GetFeatureFlag(key)
+	if value == "" {
+		return true
+	}
+	enabled, err := value.AsBool()
+	if err != nil {
+		c.Warnw("invalid controller enabled value, keeping the controller enabled", "key", key, "value", value, "err", err)
+		return true
+	}
+	return enabled
+}
+
+// applyEnabled stops controllers which were disabled in the configuration
+// and checks all pending controllers due for a check, including the ones enabled again
+func (c *controllerLazyLoader) applyEnabled(ctx context.Context) error {
+	c.lock.RLock()
+	items := append(append([]*lazyItem{}, c.pending...), c.done...)
+	c.lock.RUnlock()
+
+	for _, item := range items {
+		enabled := c.isEnabled(item)
+
+		c.lock.Lock()
+		disabled, scoped := item.disabled, item.scoped
+		item.disabled = !enabled
+		if !disabled && !enabled {
+			// avoids reporting an old error for a disabled controller
+			item.lastError = nil
+		}
+		if disabled && enabled {
+			item.attempts = 0
+			item.nextCheckTime = time.Time{}
+		}
+		c.lock.Unlock()
+
+		switch {
+		case !disabled && !enabled && scoped != nil:
+			c.Infow("controller disabled, stopping controller", "ctrl", item.checker.Name())
+			// the informers are kept as they can be used by other controllers and the cached client
+			c.unload(ctx, item, scoped, nil, nil)
+		case !disabled && !enabled:
+			c.Infow("controller disabled", "ctrl", item.checker.Name())
+		case disabled && enabled:
+			c.Infow("controller enabled", "ctrl", item.checker.Name())
+		}
+	}
+
+	return c.checkAllPending()
+}
+
+// stopAll stops all scoped controllers and waits until they returned
+func (c *controllerLazyLoader) stopAll() {
+	c.lock.RLock()
+	scopeds := []*scopedManager{}
+	for _, item := range c.done {
+		if item.scoped != nil {
+			scopeds = append(scopeds, item.scoped)
+		}
 	}
+	c.lock.RUnlock()
+
+	for _, scoped := range scopeds {
+		scoped.stop()
+	}
+}
+
+// markDone moves an item from pending to done
+func (c *controllerLazyLoader) markDone(item *lazyItem) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i := range c.pending {
+		if c.pending[i] == item {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+	c.done = append(c.done, item)
+}
+
+// Status returns the loading status of all controllers
+func (c *controllerLazyLoader) Status() LazyLoaderStatus {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	status := LazyLoaderStatus{
+		Pending: make([]ControllerStatus, 0, len(c.pending)),
+		Done:    make([]ControllerStatus, 0, len(c.done)),
+	}
+	for _, item := range c.pending {
+		status.Pending = append(status.Pending, item.status(false))
+	}
+	for _, item := range c.done {
+		status.Done = append(status.Done, item.status(true))
+	}
+	return status
+}
+
+// ReadyzCheck returns an error while any controller implementing
+// RequiredController is still pending
+func (c *controllerLazyLoader) ReadyzCheck(_ *http.Request) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	names := []string{}
+	for _, item := range c.pending {
+		if item.required() && !item.disabled {
+			names = append(names, item.checker.Name())
+		}
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("required controllers are not loaded yet: %v", names)
+	}
+	return nil
 }
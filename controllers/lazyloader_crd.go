@@ -0,0 +1,296 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apiextensionsinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// crdEvent describes served kinds of a CRD which just became established or were removed
+type crdEvent struct {
+	kinds       []schema.GroupVersionKind
+	established bool
+}
+
+// crdKinds returns all served kinds of a CRD
+func crdKinds(crd *apiextensionsv1.CustomResourceDefinition) []schema.GroupVersionKind {
+	kinds := make([]schema.GroupVersionKind, 0, len(crd.Spec.Versions))
+	for _, version := range crd.Spec.Versions {
+		if !version.Served {
+			continue
+		}
+		kinds = append(kinds, schema.GroupVersionKind{
+			Group:   crd.Spec.Group,
+			Version: version.Name,
+			Kind:    crd.Spec.Names.Kind,
+		})
+	}
+	return kinds
+}
+
+// crdEstablished returns true if the CRD is established and not being deleted
+func crdEstablished(crd *apiextensionsv1.CustomResourceDefinition) bool {
+	if crd == nil || crd.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range crd.Status.Conditions {
+		if condition.Type == apiextensionsv1.Established {
+			return condition.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// crdEventQueue queues crdEvents without blocking the informer delivering them,
+// notify is signaled whenever events are added
+type crdEventQueue struct {
+	lock   sync.Mutex
+	events []crdEvent
+	notify chan struct{}
+}
+
+func newCRDEventQueue() *crdEventQueue {
+	return &crdEventQueue{notify: make(chan struct{}, 1)}
+}
+
+// add queues an event, notifications are coalesced while the queue is not drained
+func (q *crdEventQueue) add(event crdEvent) {
+	q.lock.Lock()
+	q.events = append(q.events, event)
+	q.lock.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// drain returns and removes all queued events
+func (q *crdEventQueue) drain() []crdEvent {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	events := q.events
+	q.events = nil
+	return events
+}
+
+// crdEventHandler converts CRD informer notifications into crdEvents
+// only when the established state of a CRD changes
+func crdEventHandler(events *crdEventQueue) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			crd, ok := obj.(*apiextensionsv1.CustomResourceDefinition)
+			if ok && crdEstablished(crd) {
+				events.add(crdEvent{kinds: crdKinds(crd), established: true})
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldCRD, _ := oldObj.(*apiextensionsv1.CustomResourceDefinition)
+			newCRD, ok := newObj.(*apiextensionsv1.CustomResourceDefinition)
+			if !ok {
+				return
+			}
+			wasEstablished, established := crdEstablished(oldCRD), crdEstablished(newCRD)
+			if wasEstablished != established {
+				events.add(crdEvent{kinds: crdKinds(newCRD), established: established})
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if crd, ok := obj.(*apiextensionsv1.CustomResourceDefinition); ok {
+				events.add(crdEvent{kinds: crdKinds(crd), established: false})
+			}
+		},
+	}
+}
+
+// watchCRDs starts an informer on CRDs and returns the queue of its events
+// the informer is stopped when ctx is done
+func (c *controllerLazyLoader) watchCRDs(ctx context.Context) (*crdEventQueue, error) {
+	clientset, err := apiextensionsclientset.NewForConfig(c.mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	events := newCRDEventQueue()
+	factory := apiextensionsinformers.NewSharedInformerFactory(clientset, 0)
+	informer := factory.Apiextensions().V1().CustomResourceDefinitions().Informer()
+	if _, err = informer.AddEventHandler(crdEventHandler(events)); err != nil {
+		return nil, err
+	}
+	factory.Start(ctx.Done())
+	return events, nil
+}
+
+// removedKindsMapper hides the kinds of removed CRDs which may still be cached by the RESTMapper
+// of the manager, so that checks do not pass on a stale mapping until the CRD is established again
+type removedKindsMapper struct {
+	meta.RESTMapper
+	removed func(schema.GroupKind) bool
+}
+
+// RESTMapping returns a no match error for removed kinds
+func (m removedKindsMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	if m.removed(gk) {
+		return nil, &meta.NoKindMatchError{GroupKind: gk, SearchedVersions: versions}
+	}
+	return m.RESTMapper.RESTMapping(gk, versions...)
+}
+
+// RESTMappings returns a no match error for removed kinds
+func (m removedKindsMapper) RESTMappings(gk schema.GroupKind, versions ...string) ([]*meta.RESTMapping, error) {
+	if m.removed(gk) {
+		return nil, &meta.NoKindMatchError{GroupKind: gk, SearchedVersions: versions}
+	}
+	return m.RESTMapper.RESTMappings(gk, versions...)
+}
+
+// discoveryAwareManager is the manager given to controllers by the lazy loader,
+// its RESTMapper reflects the CRDs removed since the mapper discovered them
+type discoveryAwareManager struct {
+	manager.Manager
+	loader *controllerLazyLoader
+}
+
+// GetRESTMapper returns the RESTMapper of the manager hiding removed kinds
+func (m *discoveryAwareManager) GetRESTMapper() meta.RESTMapper {
+	return removedKindsMapper{RESTMapper: m.Manager.GetRESTMapper(), removed: m.loader.kindRemoved}
+}
+
+// setKindsRemoved records the kinds of a CRD as removed or established
+// and resets the RESTMapper of the manager when it supports it
+func (c *controllerLazyLoader) setKindsRemoved(kinds []schema.GroupVersionKind, removed bool) {
+	c.removedLock.Lock()
+	if c.removedKinds == nil {
+		c.removedKinds = map[schema.GroupKind]struct{}{}
+	}
+	for _, kind := range kinds {
+		if removed {
+			c.removedKinds[kind.GroupKind()] = struct{}{}
+		} else {
+			delete(c.removedKinds, kind.GroupKind())
+		}
+	}
+	c.removedLock.Unlock()
+
+	// the mapper of the wrapped manager is reset, the mapper of discoveryAwareManager only hides removed kinds
+	mgr := c.mgr
+	if wrapped, ok := mgr.(*discoveryAwareManager); ok {
+		mgr = wrapped.Manager
+	}
+	if mgr == nil {
+		return
+	}
+	if resettable, ok := mgr.GetRESTMapper().(meta.ResettableRESTMapper); ok {
+		resettable.Reset()
+	}
+}
+
+// kindRemoved returns true if the CRD serving the kind was removed and not established again
+func (c *controllerLazyLoader) kindRemoved(gk schema.GroupKind) bool {
+	c.removedLock.RLock()
+	defer c.removedLock.RUnlock()
+	_, ok := c.removedKinds[gk]
+	return ok
+}
+
+// declaredKinds returns the kinds the item depends on if declared
+func declaredKinds(item *lazyItem) []schema.GroupVersionKind {
+	if declarer, ok := item.checker.(DependentKindsDeclarer); ok {
+		return declarer.DependentKinds()
+	}
+	return nil
+}
+
+// dependsOnAny returns true if the item declared any of the given kinds
+func dependsOnAny(item *lazyItem, kinds []schema.GroupVersionKind) bool {
+	for _, declared := range declaredKinds(item) {
+		for _, kind := range kinds {
+			if declared == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// kindsInstalled checks if all kinds are known by the api server
+func (c *controllerLazyLoader) kindsInstalled(kinds []schema.GroupVersionKind) (missing []schema.GroupVersionKind) {
+	if c.mgr == nil {
+		return nil
+	}
+	mapper := c.mgr.GetRESTMapper()
+	for _, kind := range kinds {
+		if _, err := mapper.RESTMapping(kind.GroupKind(), kind.Version); err != nil {
+			missing = append(missing, kind)
+		}
+	}
+	return missing
+}
+
+// unusedKinds returns the kinds not declared by any loaded item
+func (c *controllerLazyLoader) unusedKinds(kinds []schema.GroupVersionKind) (unused []schema.GroupVersionKind) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, kind := range kinds {
+		used := false
+		for _, item := range c.done {
+			if item.scoped != nil && dependsOnAny(item, []schema.GroupVersionKind{kind}) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unused = append(unused, kind)
+		}
+	}
+	return unused
+}
+
+// removeInformers removes informers of the given kinds from the manager cache
+// to avoid watching kinds which do not exist anymore
+func (c *controllerLazyLoader) removeInformers(ctx context.Context, kinds []schema.GroupVersionKind) {
+	if c.mgr == nil || c.mgr.GetCache() == nil {
+		return
+	}
+	for _, kind := range kinds {
+		var obj client.Object
+		if typed, err := c.mgr.GetScheme().New(kind); err == nil {
+			obj, _ = typed.(client.Object)
+		}
+		if obj == nil {
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(kind)
+			obj = u
+		}
+		if err := c.mgr.GetCache().RemoveInformer(ctx, obj); err != nil {
+			c.Warnw("failed to remove informer", "kind", kind.String(), "err", err)
+		}
+	}
+}
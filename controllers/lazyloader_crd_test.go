@@ -0,0 +1,288 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var fooKind = schema.GroupVersionKind{Group: "example.io", Version: "v1", Kind: "Foo"}
+
+type fakeCache struct {
+	cache.Cache
+	removed atomic.Int32
+}
+
+func (c *fakeCache) WaitForCacheSync(ctx context.Context) bool {
+	return true
+}
+
+func (c *fakeCache) RemoveInformer(ctx context.Context, obj client.Object) error {
+	c.removed.Add(1)
+	return nil
+}
+
+type fakeManager struct {
+	manager.Manager
+	mapper  *meta.DefaultRESTMapper
+	cache   *fakeCache
+	elected chan struct{}
+}
+
+func newFakeManager() *fakeManager {
+	elected := make(chan struct{})
+	close(elected)
+	return &fakeManager{
+		mapper:  meta.NewDefaultRESTMapper(nil),
+		cache:   &fakeCache{},
+		elected: elected,
+	}
+}
+
+func (m *fakeManager) GetRESTMapper() meta.RESTMapper          { return m.mapper }
+func (m *fakeManager) GetCache() cache.Cache                   { return m.cache }
+func (m *fakeManager) Elected() <-chan struct{}                { return m.elected }
+func (m *fakeManager) GetScheme() *runtime.Scheme              { return runtime.NewScheme() }
+func (m *fakeManager) GetControllerOptions() config.Controller { return config.Controller{} }
+func (m *fakeManager) Add(manager.Runnable) error              { panic("should not add to the manager") }
+func (m *fakeManager) installKind(kind schema.GroupVersionKind) {
+	m.mapper.Add(kind, meta.RESTScopeNamespace)
+}
+
+func skipNameValidation(mgr manager.Manager) bool {
+	skip := mgr.GetControllerOptions().SkipNameValidation
+	return skip != nil && *skip
+}
+
+// kindsChecker is a controller depending on fooKind
+type kindsChecker struct {
+	lock    sync.Mutex
+	setups  int
+	reloads int
+	running atomic.Int32
+}
+
+func (k *kindsChecker) Name() string { return "kinds" }
+
+func (k *kindsChecker) DependentKinds() []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{fooKind}
+}
+
+func (k *kindsChecker) CheckSetup(context.Context, manager.Manager, *zap.SugaredLogger) error {
+	return nil
+}
+
+func (k *kindsChecker) Setup(ctx context.Context, mgr manager.Manager, _ *zap.SugaredLogger) error {
+	k.lock.Lock()
+	k.setups++
+	if skipNameValidation(mgr) {
+		k.reloads++
+	}
+	k.lock.Unlock()
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		k.running.Add(1)
+		defer k.running.Add(-1)
+		<-ctx.Done()
+		return nil
+	}))
+}
+
+func (k *kindsChecker) counts() (int, int) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.setups, k.reloads
+}
+
+func TestControllerLazyLoaderCRDEvents(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := newFakeManager()
+	checker := &kindsChecker{}
+	loader := NewLazyLoader(ctx, time.Hour).(*controllerLazyLoader)
+
+	g.Expect(loader.LazyLoad(ctx, mgr, zap.NewNop().Sugar(), checker)).To(Succeed())
+	g.Expect(loader.Status().Pending).To(HaveLen(1))
+	g.Expect(loader.Status().Pending[0].LastError).To(ContainSubstring("dependent kinds are not installed"))
+	loader.runCtx = ctx
+
+	// crd of another kind does not trigger the setup
+	g.Expect(loader.handleCRDEvent(ctx, crdEvent{
+		kinds:       []schema.GroupVersionKind{{Group: "example.io", Version: "v1", Kind: "Bar"}},
+		established: true,
+	})).To(Succeed())
+	g.Expect(loader.Status().Pending).To(HaveLen(1))
+
+	// crd established
+	mgr.installKind(fooKind)
+	g.Expect(loader.handleCRDEvent(ctx, crdEvent{kinds: []schema.GroupVersionKind{fooKind}, established: true})).To(Succeed())
+	g.Expect(loader.Status().Done).To(HaveLen(1))
+	g.Eventually(checker.running.Load).Should(Equal(int32(1)))
+
+	// crd removed while the RESTMapper still has a stale mapping of the kind
+	g.Expect(loader.handleCRDEvent(ctx, crdEvent{kinds: []schema.GroupVersionKind{fooKind}, established: false})).To(Succeed())
+	g.Expect(loader.kindsInstalled([]schema.GroupVersionKind{fooKind})).To(ConsistOf(fooKind))
+	g.Expect(loader.Status().Pending).To(HaveLen(1))
+	g.Expect(loader.Status().Pending[0].LastError).To(ContainSubstring("dependent kinds were removed"))
+	g.Eventually(checker.running.Load).Should(Equal(int32(0)))
+	g.Eventually(mgr.cache.removed.Load).Should(Equal(int32(1)))
+
+	// crd installed back
+	mgr.installKind(fooKind)
+	g.Eventually(func() int {
+		g.Expect(loader.handleCRDEvent(ctx, crdEvent{kinds: []schema.GroupVersionKind{fooKind}, established: true})).To(Succeed())
+		return len(loader.Status().Done)
+	}).Should(Equal(1))
+	g.Eventually(checker.running.Load).Should(Equal(int32(1)))
+
+	setups, reloads := checker.counts()
+	g.Expect(setups).To(Equal(2))
+	g.Expect(reloads).To(Equal(1))
+}
+
+func TestCRDEventHandler(t *testing.T) {
+	established := func(status apiextensionsv1.ConditionStatus) *apiextensionsv1.CustomResourceDefinition {
+		return &apiextensionsv1.CustomResourceDefinition{
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Group: "example.io",
+				Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: "Foo"},
+				Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+					{Name: "v1", Served: true},
+					{Name: "v1alpha1", Served: false},
+				},
+			},
+			Status: apiextensionsv1.CustomResourceDefinitionStatus{
+				Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+					{Type: apiextensionsv1.Established, Status: status},
+				},
+			},
+		}
+	}
+
+	t.Run("add established crd", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		events := newCRDEventQueue()
+		crdEventHandler(events).OnAdd(established(apiextensionsv1.ConditionTrue), true)
+		g.Expect(events.notify).To(Receive())
+		g.Expect(events.drain()).To(ConsistOf(crdEvent{kinds: []schema.GroupVersionKind{fooKind}, established: true}))
+	})
+
+	t.Run("add not established crd", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		events := newCRDEventQueue()
+		crdEventHandler(events).OnAdd(established(apiextensionsv1.ConditionFalse), true)
+		g.Expect(events.notify).NotTo(Receive())
+		g.Expect(events.drain()).To(BeEmpty())
+	})
+
+	t.Run("crd being deleted", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		events := newCRDEventQueue()
+		deleting := established(apiextensionsv1.ConditionTrue)
+		deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		crdEventHandler(events).OnUpdate(established(apiextensionsv1.ConditionTrue), deleting)
+		g.Expect(events.notify).To(Receive())
+		g.Expect(events.drain()).To(ConsistOf(crdEvent{kinds: []schema.GroupVersionKind{fooKind}, established: false}))
+	})
+
+	t.Run("update without established change", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		events := newCRDEventQueue()
+		crdEventHandler(events).OnUpdate(established(apiextensionsv1.ConditionTrue), established(apiextensionsv1.ConditionTrue))
+		g.Expect(events.notify).NotTo(Receive())
+		g.Expect(events.drain()).To(BeEmpty())
+	})
+
+	t.Run("delete crd", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		events := newCRDEventQueue()
+		crdEventHandler(events).OnDelete(established(apiextensionsv1.ConditionTrue))
+		g.Expect(events.notify).To(Receive())
+		g.Expect(events.drain()).To(ConsistOf(crdEvent{kinds: []schema.GroupVersionKind{fooKind}, established: false}))
+	})
+}
+
+func TestCRDEventQueueDoesNotBlock(t *testing.T) {
+	g := NewGomegaWithT(t)
+	events := newCRDEventQueue()
+	for i := 0; i < 200; i++ {
+		events.add(crdEvent{kinds: []schema.GroupVersionKind{fooKind}, established: i%2 == 0})
+	}
+	g.Expect(events.notify).To(Receive())
+	g.Expect(events.notify).NotTo(Receive())
+	g.Expect(events.drain()).To(HaveLen(200))
+	g.Expect(events.drain()).To(BeEmpty())
+}
+
+func TestControllerLazyLoaderUnusedKinds(t *testing.T) {
+	g := NewGomegaWithT(t)
+	barKind := schema.GroupVersionKind{Group: "example.io", Version: "v1", Kind: "Bar"}
+	loader := &controllerLazyLoader{done: []*lazyItem{
+		{checker: &kindsChecker{}, scoped: &scopedManager{}},
+	}}
+	g.Expect(loader.unusedKinds([]schema.GroupVersionKind{fooKind, barKind})).To(Equal([]schema.GroupVersionKind{barKind}))
+
+	// items which are not running do not use their kinds
+	loader.done[0].scoped = nil
+	g.Expect(loader.unusedKinds([]schema.GroupVersionKind{fooKind})).To(Equal([]schema.GroupVersionKind{fooKind}))
+}
+
+// resettableMapper counts the resets of the mapper
+type resettableMapper struct {
+	meta.RESTMapper
+	resets int
+}
+
+func (m *resettableMapper) Reset() { m.resets++ }
+
+type resettableMapperManager struct {
+	*fakeManager
+	mapper *resettableMapper
+}
+
+func (m *resettableMapperManager) GetRESTMapper() meta.RESTMapper { return m.mapper }
+
+func TestControllerLazyLoaderResetsRESTMapper(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	fake := newFakeManager()
+	fake.installKind(fooKind)
+	mgr := &resettableMapperManager{fakeManager: fake, mapper: &resettableMapper{RESTMapper: fake.mapper}}
+	loader := NewLazyLoader(ctx, time.Hour).(*controllerLazyLoader)
+	g.Expect(loader.LazyLoad(ctx, mgr, zap.NewNop().Sugar(), &kindsChecker{})).To(Succeed())
+
+	loader.setKindsRemoved([]schema.GroupVersionKind{fooKind}, true)
+	g.Expect(mgr.mapper.resets).To(Equal(1))
+	g.Expect(loader.kindsInstalled([]schema.GroupVersionKind{fooKind})).To(ConsistOf(fooKind))
+}
@@ -22,14 +22,21 @@ import (
 	"testing"
 	"time"
 
+	"github.com/AlaudaDevops/pkg/config"
+
 	. "github.com/onsi/gomega"
 	"go.uber.org/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 type mockChecker struct {
-	name string
-	err  error
+	name     string
+	err      error
+	required bool
+}
+
+func (m *mockChecker) RequiredForReadiness() bool {
+	return m.required
 }
 
 func (m *mockChecker) Name() string {
@@ -95,3 +102,121 @@ func TestControllerLazyLoader(t *testing.T) {
 	}()
 
 }
+
+func TestControllerLazyLoaderStatus(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+	loader := NewLazyLoader(ctx, time.Minute).(*controllerLazyLoader)
+
+	g.Expect(loader.LazyLoad(ctx, nil, sugar, &mockChecker{name: "optional", err: errors.New("missing dependency")})).To(Succeed())
+	g.Expect(loader.ReadyzCheck(nil)).To(Succeed())
+
+	g.Expect(loader.LazyLoad(ctx, nil, sugar, &mockChecker{name: "required", err: errors.New("missing dependency"), required: true})).To(Succeed())
+	g.Expect(loader.LazyLoad(ctx, nil, sugar, &mockChecker{name: "loaded"})).To(Succeed())
+
+	err := loader.ReadyzCheck(nil)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("required"))
+	g.Expect(err.Error()).NotTo(ContainSubstring("optional"))
+
+	status := loader.Status()
+	g.Expect(status.Pending).To(HaveLen(2))
+	g.Expect(status.Pending[0].Name).To(Equal("optional"))
+	g.Expect(status.Pending[0].LastError).To(Equal("missing dependency"))
+	g.Expect(status.Pending[0].Attempts).To(Equal(1))
+	g.Expect(status.Pending[0].NextCheckTime).NotTo(BeEmpty())
+	g.Expect(status.Pending[1].Required).To(BeTrue())
+	g.Expect(status.Done).To(HaveLen(1))
+	g.Expect(status.Done[0].Name).To(Equal("loaded"))
+	g.Expect(status.Done[0].Loaded).To(BeTrue())
+	g.Expect(status.Done[0].LastError).To(BeEmpty())
+}
+
+func TestControllerLazyLoaderBackoff(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("doubles the interval up to max backoff", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		loader := NewLazyLoader(ctx, time.Minute).(*controllerLazyLoader)
+
+		g.Expect(loader.backoff(1)).To(Equal(time.Minute))
+		g.Expect(loader.backoff(2)).To(Equal(2 * time.Minute))
+		g.Expect(loader.backoff(3)).To(Equal(4 * time.Minute))
+		g.Expect(loader.backoff(10)).To(Equal(DefaultLazyLoaderMaxBackoff))
+	})
+
+	t.Run("uses the interval of the config manager", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		manager := &config.Manager{Config: &config.Config{Data: map[string]string{
+			config.LazyLoaderIntervalKey: "10s",
+		}}}
+		loader := NewLazyLoader(config.WithConfigManager(ctx, manager), time.Minute).(*controllerLazyLoader)
+
+		g.Expect(loader.getInterval()).To(Equal(10 * time.Second))
+		g.Expect(loader.backoff(2)).To(Equal(20 * time.Second))
+	})
+
+	t.Run("ignores an invalid interval of the config manager", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		manager := &config.Manager{Config: &config.Config{Data: map[string]string{
+			config.LazyLoaderIntervalKey: "abc",
+		}}}
+		loader := NewLazyLoader(config.WithConfigManager(ctx, manager), time.Minute).(*controllerLazyLoader)
+
+		g.Expect(loader.getInterval()).To(Equal(time.Minute))
+	})
+}
+
+func TestControllerLazyLoaderEnabled(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := config.ControllerEnabledKey("kinds")
+	configManager := &config.Manager{Config: &config.Config{Data: map[string]string{key: "false"}}}
+	ctx = config.WithConfigManager(ctx, configManager)
+
+	mgr := newFakeManager()
+	mgr.installKind(fooKind)
+	checker := &kindsChecker{}
+	loader := NewLazyLoader(ctx, time.Hour).(*controllerLazyLoader)
+	loader.runCtx = ctx
+
+	// disabled on load
+	g.Expect(loader.LazyLoad(ctx, mgr, zap.NewNop().Sugar(), checker)).To(Succeed())
+	g.Expect(loader.Status().Pending).To(HaveLen(1))
+	g.Expect(loader.Status().Pending[0].Disabled).To(BeTrue())
+	setups, _ := checker.counts()
+	g.Expect(setups).To(Equal(0))
+
+	// enabled
+	configManager.Config = &config.Config{Data: map[string]string{key: "true"}}
+	g.Expect(loader.applyEnabled(ctx)).To(Succeed())
+	g.Expect(loader.Status().Done).To(HaveLen(1))
+	g.Eventually(checker.running.Load).Should(Equal(int32(1)))
+
+	// disabled at runtime
+	configManager.Config = &config.Config{Data: map[string]string{key: "false"}}
+	g.Expect(loader.applyEnabled(ctx)).To(Succeed())
+	g.Expect(loader.Status().Pending).To(HaveLen(1))
+	g.Expect(loader.Status().Pending[0].Disabled).To(BeTrue())
+	g.Expect(loader.Status().Pending[0].LastError).To(BeEmpty())
+	g.Eventually(checker.running.Load).Should(Equal(int32(0)))
+	// the informers of the shared cache are kept
+	g.Consistently(mgr.cache.removed.Load, "100ms").Should(BeZero())
+
+	// enabled again, the key is removed
+	configManager.Config = &config.Config{Data: map[string]string{}}
+	g.Eventually(func() int {
+		g.Expect(loader.applyEnabled(ctx)).To(Succeed())
+		return len(loader.Status().Done)
+	}).Should(Equal(1))
+	g.Eventually(checker.running.Load).Should(Equal(int32(1)))
+	_, reloads := checker.counts()
+	g.Expect(reloads).To(Equal(1))
+
+	// stops all controllers on shutdown
+	loader.stopAll()
+	g.Expect(checker.running.Load()).To(Equal(int32(0)))
+}
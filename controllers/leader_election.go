@@ -0,0 +1,116 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	kmanager "github.com/AlaudaDevops/pkg/manager"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	ctrlleaderelection "sigs.k8s.io/controller-runtime/pkg/leaderelection"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// ErrLeaderElectionLost is returned when a controller elected using its own election ID loses its lease
+var ErrLeaderElectionLost = errors.New("leader election lost")
+
+// LeaderElectionDeclarer controllers implementing this interface and returning a non empty ID
+// are elected using their own lease instead of the lease of the manager,
+// so that unrelated controllers of the same app can be active on different replicas.
+// When the lease is lost the controller is stopped and set up again by the LazyLoader.
+type LeaderElectionDeclarer interface {
+	LeaderElectionID() string
+}
+
+// LeaderElectionOptions configures the leader election of controllers declaring their own election ID
+type LeaderElectionOptions struct {
+	// Enabled is false when leader election is disabled, controllers are then always active
+	Enabled bool
+	// Namespace of the leases, the namespace of the pod is used if empty
+	Namespace string
+	// LeaseDuration the duration that non-leader candidates will wait to force acquire leadership
+	LeaseDuration time.Duration
+	// RenewDeadline the duration that the leader will retry refreshing leadership before giving up
+	RenewDeadline time.Duration
+	// RetryPeriod the duration the clients should wait between tries of actions
+	RetryPeriod time.Duration
+	// NewResourceLock creates the lock of each election ID, leases are used if nil
+	NewResourceLock kmanager.ResourceLockFunc
+}
+
+// leaderElector runs the leader election of a single election ID
+type leaderElector struct {
+	id      string
+	config  leaderelection.LeaderElectionConfig
+	elected chan struct{}
+}
+
+// newLeaderElector returns an elector for the election ID using the config and the event recorders of the manager
+func newLeaderElector(mgr manager.Manager, id string, options LeaderElectionOptions) (*leaderElector, error) {
+	newResourceLock := options.NewResourceLock
+	if newResourceLock == nil {
+		newResourceLock = ctrlleaderelection.NewResourceLock
+	}
+	lock, err := newResourceLock(mgr.GetConfig(), mgr, ctrlleaderelection.Options{
+		LeaderElection:             true,
+		LeaderElectionResourceLock: resourcelock.LeasesResourceLock,
+		LeaderElectionNamespace:    options.Namespace,
+		LeaderElectionID:           id,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create resource lock for election id %q: %w", id, err)
+	}
+	return &leaderElector{
+		id: id,
+		config: leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			Name:            id,
+			LeaseDuration:   options.LeaseDuration,
+			RenewDeadline:   options.RenewDeadline,
+			RetryPeriod:     options.RetryPeriod,
+			ReleaseOnCancel: true,
+		},
+		elected: make(chan struct{}),
+	}, nil
+}
+
+// Elected is closed once the lease is acquired
+func (e *leaderElector) Elected() <-chan struct{} {
+	return e.elected
+}
+
+// run campaigns for the lease until ctx is done, returns ErrLeaderElectionLost if the lease is lost before
+func (e *leaderElector) run(ctx context.Context) error {
+	config := e.config
+	config.Callbacks = leaderelection.LeaderCallbacks{
+		OnStartedLeading: func(context.Context) { close(e.elected) },
+		OnStoppedLeading: func() {},
+	}
+	elector, err := leaderelection.NewLeaderElector(config)
+	if err != nil {
+		return err
+	}
+	elector.Run(ctx)
+	if ctx.Err() == nil {
+		return fmt.Errorf("%w for election id %q", ErrLeaderElectionLost, e.id)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	ctrlleaderelection "sigs.k8s.io/controller-runtime/pkg/leaderelection"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/recorder"
+)
+
+// memoryLock is an in memory resourcelock.Interface
+type memoryLock struct {
+	lock     sync.Mutex
+	identity string
+	record   *resourcelock.LeaderElectionRecord
+	failing  bool
+}
+
+func (l *memoryLock) Get(context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.record == nil {
+		return nil, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "leases"}, l.identity)
+	}
+	record := *l.record
+	return &record, nil, nil
+}
+
+func (l *memoryLock) Create(_ context.Context, record resourcelock.LeaderElectionRecord) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.record = &record
+	return nil
+}
+
+func (l *memoryLock) Update(_ context.Context, record resourcelock.LeaderElectionRecord) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.failing {
+		return errors.New("update failed")
+	}
+	l.record = &record
+	return nil
+}
+
+func (l *memoryLock) setFailing() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.failing = true
+}
+
+func (l *memoryLock) RecordEvent(string) {}
+func (l *memoryLock) Identity() string   { return l.identity }
+func (l *memoryLock) Describe() string   { return l.identity }
+
+// electionManager is a fakeManager providing a rest config to create resource locks
+type electionManager struct {
+	*fakeManager
+}
+
+func (m *electionManager) GetConfig() *rest.Config { return &rest.Config{} }
+
+func TestScopedManagerLeaderElection(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lock := &memoryLock{identity: "replica"}
+	mgr := &electionManager{fakeManager: newFakeManager()}
+	elector, err := newLeaderElector(mgr, "controller", LeaderElectionOptions{
+		Enabled:       true,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+		NewResourceLock: func(_ *rest.Config, _ recorder.Provider, options ctrlleaderelection.Options) (resourcelock.Interface, error) {
+			g.Expect(options.LeaderElectionID).To(Equal("controller"))
+			return lock, nil
+		},
+	})
+	g.Expect(err).To(BeNil())
+
+	scoped := newScopedManager(mgr, false)
+	scoped.elector = elector
+	var running atomic.Int32
+	g.Expect(scoped.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		running.Add(1)
+		defer running.Add(-1)
+		<-ctx.Done()
+		return nil
+	}))).To(Succeed())
+
+	exited := make(chan error, 1)
+	scoped.start(ctx, func(err error) { exited <- err })
+	g.Eventually(running.Load).Should(Equal(int32(1)))
+
+	// the runnables are stopped when the lease is lost
+	lock.setFailing()
+	var exitErr error
+	g.Eventually(exited, 5*time.Second).Should(Receive(&exitErr))
+	g.Expect(errors.Is(exitErr, ErrLeaderElectionLost)).To(BeTrue())
+	g.Expect(running.Load()).To(Equal(int32(0)))
+}
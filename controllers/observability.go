@@ -0,0 +1,138 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	"github.com/AlaudaDevops/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Results of a reconciliation used as label of metrics
+const (
+	ReconcileResultSuccess      = "success"
+	ReconcileResultRequeue      = "requeue"
+	ReconcileResultRequeueAfter = "requeue_after"
+	ReconcileResultError        = "error"
+)
+
+var (
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reconciler_wrapper_reconcile_total",
+		Help: "Number of reconciliations by controller, result and error reason",
+	}, []string{"controller", "result", "reason"})
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "reconciler_wrapper_reconcile_duration_seconds",
+		Help:    "Duration of reconciliations by controller and result",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"controller", "result"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(reconcileTotal, reconcileDuration)
+}
+
+// WithObservability adds tracing, metrics and a request logger to the reconciler,
+// see WithTracing, WithMetrics and WithRequestLogger
+func WithObservability(controllerName string) WrapperOption {
+	return func(options *WrapperOptions) {
+		WithRequestLogger(controllerName)(options)
+		WithTracing(controllerName)(options)
+		WithMetrics(controllerName)(options)
+	}
+}
+
+// WithTracing starts a span for each reconciliation using the global tracer provider,
+// the span is passed through the context and is marked as failed when the reconciliation returns an error
+func WithTracing(controllerName string) WrapperOption {
+	return func(options *WrapperOptions) {
+		options.ObserveFuncs = append(options.ObserveFuncs, TracingObserveFunc(controllerName))
+	}
+}
+
+// WithMetrics records the number and the duration of reconciliations of the controller by result,
+// the reason of errors is recorded using metav1alpha1.ReasonForError
+func WithMetrics(controllerName string) WrapperOption {
+	return func(options *WrapperOptions) {
+		options.ObserveFuncs = append(options.ObserveFuncs, MetricsObserveFunc(controllerName))
+	}
+}
+
+// WithRequestLogger injects into the context a logger with the controller name,
+// the key of the request and the reconcile ID
+func WithRequestLogger(controllerName string) WrapperOption {
+	return func(options *WrapperOptions) {
+		options.ObserveFuncs = append(options.ObserveFuncs, RequestLoggerObserveFunc(controllerName))
+	}
+}
+
+// TracingObserveFunc starts a span for each reconciliation, see WithTracing
+func TracingObserveFunc(controllerName string) ObserveFunc {
+	return func(ctx context.Context, request reconcile.Request) (context.Context, DoneFunc) {
+		ctx, span := tracing.StartReconcileSpan(ctx, controllerName, request.Namespace, request.Name)
+		return ctx, func(_ reconcile.Result, err error) {
+			tracing.EndSpan(span, err)
+		}
+	}
+}
+
+// MetricsObserveFunc records metrics of each reconciliation, see WithMetrics
+func MetricsObserveFunc(controllerName string) ObserveFunc {
+	return func(ctx context.Context, _ reconcile.Request) (context.Context, DoneFunc) {
+		start := time.Now()
+		return ctx, func(result reconcile.Result, err error) {
+			label, reason := reconcileResultLabel(result, err), ""
+			if err != nil {
+				reason = metav1alpha1.ReasonForError(err)
+			}
+			reconcileTotal.WithLabelValues(controllerName, label, reason).Inc()
+			reconcileDuration.WithLabelValues(controllerName, label).Observe(time.Since(start).Seconds())
+		}
+	}
+}
+
+// RequestLoggerObserveFunc injects a logger for the request into the context, see WithRequestLogger
+func RequestLoggerObserveFunc(controllerName string) ObserveFunc {
+	return func(ctx context.Context, request reconcile.Request) (context.Context, DoneFunc) {
+		logger := logging.FromContext(ctx).With("controller", controllerName, "request", request.String())
+		if reconcileID := controller.ReconcileIDFromContext(ctx); reconcileID != "" {
+			logger = logger.With("reconcileID", reconcileID)
+		}
+		return logging.WithLogger(ctx, logger), nil
+	}
+}
+
+// reconcileResultLabel returns the result label of a reconciliation
+func reconcileResultLabel(result reconcile.Result, err error) string {
+	switch {
+	case err != nil:
+		return ReconcileResultError
+	case result.RequeueAfter > 0:
+		return ReconcileResultRequeueAfter
+	case result.Requeue:
+		return ReconcileResultRequeue
+	default:
+		return ReconcileResultSuccess
+	}
+}
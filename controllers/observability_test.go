@@ -0,0 +1,88 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestWithObservability(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(provider)
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cm"}}
+	clt := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cm).Build()
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "cm"}}
+
+	var result reconcile.Result
+	var err error
+	r := NewReconcilerWrapper(reconcile.Func(func(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+		g.Expect(logging.FromContext(ctx)).NotTo(BeNil())
+		return result, err
+	}), WithObservability("observed"), func(options *WrapperOptions) {
+		options.Client = clt
+		options.Object = &corev1.ConfigMap{}
+	})
+
+	result = reconcile.Result{RequeueAfter: time.Minute}
+	_, reconcileErr := r.Reconcile(context.TODO(), request)
+	g.Expect(reconcileErr).To(BeNil())
+	g.Expect(testutil.ToFloat64(reconcileTotal.WithLabelValues("observed", ReconcileResultRequeueAfter, ""))).To(Equal(float64(1)))
+
+	result, err = reconcile.Result{}, apierrors.NewConflict(corev1.Resource("configmaps"), "cm", nil)
+	_, reconcileErr = r.Reconcile(context.TODO(), request)
+	g.Expect(reconcileErr).To(Equal(err))
+	g.Expect(testutil.ToFloat64(reconcileTotal.WithLabelValues("observed", ReconcileResultError, string(metav1.StatusReasonConflict)))).To(Equal(float64(1)))
+
+	spans := recorder.Ended()
+	g.Expect(spans).To(HaveLen(2))
+	g.Expect(spans[0].Name()).To(Equal("Reconcile observed"))
+	g.Expect(spans[0].Status().Code).To(Equal(codes.Ok))
+	g.Expect(spans[1].Status().Code).To(Equal(codes.Error))
+}
+
+func TestReconcileResultLabel(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(reconcileResultLabel(reconcile.Result{}, nil)).To(Equal(ReconcileResultSuccess))
+	g.Expect(reconcileResultLabel(reconcile.Result{Requeue: true}, nil)).To(Equal(ReconcileResultRequeue))
+	g.Expect(reconcileResultLabel(reconcile.Result{Requeue: true, RequeueAfter: time.Second}, nil)).To(Equal(ReconcileResultRequeueAfter))
+	g.Expect(reconcileResultLabel(reconcile.Result{}, context.Canceled)).To(Equal(ReconcileResultError))
+}
@@ -19,15 +19,21 @@ package controllers
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-// HasControllerReferenceByObjectReference has controller owner reference
+// HasControllerReferenceByObjectReference has controller owner reference.
+// Owners are compared by name, and by kind, api group and uid when set in ref,
+// the version is ignored as an owner can be referenced using any version of its kind.
 func HasControllerReferenceByObjectReference(obj metav1.Object, ref *corev1.ObjectReference, controller bool) bool {
 	if obj == nil || ref == nil {
 		return false
 	}
 	for _, owner := range obj.GetOwnerReferences() {
 		if owner.Name == ref.Name &&
+			(ref.Kind == "" || owner.Kind == ref.Kind) &&
+			(ref.APIVersion == "" || sameGroup(owner.APIVersion, ref.APIVersion)) &&
+			(ref.UID == "" || owner.UID == ref.UID) &&
 			((controller && owner.Controller != nil && *owner.Controller) ||
 				(!controller && (owner.Controller == nil || !*owner.Controller))) {
 			return true
@@ -35,3 +41,16 @@ func HasControllerReferenceByObjectReference(obj metav1.Object, ref *corev1.Obje
 	}
 	return false
 }
+
+// sameGroup returns true if both api versions are of the same group
+func sameGroup(apiVersion, other string) bool {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return false
+	}
+	otherGV, err := schema.ParseGroupVersion(other)
+	if err != nil {
+		return false
+	}
+	return gv.Group == otherGV.Group
+}
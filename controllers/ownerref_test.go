@@ -0,0 +1,46 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestHasControllerReferenceByObjectReference(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{
+		APIVersion: "v1", Kind: "Secret", Name: "owner", UID: "uid", Controller: ptr.To(true),
+	}}}}
+	g.Expect(HasControllerReferenceByObjectReference(obj, &corev1.ObjectReference{Name: "owner"}, true)).To(BeTrue())
+	g.Expect(HasControllerReferenceByObjectReference(obj, &corev1.ObjectReference{Name: "owner"}, false)).To(BeFalse())
+	g.Expect(HasControllerReferenceByObjectReference(obj, &corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Name: "owner", UID: "uid"}, true)).To(BeTrue())
+	g.Expect(HasControllerReferenceByObjectReference(obj, &corev1.ObjectReference{Kind: "ConfigMap", Name: "owner"}, true)).To(BeFalse())
+	g.Expect(HasControllerReferenceByObjectReference(obj, &corev1.ObjectReference{Name: "owner", UID: "other"}, true)).To(BeFalse())
+	g.Expect(HasControllerReferenceByObjectReference(obj, nil, true)).To(BeFalse())
+
+	// owners are matched by group regardless of the version
+	obj.OwnerReferences[0].APIVersion = "example.io/v1beta1"
+	g.Expect(HasControllerReferenceByObjectReference(obj, &corev1.ObjectReference{APIVersion: "example.io/v1", Kind: "Secret", Name: "owner"}, true)).To(BeTrue())
+	g.Expect(HasControllerReferenceByObjectReference(obj, &corev1.ObjectReference{APIVersion: "other.io/v1beta1", Kind: "Secret", Name: "owner"}, true)).To(BeFalse())
+	g.Expect(HasControllerReferenceByObjectReference(obj, &corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Name: "owner"}, true)).To(BeFalse())
+}
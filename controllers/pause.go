@@ -0,0 +1,118 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// PausedConditionReason is the reason of the Paused condition set on paused objects
+const PausedConditionReason = "Paused"
+
+// WithPauseAndResync honors the pause and resync annotations of the reconciled objects:
+//   - objects with the metav1alpha1.PausedAnnotationKey annotation set to "true" are not reconciled,
+//     including deleted objects, and a Paused condition is set on objects implementing duckv1.KRShaped
+//   - the metav1alpha1.ResyncRequestedAnnotationKey annotation is removed once the object is reconciled successfully
+//
+// Controllers filtering events using predicates should add PauseResyncPredicate
+// to be notified when the annotations change.
+func WithPauseAndResync(clt client.Client) WrapperOption {
+	return func(options *WrapperOptions) {
+		options.RequestFuncs = append(options.RequestFuncs, PauseRequestFunc(clt))
+		options.ResultFuncs = append(options.ResultFuncs, ResyncResultFunc(clt))
+	}
+}
+
+// PauseResyncPredicate returns a predicate accepting changes of the pause and resync annotations
+func PauseResyncPredicate() predicate.Predicate {
+	return AnnotationChangedPredicate{Keys: []string{metav1alpha1.PausedAnnotationKey, metav1alpha1.ResyncRequestedAnnotationKey}}
+}
+
+// PauseRequestFunc skips the reconciliation of paused objects, see WithPauseAndResync
+func PauseRequestFunc(clt client.Client) RequestFunc {
+	return func(ctx context.Context, request reconcile.Request) (context.Context, error) {
+		obj := ReconcileObjectCtx(ctx)
+		if obj == nil {
+			return ctx, nil
+		}
+		paused := metav1alpha1.IsPaused(obj)
+		if err := markPaused(ctx, clt, obj, paused); err != nil {
+			return ctx, err
+		}
+		if paused {
+			logging.FromContext(ctx).Debugw("reconciliation is paused", "request", request.String())
+			return ctx, ErrSkipReconcile
+		}
+		return ctx, nil
+	}
+}
+
+// ResyncResultFunc removes the resync annotation of reconciled objects, see WithPauseAndResync
+func ResyncResultFunc(clt client.Client) ResultFunc {
+	return func(ctx context.Context, _ reconcile.Request, _ *reconcile.Result) error {
+		obj := ReconcileObjectCtx(ctx)
+		if obj == nil || !metav1alpha1.IsResyncRequested(obj) {
+			return nil
+		}
+		toUpdate := obj.DeepCopyObject().(client.Object)
+		annotations := toUpdate.GetAnnotations()
+		delete(annotations, metav1alpha1.ResyncRequestedAnnotationKey)
+		toUpdate.SetAnnotations(annotations)
+		return client.IgnoreNotFound(clt.Patch(ctx, toUpdate, client.MergeFrom(obj)))
+	}
+}
+
+// markPaused sets the Paused condition of paused objects and clears it from other objects,
+// objects not implementing duckv1.KRShaped are ignored
+func markPaused(ctx context.Context, clt client.Client, obj client.Object, paused bool) error {
+	shaped, ok := obj.(duckv1.KRShaped)
+	if !ok {
+		return nil
+	}
+	original := obj.DeepCopyObject().(client.Object)
+	oldConditions := shaped.GetStatus().GetConditions()
+
+	conditionType := apis.ConditionType(metav1alpha1.ConditionPaused)
+	manager := shaped.GetConditionSet().Manage(shaped.GetStatus())
+	if paused {
+		// the condition is set directly to not recompute the happy condition
+		manager.SetCondition(apis.Condition{
+			Type:     conditionType,
+			Status:   corev1.ConditionTrue,
+			Severity: apis.ConditionSeverityInfo,
+			Reason:   PausedConditionReason,
+			Message:  fmt.Sprintf("reconciliation is paused by the annotation %s", metav1alpha1.PausedAnnotationKey),
+		})
+	} else if err := manager.ClearCondition(conditionType); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(oldConditions, shaped.GetStatus().GetConditions()) {
+		return nil
+	}
+	return client.IgnoreNotFound(clt.Status().Patch(ctx, obj, client.MergeFrom(original)))
+}
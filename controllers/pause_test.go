@@ -0,0 +1,87 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestWithPauseAndResync(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "test.io", Version: "v1", Kind: "KResource"}, &duckv1.KResource{})
+	obj := &duckv1.KResource{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "obj",
+		Annotations: map[string]string{
+			metav1alpha1.PausedAnnotationKey:          "true",
+			metav1alpha1.ResyncRequestedAnnotationKey: "2023-01-01T00:00:00Z",
+		},
+	}}
+	clt := fake.NewClientBuilder().WithScheme(scheme).WithObjects(obj).WithStatusSubresource(obj).Build()
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "obj"}}
+	pausedCondition := apis.ConditionType(metav1alpha1.ConditionPaused)
+
+	reconciled := 0
+	r := NewReconcilerWrapper(reconcile.Func(func(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+		reconciled++
+		return reconcile.Result{}, nil
+	}), WithPauseAndResync(clt), func(options *WrapperOptions) {
+		options.Client = clt
+		options.Object = &duckv1.KResource{}
+	})
+
+	// paused objects are skipped and marked as paused
+	_, err := r.Reconcile(ctx, request)
+	g.Expect(err).To(BeNil())
+	g.Expect(reconciled).To(Equal(0))
+
+	current := &duckv1.KResource{}
+	g.Expect(clt.Get(ctx, request.NamespacedName, current)).To(Succeed())
+	g.Expect(current.Status.GetCondition(pausedCondition)).NotTo(BeNil())
+	g.Expect(current.Status.GetCondition(pausedCondition).IsTrue()).To(BeTrue())
+	g.Expect(current.Annotations).To(HaveKey(metav1alpha1.ResyncRequestedAnnotationKey))
+
+	// resumed objects are reconciled and the resync annotation is removed
+	patch := client.MergeFrom(current.DeepCopy())
+	delete(current.Annotations, metav1alpha1.PausedAnnotationKey)
+	g.Expect(clt.Patch(ctx, current, patch)).To(Succeed())
+
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).To(BeNil())
+	g.Expect(reconciled).To(Equal(1))
+
+	current = &duckv1.KResource{}
+	g.Expect(clt.Get(ctx, request.NamespacedName, current)).To(Succeed())
+	g.Expect(current.Status.GetCondition(pausedCondition)).To(BeNil())
+	g.Expect(current.Annotations).NotTo(HaveKey(metav1alpha1.ResyncRequestedAnnotationKey))
+}
@@ -17,9 +17,21 @@ limitations under the License.
 package controllers
 
 import (
+	"fmt"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/AlaudaDevops/pkg/config"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
@@ -31,17 +43,46 @@ type SecretDataChangedPredicate struct {
 
 // Update implements default UpdateEvent filter for validating generation change.
 func (SecretDataChangedPredicate) Update(e event.UpdateEvent) bool {
-	if e.ObjectOld == nil {
-		return false
-	}
-	oldObj := e.ObjectOld.(*corev1.Secret)
+	return TypedUpdateFunc(func(oldObj, newObj *corev1.Secret) bool {
+		return !reflect.DeepEqual(oldObj.Data, newObj.Data)
+	})(e)
+}
 
-	if e.ObjectNew == nil {
-		return false
+// ConfigMapDataChangedPredicate implements a default update predicate function on configmap data change.
+type ConfigMapDataChangedPredicate struct {
+	predicate.Funcs
+}
+
+// Update implements default UpdateEvent filter for validating data change.
+func (ConfigMapDataChangedPredicate) Update(e event.UpdateEvent) bool {
+	return TypedUpdateFunc(func(oldObj, newObj *corev1.ConfigMap) bool {
+		return !reflect.DeepEqual(oldObj.Data, newObj.Data) || !reflect.DeepEqual(oldObj.BinaryData, newObj.BinaryData)
+	})(e)
+}
+
+// TypedUpdateFunc returns an update event filter invoking changed with the objects of the event as T.
+// Events with a nil object or an object which is not a T are filtered out.
+func TypedUpdateFunc[T client.Object](changed func(oldObj, newObj T) bool) func(event.UpdateEvent) bool {
+	return func(e event.UpdateEvent) bool {
+		if e.ObjectOld == nil || e.ObjectNew == nil {
+			return false
+		}
+		oldObj, ok := e.ObjectOld.(T)
+		if !ok {
+			return false
+		}
+		newObj, ok := e.ObjectNew.(T)
+		if !ok {
+			return false
+		}
+		return changed(oldObj, newObj)
 	}
-	newObj := e.ObjectNew.(*corev1.Secret)
+}
 
-	return !reflect.DeepEqual(oldObj.Data, newObj.Data)
+// TypedChangedPredicate returns a predicate filtering update events using changed, see TypedUpdateFunc.
+// Create, delete and generic events are accepted.
+func TypedChangedPredicate[T client.Object](changed func(oldObj, newObj T) bool) predicate.Funcs {
+	return predicate.Funcs{UpdateFunc: TypedUpdateFunc(changed)}
 }
 
 // AnnotationChangedPredicate implements a predicate that checks for changes in specific annotations.
@@ -105,6 +146,193 @@ func (p AnnotationChangedPredicate) Update(e event.UpdateEvent) bool {
 	return valuesChangeInMap(p.Keys, e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations())
 }
 
+// LabelChangedPredicate implements a predicate that checks for changes in specific labels.
+// It extends the default LabelChangedPredicate from controller-runtime and allows filtering
+// on specific label keys.
+type LabelChangedPredicate struct {
+	// Keys is a list of label keys to watch for changes.
+	// If empty, all label changes will be considered.
+	Keys []string
+	predicate.LabelChangedPredicate
+}
+
+// Create implements Predicate interface for creation events.
+// It checks if any of the specified label keys have changed from nil to a value.
+func (p LabelChangedPredicate) Create(e event.CreateEvent) bool {
+	if len(p.Keys) == 0 {
+		return p.LabelChangedPredicate.Create(e)
+	}
+	return valuesChangeInMap(p.Keys, nil, e.Object.GetLabels())
+}
+
+// Delete implements Predicate interface for deletion events.
+// It checks if any of the specified label keys have changed from a value to nil.
+func (p LabelChangedPredicate) Delete(e event.DeleteEvent) bool {
+	if len(p.Keys) == 0 {
+		return p.LabelChangedPredicate.Delete(e)
+	}
+	return valuesChangeInMap(p.Keys, e.Object.GetLabels(), nil)
+}
+
+// Generic implements Predicate interface for generic events.
+// It checks if any of the specified label keys have changed.
+func (p LabelChangedPredicate) Generic(e event.GenericEvent) bool {
+	if len(p.Keys) == 0 {
+		return p.LabelChangedPredicate.Generic(e)
+	}
+	return valuesChangeInMap(p.Keys, e.Object.GetLabels(), nil)
+}
+
+// Update implements Predicate interface for update events.
+// It checks if any of the specified label keys have different values between old and new objects.
+func (p LabelChangedPredicate) Update(e event.UpdateEvent) bool {
+	if len(p.Keys) == 0 {
+		return p.LabelChangedPredicate.Update(e)
+	}
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+	return valuesChangeInMap(p.Keys, e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+}
+
+// FieldPathChangedPredicate implements an update predicate checking for changes of fields
+// selected using JSONPath expressions on the unstructured content of the objects, e.g. "{.spec.replicas}".
+// Create, delete and generic events are accepted, the zero value accepts no update.
+type FieldPathChangedPredicate struct {
+	predicate.Funcs
+
+	// lock protects paths, JSONPath is not safe for concurrent use
+	lock  sync.Mutex
+	paths []*jsonpath.JSONPath
+}
+
+// NewFieldPathChangedPredicate returns a predicate checking for changes of the fields selected by paths,
+// the braces of the expressions are optional.
+func NewFieldPathChangedPredicate(paths ...string) (*FieldPathChangedPredicate, error) {
+	p := &FieldPathChangedPredicate{}
+	for _, path := range paths {
+		expression := path
+		if !strings.HasPrefix(expression, "{") {
+			expression = "{" + expression + "}"
+		}
+		parsed := jsonpath.New(path).AllowMissingKeys(true)
+		if err := parsed.Parse(expression); err != nil {
+			return nil, fmt.Errorf("invalid field path %q: %w", path, err)
+		}
+		p.paths = append(p.paths, parsed)
+	}
+	return p, nil
+}
+
+// Update implements Predicate interface for update events.
+// It checks if any of the selected fields have different values between old and new objects,
+// objects which cannot be evaluated are considered changed.
+func (p *FieldPathChangedPredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+	oldContent, err := unstructuredContent(e.ObjectOld)
+	if err != nil {
+		return true
+	}
+	newContent, err := unstructuredContent(e.ObjectNew)
+	if err != nil {
+		return true
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, path := range p.paths {
+		oldValues, err := findValues(path, oldContent)
+		if err != nil {
+			return true
+		}
+		newValues, err := findValues(path, newContent)
+		if err != nil {
+			return true
+		}
+		if !equality.Semantic.DeepEqual(oldValues, newValues) {
+			return true
+		}
+	}
+	return false
+}
+
+// unstructuredContent returns the unstructured content of the object
+func unstructuredContent(obj client.Object) (map[string]interface{}, error) {
+	if u, ok := obj.(runtime.Unstructured); ok {
+		return u.UnstructuredContent(), nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// findValues returns the values selected by the path in content
+func findValues(path *jsonpath.JSONPath, content map[string]interface{}) ([]interface{}, error) {
+	results, err := path.FindResults(content)
+	if err != nil {
+		return nil, err
+	}
+	values := []interface{}{}
+	for _, result := range results {
+		for _, value := range result {
+			values = append(values, value.Interface())
+		}
+	}
+	return values, nil
+}
+
+// NamespacePredicate returns a predicate accepting only the events of objects in the namespaces
+// included and not excluded by the configuration of the controller,
+// see config.ControllerIncludeNamespacesSetting and config.ControllerExcludeNamespacesSetting.
+// The lists are read on each event so that changes apply without restarting the controller,
+// events of cluster scoped objects are always accepted.
+func NamespacePredicate(manager config.ManagerInterface, name string) predicate.Funcs {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		namespace := obj.GetNamespace()
+		if namespace == "" {
+			return true
+		}
+		include, _ := config.GetFlag[string](manager, config.ControllerKey(name, config.ControllerIncludeNamespacesSetting))
+		exclude, _ := config.GetFlag[string](manager, config.ControllerKey(name, config.ControllerExcludeNamespacesSetting))
+		if included := splitList(include); len(included) > 0 && !slices.Contains(included, namespace) {
+			return false
+		}
+		return !slices.Contains(splitList(exclude), namespace)
+	})
+}
+
+// splitList returns the trimmed non empty items of a comma separated list
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// LabelSelectorMatchPredicate returns a predicate accepting only the events of objects matching the selector.
+// Update events are accepted if either the old or the new object matches,
+// so that objects no longer matching the selector are reconciled once more.
+func LabelSelectorMatchPredicate(selector metav1.LabelSelector) (predicate.Funcs, error) {
+	parsed, err := metav1.LabelSelectorAsSelector(&selector)
+	if err != nil {
+		return predicate.Funcs{}, err
+	}
+	matches := func(obj client.Object) bool {
+		return obj != nil && parsed.Matches(labels.Set(obj.GetLabels()))
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return matches(e.Object) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return matches(e.Object) },
+		GenericFunc: func(e event.GenericEvent) bool { return matches(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return matches(e.ObjectOld) || matches(e.ObjectNew)
+		},
+	}, nil
+}
+
 // valuesChangeInMap checks if any of the specified keys have different values in two maps.
 // Returns true if there's a difference in values for any of the specified keys.
 func valuesChangeInMap(keys []string, old, new map[string]string) bool {
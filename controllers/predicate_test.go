@@ -19,8 +19,13 @@ package controllers
 import (
 	"testing"
 
+	"github.com/AlaudaDevops/pkg/config"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
@@ -208,3 +213,104 @@ func TestAnnotationChangedPredicate(t *testing.T) {
 		})
 	}
 }
+
+func TestSecretDataChangedPredicateOtherTypes(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	e := event.UpdateEvent{ObjectOld: &corev1.ConfigMap{}, ObjectNew: &corev1.ConfigMap{}}
+	g.Expect(SecretDataChangedPredicate{}.Update(e)).To(BeFalse())
+	g.Expect(SecretDataChangedPredicate{}.Update(event.UpdateEvent{})).To(BeFalse())
+}
+
+func TestConfigMapDataChangedPredicate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	old := &corev1.ConfigMap{Data: map[string]string{"a": "1"}}
+	g.Expect(ConfigMapDataChangedPredicate{}.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: old.DeepCopy()})).To(BeFalse())
+	changed := &corev1.ConfigMap{Data: map[string]string{"a": "2"}}
+	g.Expect(ConfigMapDataChangedPredicate{}.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: changed})).To(BeTrue())
+	binary := &corev1.ConfigMap{Data: old.Data, BinaryData: map[string][]byte{"b": []byte("1")}}
+	g.Expect(ConfigMapDataChangedPredicate{}.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: binary})).To(BeTrue())
+	g.Expect(ConfigMapDataChangedPredicate{}.Update(event.UpdateEvent{ObjectOld: &corev1.Secret{}, ObjectNew: &corev1.Secret{}})).To(BeFalse())
+}
+
+func TestLabelChangedPredicate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	pred := LabelChangedPredicate{Keys: []string{"test"}}
+	oldObj := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"test": "a", "other": "a"}}}
+	newObj := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"test": "a", "other": "b"}}}
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj})).To(BeFalse())
+	g.Expect(LabelChangedPredicate{}.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj})).To(BeTrue())
+
+	newObj.Labels["test"] = "b"
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj})).To(BeTrue())
+	g.Expect(pred.Create(event.CreateEvent{Object: &corev1.Pod{}})).To(BeFalse())
+	g.Expect(pred.Delete(event.DeleteEvent{Object: oldObj})).To(BeTrue())
+}
+
+func TestFieldPathChangedPredicate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := NewFieldPathChangedPredicate("{.spec[")
+	g.Expect(err).To(HaveOccurred())
+
+	pred, err := NewFieldPathChangedPredicate(".spec.replicas", "{.spec.template.spec.containers[*].image}")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	oldObj := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{
+		Replicas: ptr.To[int32](1),
+		Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "a", Image: "a:1"}}}},
+	}}
+	newObj := oldObj.DeepCopy()
+	newObj.Labels = map[string]string{"a": "b"}
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj})).To(BeFalse())
+
+	newObj.Spec.Template.Spec.Containers[0].Image = "a:2"
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj})).To(BeTrue())
+
+	// unstructured objects and missing fields are supported
+	oldU := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+	newU := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(2)}}}
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: oldU, ObjectNew: oldU.DeepCopy()})).To(BeFalse())
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: oldU, ObjectNew: newU})).To(BeTrue())
+	g.Expect(pred.Create(event.CreateEvent{Object: oldU})).To(BeTrue())
+
+	// the zero value accepts no update
+	zero := &FieldPathChangedPredicate{}
+	g.Expect(zero.Update(event.UpdateEvent{ObjectOld: oldU, ObjectNew: newU})).To(BeFalse())
+}
+
+func TestNamespacePredicate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	manager := &config.Manager{Config: &config.Config{Data: map[string]string{}}}
+	pred := NamespacePredicate(manager, "foo")
+	inNamespace := func(namespace string) event.GenericEvent {
+		return event.GenericEvent{Object: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}}}
+	}
+	g.Expect(pred.Generic(inNamespace("a"))).To(BeTrue())
+
+	manager.Config.Data[config.ControllerKey("foo", config.ControllerIncludeNamespacesSetting)] = "a, b"
+	manager.Config.Data[config.ControllerKey("foo", config.ControllerExcludeNamespacesSetting)] = "b"
+	g.Expect(pred.Generic(inNamespace("a"))).To(BeTrue())
+	g.Expect(pred.Generic(inNamespace("b"))).To(BeFalse())
+	g.Expect(pred.Generic(inNamespace("c"))).To(BeFalse())
+	g.Expect(pred.Generic(inNamespace(""))).To(BeTrue())
+}
+
+func TestLabelSelectorMatchPredicate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, err := LabelSelectorMatchPredicate(metav1.LabelSelector{MatchLabels: map[string]string{"a": "-"}})
+	g.Expect(err).To(HaveOccurred())
+
+	pred, err := LabelSelectorMatchPredicate(metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo"}})
+	g.Expect(err).NotTo(HaveOccurred())
+	matching := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "foo"}}}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "bar"}}}
+	g.Expect(pred.Create(event.CreateEvent{Object: matching})).To(BeTrue())
+	g.Expect(pred.Create(event.CreateEvent{Object: other})).To(BeFalse())
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: matching, ObjectNew: other})).To(BeTrue())
+	g.Expect(pred.Update(event.UpdateEvent{ObjectOld: other, ObjectNew: other})).To(BeFalse())
+}
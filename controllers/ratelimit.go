@@ -17,6 +17,8 @@ limitations under the License.
 package controllers
 
 import (
+	"math"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -37,7 +39,7 @@ func DefaultRateLimiter() workqueue.RateLimiter {
 // DefaultTypedRateLimiter returns a workqueue rate limiter with a starting value of 2 seconds
 // opposed to controller-runtime's default one of 1 millisecond
 func DefaultTypedRateLimiter[T comparable]() workqueue.TypedRateLimiter[T] {
-	return TypedRateLimiter[T](2*time.Second, 1000*time.Second)
+	return TypedRateLimiter[T](DefaultBaseDelay, DefaultMaxDelay)
 }
 
 // TypedRateLimiter returns a workqueue rate limiter with value of baseDelay and maxDelay
@@ -46,6 +48,71 @@ func TypedRateLimiter[T comparable](baseDelay time.Duration, maxDelay time.Durat
 	return workqueue.NewTypedMaxOfRateLimiter(
 		workqueue.NewTypedItemExponentialFailureRateLimiter[T](baseDelay, maxDelay),
 		// 10 qps, 100 bucket size.  This is only for retry speed and its only the overall factor (not per item)
-		&workqueue.TypedBucketRateLimiter[T]{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+		&workqueue.TypedBucketRateLimiter[T]{Limiter: rate.NewLimiter(rate.Limit(DefaultQPS), DefaultBurst)},
 	)
 }
+
+// ConfigurableRateLimiter is a workqueue rate limiter combining a per item exponential backoff
+// and an overall bucket limiter, whose settings can be updated while the controller is running
+type ConfigurableRateLimiter[T comparable] struct {
+	lock      sync.Mutex
+	failures  map[T]int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	limiter   *rate.Limiter
+}
+
+var _ workqueue.TypedRateLimiter[string] = &ConfigurableRateLimiter[string]{}
+
+// NewConfigurableRateLimiter returns a rate limiter using the delays, qps and burst of settings
+func NewConfigurableRateLimiter[T comparable](settings ControllerSettings) *ConfigurableRateLimiter[T] {
+	return &ConfigurableRateLimiter[T]{
+		failures:  map[T]int{},
+		baseDelay: settings.BaseDelay,
+		maxDelay:  settings.MaxDelay,
+		limiter:   rate.NewLimiter(rate.Limit(settings.QPS), settings.Burst),
+	}
+}
+
+// When returns the longest delay of the exponential backoff of the item and of the bucket limiter
+func (r *ConfigurableRateLimiter[T]) When(item T) time.Duration {
+	r.lock.Lock()
+	exp := r.failures[item]
+	r.failures[item] = exp + 1
+	backoff := float64(r.baseDelay.Nanoseconds()) * math.Pow(2, float64(exp))
+	delay := r.maxDelay
+	if backoff < float64(r.maxDelay.Nanoseconds()) {
+		delay = time.Duration(backoff)
+	}
+	r.lock.Unlock()
+
+	if bucket := r.limiter.Reserve().Delay(); bucket > delay {
+		return bucket
+	}
+	return delay
+}
+
+// Forget stops tracking the failures of the item
+func (r *ConfigurableRateLimiter[T]) Forget(item T) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.failures, item)
+}
+
+// NumRequeues returns the number of failures of the item
+func (r *ConfigurableRateLimiter[T]) NumRequeues(item T) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.failures[item]
+}
+
+// Update applies the delays, qps and burst of settings, failures of the items are kept
+func (r *ConfigurableRateLimiter[T]) Update(settings ControllerSettings) {
+	r.lock.Lock()
+	r.baseDelay = settings.BaseDelay
+	r.maxDelay = settings.MaxDelay
+	r.lock.Unlock()
+
+	r.limiter.SetLimit(rate.Limit(settings.QPS))
+	r.limiter.SetBurst(settings.Burst)
+}
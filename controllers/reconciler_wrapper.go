@@ -19,6 +19,7 @@ package controllers
 // Importing necessary packages.
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"runtime"
@@ -29,14 +30,26 @@ import (
 
 type WrapperOption func(*WrapperOptions)
 
+// ErrSkipReconcile can be returned by a RequestFunc to skip the reconciliation of the request without error
+var ErrSkipReconcile = errors.New("skip reconcile")
+
 type RequestFunc func(ctx context.Context, request reconcile.Request) (context.Context, error)
 
 type ResultFunc func(ctx context.Context, request reconcile.Request, result *reconcile.Result) error
 
+// DoneFunc is invoked with the final result and error of a reconciliation
+type DoneFunc func(result reconcile.Result, err error)
+
+// ObserveFunc is invoked before the object of the request is fetched,
+// the returned DoneFunc, if not nil, is invoked once the reconciliation finished.
+// It is used to observe the whole reconciliation, e.g. tracing, metrics and logging.
+type ObserveFunc func(ctx context.Context, request reconcile.Request) (context.Context, DoneFunc)
+
 // scheduleReconciler is a wrapper for Reconciler interface from controller-runtime package.
 type reconcilerWrapper struct {
 	reconciler reconcile.Reconciler
 
+	observeFuncs []ObserveFunc
 	requestFuncs []RequestFunc
 	resultFuncs  []ResultFunc
 	Object       client.Object
@@ -45,6 +58,7 @@ type reconcilerWrapper struct {
 
 type WrapperOptions struct {
 	// define the synchronization interval.
+	ObserveFuncs []ObserveFunc
 	RequestFuncs []RequestFunc
 	ResultFuncs  []ResultFunc
 	Object       client.Object
@@ -60,6 +74,7 @@ func NewReconcilerWrapper(r reconcile.Reconciler, opts ...WrapperOption) reconci
 
 	return &reconcilerWrapper{
 		reconciler:   r,
+		observeFuncs: options.ObserveFuncs,
 		requestFuncs: options.RequestFuncs,
 		resultFuncs:  options.ResultFuncs,
 		Client:       options.Client,
@@ -69,7 +84,16 @@ func NewReconcilerWrapper(r reconcile.Reconciler, opts ...WrapperOption) reconci
 
 // Reconcile is the method that will be called whenever an event occurs that the Reconciler should handle.
 // It calls the Reconcile method of the embedded Reconciler and adjusts the RequeueAfter
-func (s *reconcilerWrapper) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+func (s *reconcilerWrapper) Reconcile(ctx context.Context, request reconcile.Request) (result reconcile.Result, err error) {
+	// observe the whole reconciliation, done funcs are invoked in reverse order
+	for _, observeFunc := range s.observeFuncs {
+		var done DoneFunc
+		ctx, done = observeFunc(ctx, request)
+		if done != nil {
+			defer func() { done(result, err) }()
+		}
+	}
+
 	if s.reconciler == nil {
 		return reconcile.Result{}, fmt.Errorf("reconciler should not be empty")
 	}
@@ -78,7 +102,9 @@ func (s *reconcilerWrapper) Reconcile(ctx context.Context, request reconcile.Req
 		return reconcile.Result{}, fmt.Errorf("reconciler object should not be empty")
 	}
 
-	err := s.Client.Get(ctx, request.NamespacedName, s.Object)
+	// each request gets its own object as requests can be reconciled concurrently
+	obj := s.Object.DeepCopyObject().(client.Object)
+	err = s.Client.Get(ctx, request.NamespacedName, obj)
 	if err != nil {
 		// if object does not exist, do nothing.
 		// when the DeletionTimestamp is not 0, should not return here, as its
@@ -87,15 +113,19 @@ func (s *reconcilerWrapper) Reconcile(ctx context.Context, request reconcile.Req
 	}
 
 	// modify request context
+	ctx = WithReconcileObject(ctx, obj)
 	for _, requestFunc := range s.requestFuncs {
 		requestCtx, err := requestFunc(ctx, request)
+		if errors.Is(err, ErrSkipReconcile) {
+			return reconcile.Result{}, nil
+		}
 		if err != nil {
 			return reconcile.Result{}, funcError(requestFunc, err)
 		}
 		ctx = requestCtx
 	}
 
-	result, err := s.reconciler.Reconcile(ctx, request)
+	result, err = s.reconciler.Reconcile(ctx, request)
 	if err != nil {
 		return result, err
 	}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// scopedManager is a manager.Manager which keeps the runnables added during a controller setup
+// instead of adding them to the manager, so they can be started and stopped independently
+// from the manager while still sharing its cache, clients and leader election.
+type scopedManager struct {
+	manager.Manager
+
+	// reload is true when the controller was already set up once,
+	// controller name validation is skipped because the name is already registered.
+	reload bool
+
+	// elector elects the runnables needing leader election instead of the manager, if not nil
+	elector *leaderElector
+
+	lock      sync.Mutex
+	runnables []manager.Runnable
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func newScopedManager(mgr manager.Manager, reload bool) *scopedManager {
+	return &scopedManager{Manager: mgr, reload: reload}
+}
+
+// Add keeps the runnable to be started by start
+func (m *scopedManager) Add(r manager.Runnable) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.runnables = append(m.runnables, r)
+	return nil
+}
+
+// GetControllerOptions returns the controller options of the manager
+// skipping the name validation when the controller is set up again
+func (m *scopedManager) GetControllerOptions() config.Controller {
+	options := m.Manager.GetControllerOptions()
+	if m.reload {
+		options.SkipNameValidation = ptr.To(true)
+	}
+	return options
+}
+
+// start runs all runnables in their own context until stop is invoked or any of them returns an error.
+// Runnables needing leader election are only started once the manager is elected,
+// or once the elector is elected when the controller has its own election ID.
+// onExit is invoked once all runnables returned, with the first error returned if any.
+func (m *scopedManager) start(ctx context.Context, onExit func(error)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.cancel != nil {
+		// already started
+		return
+	}
+
+	var runCtx context.Context
+	runCtx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	runnables := append([]manager.Runnable{}, m.runnables...)
+
+	go func() {
+		defer close(m.done)
+		var (
+			wg   sync.WaitGroup
+			lock sync.Mutex
+			errs []error
+		)
+		if cache := m.Manager.GetCache(); cache != nil && !cache.WaitForCacheSync(runCtx) {
+			onExit(runCtx.Err())
+			return
+		}
+		elected := m.Manager.Elected()
+		if m.elector != nil {
+			elected = m.elector.Elected()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := m.elector.run(runCtx); err != nil {
+					lock.Lock()
+					errs = append(errs, err)
+					lock.Unlock()
+					m.cancel()
+				}
+			}()
+		}
+		for _, runnable := range runnables {
+			wg.Add(1)
+			go func(r manager.Runnable) {
+				defer wg.Done()
+				if needLeaderElection(r) {
+					select {
+					case <-elected:
+					case <-runCtx.Done():
+						return
+					}
+				}
+				if err := r.Start(runCtx); err != nil && !errors.Is(err, context.Canceled) {
+					lock.Lock()
+					errs = append(errs, err)
+					lock.Unlock()
+					// stops the other runnables of the same controller
+					m.cancel()
+				}
+			}(runnable)
+		}
+		wg.Wait()
+		onExit(utilerrors.NewAggregate(errs))
+	}()
+}
+
+// stop cancels all runnables and waits until they returned
+func (m *scopedManager) stop() {
+	m.lock.Lock()
+	cancel, done := m.cancel, m.done
+	m.lock.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// needLeaderElection returns true if the runnable should only run on the leader,
+// consistent with the default of the manager
+func needLeaderElection(r manager.Runnable) bool {
+	if leRunnable, ok := r.(manager.LeaderElectionRunnable); ok {
+		return leRunnable.NeedLeaderElection()
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/AlaudaDevops/pkg/sharding"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// WithSharding skips the requests of objects not belonging to the shards held by the replica,
+// so that requests queued before a rebalancing are not reconciled by two replicas.
+// Requests are never skipped for a nil coordinator.
+//
+// Sharded controllers should also filter events using sharding.Coordinator.Predicate,
+// watch sharding.Coordinator.Source to reconcile the objects of acquired shards
+// and disable leader election using NeedLeaderElection.
+func WithSharding(coordinator *sharding.Coordinator) WrapperOption {
+	return func(options *WrapperOptions) {
+		options.RequestFuncs = append(options.RequestFuncs, ShardingRequestFunc(coordinator))
+	}
+}
+
+// ShardingRequestFunc skips the requests of objects owned by other replicas, see WithSharding
+func ShardingRequestFunc(coordinator *sharding.Coordinator) RequestFunc {
+	return func(ctx context.Context, request reconcile.Request) (context.Context, error) {
+		if !coordinator.Owns(request.Namespace, request.Name) {
+			return ctx, ErrSkipReconcile
+		}
+		return ctx, nil
+	}
+}
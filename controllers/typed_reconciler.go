@@ -0,0 +1,166 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/AlaudaDevops/pkg/finalizer"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// TypedReconcileFunc reconciles an object fetched by TypedReconciler,
+// changes to the status of the object are patched after the function returns
+type TypedReconcileFunc[T client.Object] func(ctx context.Context, obj T) (reconcile.Result, error)
+
+// TypedReconciler is a reconcile.Reconciler fetching a new object of type T for each request,
+// so it is safe to reconcile requests concurrently.
+// Objects are fetched by a reconciler wrapper, see NewReconcilerWrapper, so that WrapperOptions
+// like WithPauseAndResync or WithObservability apply, and objects not found are ignored.
+// When Finalizer is set, the finalizer is added to objects before ReconcileFunc is invoked,
+// and FinalizeFunc is invoked for deleted objects before removing the finalizer.
+// The status of the object is patched only when it was changed by the functions.
+type TypedReconciler[T client.Object] struct {
+	Client client.Client
+
+	// ReconcileFunc is invoked for objects not being deleted
+	ReconcileFunc TypedReconcileFunc[T]
+
+	// Finalizer is the finalizer added to objects, no finalizer is added if empty
+	Finalizer string
+	// FinalizeFunc is invoked for deleted objects having the Finalizer,
+	// the finalizer is removed when it returns an empty result without error
+	FinalizeFunc TypedReconcileFunc[T]
+
+	// WrapperOptions are the options of the wrapper fetching the objects
+	WrapperOptions []WrapperOption
+}
+
+var _ reconcile.Reconciler = &TypedReconciler[client.Object]{}
+
+// NewTypedReconciler returns a reconciler invoking fn with the object of each request
+func NewTypedReconciler[T client.Object](clt client.Client, fn TypedReconcileFunc[T]) *TypedReconciler[T] {
+	return &TypedReconciler[T]{Client: clt, ReconcileFunc: fn}
+}
+
+// WithFinalizer sets the finalizer of the objects and the function invoked when they are deleted
+func (r *TypedReconciler[T]) WithFinalizer(name string, fn TypedReconcileFunc[T]) *TypedReconciler[T] {
+	r.Finalizer = name
+	r.FinalizeFunc = fn
+	return r
+}
+
+// WithWrapperOptions adds options to the wrapper fetching the objects
+func (r *TypedReconciler[T]) WithWrapperOptions(opts ...WrapperOption) *TypedReconciler[T] {
+	r.WrapperOptions = append(r.WrapperOptions, opts...)
+	return r
+}
+
+// Reconcile fetches the object of the request and invokes ReconcileFunc or FinalizeFunc
+func (r *TypedReconciler[T]) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	opts := append([]WrapperOption{func(options *WrapperOptions) {
+		options.Object = r.newObject()
+		options.Client = r.Client
+	}}, r.WrapperOptions...)
+	return NewReconcilerWrapper(reconcile.Func(r.reconcileObject), opts...).Reconcile(ctx, request)
+}
+
+// reconcileObject invokes ReconcileFunc or FinalizeFunc with the object fetched by the wrapper
+func (r *TypedReconciler[T]) reconcileObject(ctx context.Context, request reconcile.Request) (result reconcile.Result, err error) {
+	obj, ok := ReconcileObjectCtx(ctx).(T)
+	if !ok {
+		return reconcile.Result{}, fmt.Errorf("object of request %s is not a %T", request.NamespacedName, r.newObject())
+	}
+	original := obj.DeepCopyObject().(client.Object)
+
+	if !obj.GetDeletionTimestamp().IsZero() {
+		if r.Finalizer == "" || !controllerutil.ContainsFinalizer(obj, r.Finalizer) {
+			return reconcile.Result{}, nil
+		}
+		if r.FinalizeFunc != nil {
+			result, err = r.FinalizeFunc(ctx, obj)
+		}
+		if patchErr := r.patchStatus(ctx, original, obj); patchErr != nil {
+			return result, utilerrors.NewAggregate([]error{err, patchErr})
+		}
+		if err != nil || !result.IsZero() {
+			return result, err
+		}
+		return result, finalizer.RemoveFinalizer(ctx, r.Client, obj, r.Finalizer, nil)
+	}
+
+	if r.Finalizer != "" {
+		if err = finalizer.AddFinalizer(ctx, r.Client, obj, r.Finalizer); err != nil {
+			return reconcile.Result{}, err
+		}
+		original.SetFinalizers(obj.GetFinalizers())
+		original.SetResourceVersion(obj.GetResourceVersion())
+	}
+
+	if r.ReconcileFunc != nil {
+		result, err = r.ReconcileFunc(ctx, obj)
+	}
+	if patchErr := r.patchStatus(ctx, original, obj); patchErr != nil {
+		return result, utilerrors.NewAggregate([]error{err, patchErr})
+	}
+	return result, err
+}
+
+// newObject allocates an empty object of type T
+func (r *TypedReconciler[T]) newObject() T {
+	return reflect.New(reflect.TypeFor[T]().Elem()).Interface().(T)
+}
+
+// patchStatus patches the status subresource if the status of obj differs from original
+func (r *TypedReconciler[T]) patchStatus(ctx context.Context, original, obj client.Object) error {
+	oldStatus, err := statusOf(original)
+	if err != nil {
+		return err
+	}
+	newStatus, err := statusOf(obj)
+	if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(oldStatus, newStatus) {
+		return nil
+	}
+
+	if err := r.Client.Status().Patch(ctx, obj, client.MergeFrom(original)); client.IgnoreNotFound(err) != nil {
+		logging.FromContext(ctx).Errorw("failed to patch status", "err", err,
+			"namespacedName", client.ObjectKeyFromObject(obj),
+		)
+		return err
+	}
+	return nil
+}
+
+// statusOf returns the status field of the object, nil if the object has no status
+func statusOf(obj client.Object) (interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return content["status"], nil
+}
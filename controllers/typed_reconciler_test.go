@@ -0,0 +1,132 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newTypedReconcilerClient(statusPatches *int, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	return fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&corev1.Pod{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourcePatch: func(ctx context.Context, clt client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+				*statusPatches++
+				return clt.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+			},
+		}).Build()
+}
+
+func TestTypedReconciler(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"}}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "pod"}}
+
+	statusPatches := 0
+	clt := newTypedReconcilerClient(&statusPatches, pod)
+	calls := 0
+	r := NewTypedReconciler(clt, func(ctx context.Context, obj *corev1.Pod) (reconcile.Result, error) {
+		calls++
+		g.Expect(obj.Name).To(Equal("pod"))
+		if calls == 1 {
+			obj.Status.Phase = corev1.PodRunning
+		}
+		return reconcile.Result{}, nil
+	}).WithFinalizer("test/finalizer", nil)
+
+	_, err := r.Reconcile(ctx, request)
+	g.Expect(err).To(BeNil())
+	g.Expect(statusPatches).To(Equal(1))
+
+	current := &corev1.Pod{}
+	g.Expect(clt.Get(ctx, request.NamespacedName, current)).To(Succeed())
+	g.Expect(current.Status.Phase).To(Equal(corev1.PodRunning))
+	g.Expect(current.Finalizers).To(ConsistOf("test/finalizer"))
+
+	// status is not patched when it does not change
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).To(BeNil())
+	g.Expect(calls).To(Equal(2))
+	g.Expect(statusPatches).To(Equal(1))
+
+	// objects not found are ignored
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "missing"}})
+	g.Expect(err).To(BeNil())
+	g.Expect(calls).To(Equal(2))
+
+	// wrapper options see the object fetched for the request
+	var fetched client.Object
+	r.WithWrapperOptions(func(options *WrapperOptions) {
+		options.RequestFuncs = append(options.RequestFuncs, func(ctx context.Context, request reconcile.Request) (context.Context, error) {
+			fetched = ReconcileObjectCtx(ctx)
+			return ctx, ErrSkipReconcile
+		})
+	})
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).To(BeNil())
+	g.Expect(fetched).To(BeAssignableToTypeOf(&corev1.Pod{}))
+	g.Expect(fetched.GetName()).To(Equal("pod"))
+	g.Expect(calls).To(Equal(2))
+}
+
+func TestTypedReconcilerFinalize(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", Finalizers: []string{"test/finalizer"}}}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "pod"}}
+
+	statusPatches := 0
+	clt := newTypedReconcilerClient(&statusPatches, pod)
+	g.Expect(clt.Delete(ctx, pod.DeepCopy())).To(Succeed())
+
+	finalizeErr := errors.New("cleanup failed")
+	reconciled := false
+	r := NewTypedReconciler(clt, func(ctx context.Context, obj *corev1.Pod) (reconcile.Result, error) {
+		reconciled = true
+		return reconcile.Result{}, nil
+	}).WithFinalizer("test/finalizer", func(ctx context.Context, obj *corev1.Pod) (reconcile.Result, error) {
+		return reconcile.Result{}, finalizeErr
+	})
+
+	// the finalizer is kept when finalizing fails
+	_, err := r.Reconcile(ctx, request)
+	g.Expect(err).To(Equal(finalizeErr))
+	g.Expect(clt.Get(ctx, request.NamespacedName, &corev1.Pod{})).To(Succeed())
+
+	finalizeErr = nil
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).To(BeNil())
+	g.Expect(reconciled).To(BeFalse())
+	g.Expect(apierrors.IsNotFound(clt.Get(ctx, request.NamespacedName, &corev1.Pod{}))).To(BeTrue())
+}
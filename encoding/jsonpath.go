@@ -35,6 +35,10 @@ func NewJsonPath() JsonPath {
 type JsonPath struct {
 	// PathFormat is the format of the path
 	PathFormat func(string) string
+
+	// DecodeHook is an optional hook invoked by Decode before decoding each value,
+	// e.g. mapstructure.StringToTimeDurationHookFunc() to decode durations
+	DecodeHook mapstructure.DecodeHookFunc
 }
 
 func (p JsonPath) formatPath(s string) string {
@@ -60,6 +64,7 @@ func (p JsonPath) Decode(obj interface{}, data map[string]string) error {
 		WeaklyTypedInput: true,
 		Result:           obj,
 		TagName:          "path",
+		DecodeHook:       p.DecodeHook,
 	}
 
 	decoder, _ := mapstructure.NewDecoder(config)
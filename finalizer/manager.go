@@ -0,0 +1,298 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	pkgrecord "github.com/AlaudaDevops/pkg/record"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// DefaultTimeout is the default timeout of the cleanup handlers
+	DefaultTimeout = 30 * time.Second
+	// DefaultBaseDelay is the default delay before retrying a failed cleanup handler
+	DefaultBaseDelay = time.Second
+	// DefaultMaxDelay is the default maximum delay before retrying a failed cleanup handler
+	DefaultMaxDelay = 5 * time.Minute
+
+	// FinalizingReason is the reason of the Finalizing condition while handlers are running
+	FinalizingReason = "Finalizing"
+	// FinalizeFailedReason is the reason of the Finalizing condition and of the event of a failed handler
+	FinalizeFailedReason = "FinalizeFailed"
+	// FinalizerForceRemovedReason is the reason of the event of a finalizer removed without cleanup
+	FinalizerForceRemovedReason = "FinalizerForceRemoved"
+)
+
+// Handler cleans up the resources of a deleted object before its finalizer is removed,
+// it is invoked until it succeeds and must be idempotent
+type Handler func(ctx context.Context, obj client.Object) error
+
+type registeredHandler struct {
+	key     string
+	handler Handler
+}
+
+// Manager adds finalizers to objects and runs their cleanup handlers when objects are deleted.
+//
+// Handlers run in registration order with a timeout, failed handlers are retried with an exponential backoff.
+// The progress is reported as a Finalizing condition on objects implementing duckv1.KRShaped
+// and failures are recorded as events.
+// Operators can force the removal of the finalizers of stuck objects using the
+// metav1alpha1.ForceFinalizeAnnotationKey annotation, finalizers are then removed
+// without cleanup once ForceRemovalGracePeriod has elapsed since the deletion.
+type Manager struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+
+	// Timeout of each handler invocation, DefaultTimeout if zero
+	Timeout time.Duration
+	// BaseDelay is the delay before retrying a failed handler for the first time, DefaultBaseDelay if zero
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay before retrying a failed handler, DefaultMaxDelay if zero
+	MaxDelay time.Duration
+	// ForceRemovalGracePeriod is the duration after the deletion during which handlers still run
+	// when the removal is forced, zero removes the finalizers as soon as the annotation is set
+	ForceRemovalGracePeriod time.Duration
+
+	handlers []registeredHandler
+
+	lock sync.Mutex
+	// failures stores the number of consecutive failures of each handler by object uid and finalizer
+	failures map[string]int
+}
+
+// NewManager returns a finalizer manager,
+// the recorder in the context is used to record events if recorder is nil
+func NewManager(clt client.Client, recorder record.EventRecorder) *Manager {
+	return &Manager{
+		Client:   clt,
+		Recorder: recorder,
+		failures: map[string]int{},
+	}
+}
+
+// Register adds a finalizer and its cleanup handler, finalizers are handled in registration order
+func (m *Manager) Register(key string, handler Handler) error {
+	if key == "" || handler == nil {
+		return fmt.Errorf("finalizer key and handler must be set")
+	}
+	for _, item := range m.handlers {
+		if item.key == key {
+			return fmt.Errorf("finalizer %q is already registered", key)
+		}
+	}
+	m.handlers = append(m.handlers, registeredHandler{key: key, handler: handler})
+	return nil
+}
+
+// AddFinalizers adds the registered finalizers to an object not being deleted
+func (m *Manager) AddFinalizers(ctx context.Context, obj client.Object) error {
+	if obj.GetDeletionTimestamp() != nil {
+		return nil
+	}
+	for _, item := range m.handlers {
+		if err := AddFinalizer(ctx, m.Client, obj, item.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finalize runs the handlers of the registered finalizers of a deleted object and removes them once they succeed.
+// A failed handler is retried using the returned result, the following handlers are not run meanwhile.
+// Objects not being deleted are ignored.
+func (m *Manager) Finalize(ctx context.Context, obj client.Object) (reconcile.Result, error) {
+	if obj.GetDeletionTimestamp() == nil {
+		return reconcile.Result{}, nil
+	}
+	logger := logging.FromContext(ctx)
+
+	forced := metav1alpha1.IsForceFinalizeRequested(obj)
+	graceRemaining := m.ForceRemovalGracePeriod - time.Since(obj.GetDeletionTimestamp().Time)
+	for _, item := range m.handlers {
+		if !controllerutil.ContainsFinalizer(obj, item.key) {
+			continue
+		}
+		if forced && graceRemaining <= 0 {
+			logger.Warnw("forcing the removal of finalizer", "finalizerKey", item.key)
+			m.event(ctx, obj, corev1.EventTypeWarning, FinalizerForceRemovedReason,
+				"finalizer %s was removed without cleanup as requested by the annotation %s", item.key, metav1alpha1.ForceFinalizeAnnotationKey)
+			if err := RemoveFinalizer(ctx, m.Client, obj, item.key, nil); err != nil {
+				return reconcile.Result{}, err
+			}
+			m.forget(obj, item.key)
+			continue
+		}
+
+		// each status patch requeues the object, the condition is only updated when the
+		// finalizer changes so that retries of a failed handler wait for the backoff
+		condition := finalizingCondition(obj)
+		if !finalizingConditionIsFor(condition, item.key) {
+			if err := m.markFinalizing(ctx, obj, corev1.ConditionUnknown, FinalizingReason, runningMessage(item.key)); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		if err := m.run(ctx, obj, item); err != nil {
+			delay := m.backoff(obj, item.key)
+			if forced && graceRemaining < delay {
+				delay = graceRemaining
+			}
+			logger.Warnw("failed to finalize", "finalizerKey", item.key, "err", err, "retryAfter", delay)
+			m.event(ctx, obj, corev1.EventTypeWarning, FinalizeFailedReason, "finalizer %s failed: %s", item.key, err.Error())
+			if condition == nil || condition.Reason != FinalizeFailedReason || !finalizingConditionIsFor(condition, item.key) {
+				if err := m.markFinalizing(ctx, obj, corev1.ConditionFalse, FinalizeFailedReason, failedMessagePrefix(item.key)+err.Error()); err != nil {
+					return reconcile.Result{}, err
+				}
+			}
+			return reconcile.Result{RequeueAfter: delay}, nil
+		}
+		if err := RemoveFinalizer(ctx, m.Client, obj, item.key, nil); err != nil {
+			return reconcile.Result{}, err
+		}
+		m.forget(obj, item.key)
+	}
+	return reconcile.Result{}, nil
+}
+
+// run invokes the handler with a timeout
+func (m *Manager) run(ctx context.Context, obj client.Object, item registeredHandler) error {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := item.handler(ctx, obj)
+	if err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+	return err
+}
+
+// backoff increments the failures of the handler and returns the delay before retrying it
+func (m *Manager) backoff(obj client.Object, key string) time.Duration {
+	baseDelay, maxDelay := m.BaseDelay, m.MaxDelay
+	if baseDelay <= 0 {
+		baseDelay = DefaultBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.failures == nil {
+		m.failures = map[string]int{}
+	}
+	failureKey := string(obj.GetUID()) + "/" + key
+	exp := m.failures[failureKey]
+	m.failures[failureKey] = exp + 1
+
+	backoff := float64(baseDelay.Nanoseconds()) * math.Pow(2, float64(exp))
+	if backoff >= float64(maxDelay.Nanoseconds()) {
+		return maxDelay
+	}
+	return time.Duration(backoff)
+}
+
+// forget resets the failures of the handler
+func (m *Manager) forget(obj client.Object, key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.failures, string(obj.GetUID())+"/"+key)
+}
+
+// markFinalizing sets the Finalizing condition of objects implementing duckv1.KRShaped,
+// the status is patched only if the condition changed
+func (m *Manager) markFinalizing(ctx context.Context, obj client.Object, status corev1.ConditionStatus, reason, message string) error {
+	shaped, ok := obj.(duckv1.KRShaped)
+	if !ok {
+		return nil
+	}
+	original := obj.DeepCopyObject().(client.Object)
+	oldConditions := shaped.GetStatus().GetConditions()
+
+	// the condition is set directly to not recompute the happy condition
+	shaped.GetConditionSet().Manage(shaped.GetStatus()).SetCondition(apis.Condition{
+		Type:     apis.ConditionType(metav1alpha1.ConditionFinalizing),
+		Status:   status,
+		Severity: apis.ConditionSeverityInfo,
+		Reason:   reason,
+		Message:  message,
+	})
+	if equality.Semantic.DeepEqual(oldConditions, shaped.GetStatus().GetConditions()) {
+		return nil
+	}
+	return client.IgnoreNotFound(m.Client.Status().Patch(ctx, obj, client.MergeFrom(original)))
+}
+
+// finalizingCondition returns the Finalizing condition of objects implementing duckv1.KRShaped
+func finalizingCondition(obj client.Object) *apis.Condition {
+	shaped, ok := obj.(duckv1.KRShaped)
+	if !ok {
+		return nil
+	}
+	return shaped.GetStatus().GetCondition(apis.ConditionType(metav1alpha1.ConditionFinalizing))
+}
+
+// finalizingConditionIsFor returns true if the Finalizing condition reports the progress of the finalizer key
+func finalizingConditionIsFor(condition *apis.Condition, key string) bool {
+	if condition == nil {
+		return false
+	}
+	return condition.Message == runningMessage(key) || strings.HasPrefix(condition.Message, failedMessagePrefix(key))
+}
+
+func runningMessage(key string) string {
+	return fmt.Sprintf("running the cleanup of finalizer %s", key)
+}
+
+func failedMessagePrefix(key string) string {
+	return fmt.Sprintf("finalizer %s failed: ", key)
+}
+
+// event records an event using the recorder of the manager or of the context
+func (m *Manager) event(ctx context.Context, obj client.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	recorder := m.Recorder
+	if recorder == nil {
+		recorder = pkgrecord.FromContext(ctx)
+	}
+	if recorder != nil {
+		recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package finalizer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		ctx      context.Context
+		clt      client.Client
+		recorder *record.FakeRecorder
+		manager  *Manager
+		obj      *duckv1.KResource
+		calls    []string
+		failing  error
+	)
+
+	finalizingCondition := apis.ConditionType(metav1alpha1.ConditionFinalizing)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		calls = nil
+		failing = nil
+
+		managerScheme := runtime.NewScheme()
+		managerScheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "test.dev", Version: "v1", Kind: "KResource"}, &duckv1.KResource{})
+		obj = &duckv1.KResource{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "obj", UID: "uid"}}
+		clt = fake.NewClientBuilder().WithScheme(managerScheme).WithObjects(obj).WithStatusSubresource(obj).Build()
+		recorder = record.NewFakeRecorder(10)
+
+		manager = NewManager(clt, recorder)
+		manager.BaseDelay = time.Second
+		Expect(manager.Register("test.dev/first", func(ctx context.Context, _ client.Object) error {
+			calls = append(calls, "first")
+			return failing
+		})).To(Succeed())
+		Expect(manager.Register("test.dev/second", func(ctx context.Context, _ client.Object) error {
+			calls = append(calls, "second")
+			return nil
+		})).To(Succeed())
+		Expect(manager.Register("test.dev/second", func(context.Context, client.Object) error { return nil })).NotTo(Succeed())
+
+		Expect(manager.AddFinalizers(ctx, obj)).To(Succeed())
+		Expect(obj.Finalizers).To(Equal([]string{"test.dev/first", "test.dev/second"}))
+		Expect(clt.Delete(ctx, obj)).To(Succeed())
+		Expect(clt.Get(ctx, client.ObjectKeyFromObject(obj), obj)).To(Succeed())
+	})
+
+	It("runs the handlers and removes the finalizers", func() {
+		result, err := manager.Finalize(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+		Expect(calls).To(Equal([]string{"first", "second"}))
+		Expect(apierrors.IsNotFound(clt.Get(ctx, client.ObjectKeyFromObject(obj), obj))).To(BeTrue())
+	})
+
+	It("retries failed handlers with a backoff", func() {
+		failing = errors.New("remote unavailable")
+		result, err := manager.Finalize(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Second))
+		Expect(calls).To(Equal([]string{"first"}))
+		Expect(recorder.Events).To(Receive(ContainSubstring(FinalizeFailedReason)))
+
+		current := &duckv1.KResource{}
+		Expect(clt.Get(ctx, client.ObjectKeyFromObject(obj), current)).To(Succeed())
+		Expect(current.Finalizers).To(HaveLen(2))
+		condition := current.Status.GetCondition(finalizingCondition)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal(FinalizeFailedReason))
+		Expect(condition.Message).To(ContainSubstring("remote unavailable"))
+
+		// retries do not patch the status again, which would requeue the object before the backoff
+		failing = errors.New("still unavailable")
+		result, err = manager.Finalize(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(2 * time.Second))
+		retried := &duckv1.KResource{}
+		Expect(clt.Get(ctx, client.ObjectKeyFromObject(obj), retried)).To(Succeed())
+		Expect(retried.ResourceVersion).To(Equal(current.ResourceVersion))
+	})
+
+	It("times out handlers", func() {
+		manager.Timeout = 10 * time.Millisecond
+		manager.handlers[0].handler = func(ctx context.Context, _ client.Object) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		result, err := manager.Finalize(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Second))
+		Expect(recorder.Events).To(Receive(ContainSubstring("timed out")))
+	})
+
+	It("forces the removal of the finalizers after the grace period", func() {
+		failing = errors.New("remote unavailable")
+		patch := client.MergeFrom(obj.DeepCopy())
+		obj.Annotations = map[string]string{metav1alpha1.ForceFinalizeAnnotationKey: "true"}
+		Expect(clt.Patch(ctx, obj, patch)).To(Succeed())
+
+		// handlers still run during the grace period
+		manager.ForceRemovalGracePeriod = time.Hour
+		result, err := manager.Finalize(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Second))
+		Expect(calls).To(Equal([]string{"first"}))
+
+		manager.ForceRemovalGracePeriod = 0
+		result, err = manager.Finalize(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+		Expect(calls).To(Equal([]string{"first"}))
+		Expect(recorder.Events).To(Receive(ContainSubstring(FinalizeFailedReason)))
+		Expect(recorder.Events).To(Receive(ContainSubstring(FinalizerForceRemovedReason)))
+		Expect(apierrors.IsNotFound(clt.Get(ctx, client.ObjectKeyFromObject(obj), obj))).To(BeTrue())
+	})
+
+	It("ignores objects not being deleted", func() {
+		cm := &corev1.ConfigMap{}
+		result, err := manager.Finalize(ctx, cm)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+		Expect(calls).To(BeEmpty())
+	})
+})
@@ -0,0 +1,259 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package references
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	"github.com/AlaudaDevops/pkg/fieldindexer"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// LogicalOwnerIndexField is the field indexing objects by their logical owners, see LogicalOwnerFieldIndexer
+const LogicalOwnerIndexField = ".metadata.logicalOwners"
+
+// LogicalOwnerReference references the owner of an object stored in the
+// LogicalOwnersAnnotationKey annotation. Unlike owner references the owner
+// can be in another namespace or in another cluster, dependents are not
+// garbage collected and must be deleted using CascadeDelete.
+type LogicalOwnerReference struct {
+	// Cluster of the owner, empty for the cluster of the dependent
+	Cluster string `json:"cluster,omitempty"`
+	// APIVersion of the owner
+	APIVersion string `json:"apiVersion"`
+	// Kind of the owner
+	Kind string `json:"kind"`
+	// Namespace of the owner, empty for cluster scoped owners
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the owner
+	Name string `json:"name"`
+	// UID of the owner, optional
+	UID types.UID `json:"uid,omitempty"`
+}
+
+// NewLogicalOwnerReference returns a reference to owner using scheme to find its kind
+func NewLogicalOwnerReference(owner client.Object, scheme *runtime.Scheme) (LogicalOwnerReference, error) {
+	gvk, err := apiutil.GVKForObject(owner, scheme)
+	if err != nil {
+		return LogicalOwnerReference{}, err
+	}
+	return LogicalOwnerReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Namespace:  owner.GetNamespace(),
+		Name:       owner.GetName(),
+		UID:        owner.GetUID(),
+	}, nil
+}
+
+// GroupKind returns the group kind of the owner
+func (r LogicalOwnerReference) GroupKind() schema.GroupKind {
+	return schema.FromAPIVersionAndKind(r.APIVersion, r.Kind).GroupKind()
+}
+
+// IndexKey returns the value indexed in LogicalOwnerIndexField for the owner,
+// the version and the uid of the owner are ignored
+func (r LogicalOwnerReference) IndexKey() string {
+	gk := r.GroupKind()
+	return strings.Join([]string{r.Cluster, gk.Group, gk.Kind, r.Namespace, r.Name}, "/")
+}
+
+// refersTo returns true if both references point to the same owner, the version is ignored
+// and the uids are only compared if both are set
+func (r LogicalOwnerReference) refersTo(other LogicalOwnerReference) bool {
+	if r.IndexKey() != other.IndexKey() {
+		return false
+	}
+	return r.UID == "" || other.UID == "" || r.UID == other.UID
+}
+
+// GetLogicalOwners returns the logical owners of the object
+func GetLogicalOwners(obj metav1.Object) ([]LogicalOwnerReference, error) {
+	value := obj.GetAnnotations()[metav1alpha1.LogicalOwnersAnnotationKey]
+	if value == "" {
+		return nil, nil
+	}
+	owners := []LogicalOwnerReference{}
+	if err := json.Unmarshal([]byte(value), &owners); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %w", metav1alpha1.LogicalOwnersAnnotationKey, err)
+	}
+	return owners, nil
+}
+
+// HasLogicalOwner returns true if owner is a logical owner of the object
+func HasLogicalOwner(obj metav1.Object, owner LogicalOwnerReference) bool {
+	owners, _ := GetLogicalOwners(obj)
+	for _, item := range owners {
+		if item.refersTo(owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLogicalOwner adds owner to the logical owners of the object, replacing any reference to the same owner.
+// Returns true if the annotation was changed.
+func SetLogicalOwner(obj metav1.Object, owner LogicalOwnerReference) (bool, error) {
+	owners, err := GetLogicalOwners(obj)
+	if err != nil {
+		return false, err
+	}
+	for i, item := range owners {
+		if item.IndexKey() == owner.IndexKey() {
+			if item == owner {
+				return false, nil
+			}
+			owners[i] = owner
+			return true, setLogicalOwners(obj, owners)
+		}
+	}
+	return true, setLogicalOwners(obj, append(owners, owner))
+}
+
+// RemoveLogicalOwner removes owner from the logical owners of the object.
+// Returns true if the annotation was changed.
+func RemoveLogicalOwner(obj metav1.Object, owner LogicalOwnerReference) (bool, error) {
+	owners, err := GetLogicalOwners(obj)
+	if err != nil {
+		return false, err
+	}
+	kept := make([]LogicalOwnerReference, 0, len(owners))
+	for _, item := range owners {
+		if !item.refersTo(owner) {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) == len(owners) {
+		return false, nil
+	}
+	return true, setLogicalOwners(obj, kept)
+}
+
+func setLogicalOwners(obj metav1.Object, owners []LogicalOwnerReference) error {
+	annotations := obj.GetAnnotations()
+	if len(owners) == 0 {
+		delete(annotations, metav1alpha1.LogicalOwnersAnnotationKey)
+		obj.SetAnnotations(annotations)
+		return nil
+	}
+	value, err := json.Marshal(owners)
+	if err != nil {
+		return err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[metav1alpha1.LogicalOwnersAnnotationKey] = string(value)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// LogicalOwnerFieldIndexer returns a field indexer indexing objects of the type of obj by their logical owners,
+// to be registered in the cache of the manager, e.g. using AppBuilder.WithFieldIndexer
+func LogicalOwnerFieldIndexer(obj client.Object) fieldindexer.FieldIndexer {
+	return fieldindexer.FieldIndexer{
+		Obj:   obj,
+		Field: LogicalOwnerIndexField,
+		ExtractValue: func(obj client.Object) []string {
+			owners, _ := GetLogicalOwners(obj)
+			keys := make([]string, 0, len(owners))
+			for _, owner := range owners {
+				keys = append(keys, owner.IndexKey())
+			}
+			return keys
+		},
+	}
+}
+
+// EnqueueRequestForLogicalOwner returns an event handler enqueuing the logical owners
+// of the dependents with the group kind and in the cluster, use an empty cluster for the local cluster
+func EnqueueRequestForLogicalOwner(ownerGroupKind schema.GroupKind, cluster string) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+		owners, _ := GetLogicalOwners(obj)
+		requests := []reconcile.Request{}
+		for _, owner := range owners {
+			if owner.Cluster != cluster || owner.GroupKind() != ownerGroupKind {
+				continue
+			}
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: owner.Namespace,
+				Name:      owner.Name,
+			}})
+		}
+		return requests
+	})
+}
+
+// ListLogicalDependents lists the dependents of owner of the type of list,
+// the LogicalOwnerFieldIndexer of the type must be registered in the cache used by reader
+func ListLogicalDependents(ctx context.Context, reader client.Reader, list client.ObjectList, owner LogicalOwnerReference, opts ...client.ListOption) error {
+	opts = append(opts, client.MatchingFields{LogicalOwnerIndexField: owner.IndexKey()})
+	if err := reader.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	// filters out dependents of another owner with the same name
+	items := []runtime.Object{}
+	err := meta.EachListItem(list, func(item runtime.Object) error {
+		if obj, ok := item.(client.Object); ok && HasLogicalOwner(obj, owner) {
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return meta.SetList(list, items)
+}
+
+// CascadeDelete deletes the dependents of owner of the types of lists, usually invoked from the finalizer of owner.
+// Returns the number of dependents not deleted yet, the finalizer should be kept until it is zero.
+func CascadeDelete(ctx context.Context, clt client.Client, owner LogicalOwnerReference, lists ...client.ObjectList) (remaining int, err error) {
+	var errs []error
+	for _, list := range lists {
+		list = list.DeepCopyObject().(client.ObjectList)
+		if err := ListLogicalDependents(ctx, clt, list, owner); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		_ = meta.EachListItem(list, func(item runtime.Object) error {
+			obj := item.(client.Object)
+			remaining++
+			if obj.GetDeletionTimestamp() != nil {
+				return nil
+			}
+			if err := clt.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+			return nil
+		})
+	}
+	return remaining, utilerrors.NewAggregate(errs)
+}
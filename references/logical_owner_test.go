@@ -0,0 +1,120 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package references
+
+import (
+	"context"
+	"testing"
+
+	metav1alpha1 "github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestLogicalOwners(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	owner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "owner", UID: "uid"}}
+	ref, err := NewLogicalOwnerReference(owner, clientgoscheme.Scheme)
+	g.Expect(err).To(BeNil())
+	g.Expect(ref).To(Equal(LogicalOwnerReference{APIVersion: "v1", Kind: "Secret", Namespace: "a", Name: "owner", UID: "uid"}))
+
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "dependent"}}
+	g.Expect(SetLogicalOwner(obj, ref)).To(BeTrue())
+	g.Expect(SetLogicalOwner(obj, ref)).To(BeFalse())
+	g.Expect(HasLogicalOwner(obj, ref)).To(BeTrue())
+
+	remote := ref
+	remote.Cluster = "business"
+	g.Expect(HasLogicalOwner(obj, remote)).To(BeFalse())
+	g.Expect(SetLogicalOwner(obj, remote)).To(BeTrue())
+	g.Expect(GetLogicalOwners(obj)).To(HaveLen(2))
+
+	// a recreated owner is not the same owner
+	recreated := ref
+	recreated.UID = "other"
+	g.Expect(HasLogicalOwner(obj, recreated)).To(BeFalse())
+
+	g.Expect(RemoveLogicalOwner(obj, ref)).To(BeTrue())
+	g.Expect(RemoveLogicalOwner(obj, remote)).To(BeTrue())
+	g.Expect(obj.Annotations).NotTo(HaveKey(metav1alpha1.LogicalOwnersAnnotationKey))
+
+	obj.Annotations = map[string]string{metav1alpha1.LogicalOwnersAnnotationKey: "invalid"}
+	_, err = GetLogicalOwners(obj)
+	g.Expect(err).NotTo(BeNil())
+}
+
+func TestEnqueueRequestForLogicalOwner(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	obj := &corev1.ConfigMap{}
+	_, _ = SetLogicalOwner(obj, LogicalOwnerReference{APIVersion: "v1", Kind: "Secret", Namespace: "a", Name: "local"})
+	_, _ = SetLogicalOwner(obj, LogicalOwnerReference{Cluster: "business", APIVersion: "v1", Kind: "Secret", Namespace: "a", Name: "remote"})
+	_, _ = SetLogicalOwner(obj, LogicalOwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "a", Name: "other"})
+
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]())
+	defer queue.ShutDown()
+	EnqueueRequestForLogicalOwner(schema.GroupKind{Kind: "Secret"}, "").Generic(context.TODO(), event.GenericEvent{Object: obj}, queue)
+	g.Expect(queue.Len()).To(Equal(1))
+	item, _ := queue.Get()
+	g.Expect(item.Name).To(Equal("local"))
+}
+
+func TestCascadeDelete(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+
+	ref := LogicalOwnerReference{APIVersion: "v1", Kind: "Secret", Namespace: "a", Name: "owner", UID: "uid"}
+	objects := []runtime.Object{}
+	for _, item := range []struct {
+		name  string
+		owner LogicalOwnerReference
+	}{
+		{name: "dependent", owner: ref},
+		{name: "recreated", owner: LogicalOwnerReference{APIVersion: "v1", Kind: "Secret", Namespace: "a", Name: "owner", UID: "other"}},
+		{name: "unrelated", owner: LogicalOwnerReference{APIVersion: "v1", Kind: "Secret", Namespace: "a", Name: "another"}},
+	} {
+		obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: item.name}}
+		_, _ = SetLogicalOwner(obj, item.owner)
+		objects = append(objects, obj)
+	}
+
+	indexer := LogicalOwnerFieldIndexer(&corev1.ConfigMap{})
+	clt := fake.NewClientBuilder().WithRuntimeObjects(objects...).
+		WithIndex(indexer.Obj, indexer.Field, indexer.ExtractValue).Build()
+
+	remaining, err := CascadeDelete(ctx, clt, ref, &corev1.ConfigMapList{})
+	g.Expect(err).To(BeNil())
+	g.Expect(remaining).To(Equal(1))
+
+	remaining, err = CascadeDelete(ctx, clt, ref, &corev1.ConfigMapList{})
+	g.Expect(err).To(BeNil())
+	g.Expect(remaining).To(Equal(0))
+
+	list := &corev1.ConfigMapList{}
+	g.Expect(clt.List(ctx, list, client.InNamespace("b"))).To(Succeed())
+	g.Expect(list.Items).To(HaveLen(2))
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package route
+
+import (
+	"context"
+	"net/http"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+
+	"github.com/AlaudaDevops/pkg/config"
+	"github.com/AlaudaDevops/pkg/controllers"
+)
+
+type controllersStatus struct {
+	Context       context.Context
+	ConfigManager *config.Manager
+	LazyLoader    controllers.LazyLoader
+}
+
+// NewControllers service listing the loading status of controllers
+// registered in the lazy loader of the context.
+// The route is only registered when the context has a config manager
+// and answers not found unless config.DebugEnabledKey is true.
+func NewControllers(ctx context.Context) Route {
+	return &controllersStatus{
+		Context:       ctx,
+		ConfigManager: config.ConfigManager(ctx),
+		LazyLoader:    controllers.LazyLoaderCtx(ctx),
+	}
+}
+
+func (s *controllersStatus) Register(ws *restful.WebService) {
+	filter, ok := debugFilter(s.Context, s.ConfigManager)
+	if !ok {
+		return
+	}
+	tags := []string{"debug"}
+
+	ws.Route(
+		ws.GET("/debug/controllers").
+			Doc("lists pending and loaded controllers").
+			Metadata(restfulspec.KeyOpenAPITags, tags).
+			Filter(filter).
+			Returns(http.StatusOK, "OK", controllers.LazyLoaderStatus{}).
+			To(s.status))
+}
+
+func (s *controllersStatus) status(req *restful.Request, resp *restful.Response) {
+	status := controllers.LazyLoaderStatus{
+		Pending: []controllers.ControllerStatus{},
+		Done:    []controllers.ControllerStatus{},
+	}
+	if reporter, ok := s.LazyLoader.(controllers.LazyLoaderStatusReporter); ok {
+		status = reporter.Status()
+	}
+	resp.WriteHeaderAndJson(http.StatusOK, status, restful.MIME_JSON)
+}
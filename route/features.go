@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package route
+
+import (
+	"context"
+	"net/http"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+
+	"github.com/AlaudaDevops/pkg/config"
+)
+
+type features struct {
+	Context       context.Context
+	ConfigManager *config.Manager
+}
+
+// NewFeatures service listing the effective values of feature flags
+// of the config manager in the context.
+// The route is only registered when the context has a config manager
+// and answers not found unless config.DebugEnabledKey is true.
+func NewFeatures(ctx context.Context) Route {
+	return &features{
+		Context:       ctx,
+		ConfigManager: config.ConfigManager(ctx),
+	}
+}
+
+func (s *features) Register(ws *restful.WebService) {
+	filter, ok := debugFilter(s.Context, s.ConfigManager)
+	if !ok {
+		return
+	}
+	tags := []string{"debug"}
+
+	ws.Route(
+		ws.GET("/debug/features").
+			Doc("lists feature flags with their effective value and source").
+			Param(ws.QueryParameter("namespace", "includes the overrides of the namespace")).
+			Metadata(restfulspec.KeyOpenAPITags, tags).
+			Filter(filter).
+			Returns(http.StatusOK, "OK", []config.EffectiveFlag{}).
+			To(s.list))
+}
+
+func (s *features) list(req *restful.Request, resp *restful.Response) {
+	flags := s.ConfigManager.EffectiveFlagsForNamespace(req.QueryParameter("namespace"))
+	resp.WriteHeaderAndJson(http.StatusOK, flags, restful.MIME_JSON)
+}
@@ -17,9 +17,12 @@ limitations under the License.
 package route
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/emicklei/go-restful/v3"
+
+	"github.com/AlaudaDevops/pkg/config"
 )
 
 // wrapperF go restful wrapper func for http.HandlerFunc
@@ -40,3 +43,12 @@ func wrapperH(handler http.Handler) restful.RouteFunction {
 func NoOpFilter(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
 	chain.ProcessFilter(req, res)
 }
+
+// debugFilter returns a filter answering not found unless config.DebugEnabledKey is true,
+// returns false if there is no config manager to enable the debugging apis
+func debugFilter(ctx context.Context, manager *config.Manager) (restful.FilterFunction, bool) {
+	if ctx == nil || manager == nil {
+		return nil, false
+	}
+	return config.ConfigFilter(ctx, manager, config.DebugEnabledKey, config.ConfigFilterNotFoundWhenNotTrue), true
+}
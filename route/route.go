@@ -33,11 +33,13 @@ type ContextRoute interface {
 	Register(ctx context.Context, ws *restful.WebService) error
 }
 
-// NewDefaultService default service included with metrics,pprof
+// NewDefaultService default service included with metrics,pprof, controllers status and feature flags
 func NewDefaultService(ctx context.Context) *restful.WebService {
 	routes := []Route{
 		NewSystem(ctx),
 		NewHealthz(ctx),
+		NewControllers(ctx),
+		NewFeatures(ctx),
 	}
 
 	ws := &restful.WebService{}
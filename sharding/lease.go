@@ -0,0 +1,273 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Start claims and renews shards until ctx is done, the shards held are released afterwards
+func (c *Coordinator) Start(ctx context.Context) error {
+	c.logger.Infow("starting shards coordinator", "name", c.Name, "identity", c.Identity, "shards", c.Shards)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := c.sync(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Warnw("failed to sync shards", "err", err)
+		}
+	}, c.RetryPeriod)
+
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.RenewDeadline)
+	defer cancel()
+	return c.release(releaseCtx)
+}
+
+// sync renews the member lease and claims or releases shards
+// so that shards are shared evenly by all replicas alive
+func (c *Coordinator) sync(ctx context.Context) error {
+	now := c.now()
+	if err := c.renewMember(ctx, now); err != nil {
+		return err
+	}
+	members, err := c.members(ctx, now)
+	if err != nil {
+		return err
+	}
+	target := c.targetShards(members)
+
+	var errs []error
+	acquired := []int{}
+	for shard := 0; shard < c.Shards; shard++ {
+		held, err := c.syncShard(ctx, shard, target[shard], now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if held {
+			acquired = append(acquired, shard)
+		}
+	}
+
+	if len(acquired) > 0 {
+		c.logger.Infow("acquired shards", "shards", acquired, "members", members)
+		c.lock.RLock()
+		listeners := append([]func(context.Context, []int){}, c.listeners...)
+		c.lock.RUnlock()
+		for _, listener := range listeners {
+			listener(ctx, acquired)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// syncShard claims, renews or releases the lease of a shard,
+// returns true if the shard was not held before
+func (c *Coordinator) syncShard(ctx context.Context, shard int, wanted bool, now time.Time) (acquired bool, err error) {
+	leases := c.client.CoordinationV1().Leases(c.Namespace)
+	lease, err := leases.Get(ctx, c.shardLeaseName(shard), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if !wanted {
+			c.drop(shard)
+			return false, nil
+		}
+		lease = c.newLease(c.shardLeaseName(shard), now)
+		if _, err = leases.Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+			c.drop(shard)
+			return false, err
+		}
+		return c.hold(shard, now), nil
+	}
+	if err != nil {
+		// the shard is dropped once the renew deadline is exceeded
+		return false, err
+	}
+
+	holder := holderOf(lease)
+	if !wanted {
+		// stops reconciling objects of the shard before releasing it
+		c.drop(shard)
+		if holder != c.Identity {
+			return false, nil
+		}
+		lease.Spec.HolderIdentity = nil
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		return false, err
+	}
+	if holder != c.Identity && holder != "" && !c.expired(lease, now) {
+		// waits until the shard is released by the other replica
+		c.drop(shard)
+		return false, nil
+	}
+
+	c.renew(lease, now)
+	if _, err = leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		c.drop(shard)
+		return false, err
+	}
+	return c.hold(shard, now), nil
+}
+
+// renewMember creates or renews the member lease of the replica
+func (c *Coordinator) renewMember(ctx context.Context, now time.Time) error {
+	leases := c.client.CoordinationV1().Leases(c.Namespace)
+	lease, err := leases.Get(ctx, c.memberLeaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, c.newLease(c.memberLeaseName(), now), metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	c.renew(lease, now)
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// members returns the sorted identities of the replicas alive
+func (c *Coordinator) members(ctx context.Context, now time.Time) ([]string, error) {
+	selector := labels.SelectorFromSet(labels.Set{GroupLabelKey: c.Name})
+	list, err := c.client.CoordinationV1().Leases(c.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	members := []string{c.Identity}
+	for i := range list.Items {
+		lease := &list.Items[i]
+		if holder := holderOf(lease); holder != "" && holder != c.Identity && !c.expired(lease, now) {
+			members = append(members, holder)
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// targetShards returns the shards the replica should hold, shards are assigned in turn to the members
+func (c *Coordinator) targetShards(members []string) map[int]bool {
+	index := sort.SearchStrings(members, c.Identity)
+	target := map[int]bool{}
+	for shard := index; shard < c.Shards; shard += len(members) {
+		target[shard] = true
+	}
+	return target
+}
+
+// release releases the shards held and deletes the member lease so that other replicas take over quickly
+func (c *Coordinator) release(ctx context.Context) error {
+	c.lock.Lock()
+	shards := make([]int, 0, len(c.owned))
+	for shard := range c.owned {
+		shards = append(shards, shard)
+	}
+	c.owned = map[int]time.Time{}
+	c.lock.Unlock()
+
+	leases := c.client.CoordinationV1().Leases(c.Namespace)
+	var errs []error
+	for _, shard := range shards {
+		lease, err := leases.Get(ctx, c.shardLeaseName(shard), metav1.GetOptions{})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if holderOf(lease) != c.Identity {
+			continue
+		}
+		lease.Spec.HolderIdentity = nil
+		if _, err = leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := leases.Delete(ctx, c.memberLeaseName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		errs = append(errs, err)
+	}
+	c.logger.Infow("released shards", "shards", shards)
+	return utilerrors.NewAggregate(errs)
+}
+
+// hold marks the shard as held, returns true if it was not held before
+func (c *Coordinator) hold(shard int, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, held := c.owned[shard]
+	c.owned[shard] = now
+	return !held
+}
+
+// drop marks the shard as not held
+func (c *Coordinator) drop(shard int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.owned, shard)
+}
+
+func (c *Coordinator) shardLeaseName(shard int) string {
+	return fmt.Sprintf("%s-shard-%d", c.Name, shard)
+}
+
+func (c *Coordinator) memberLeaseName() string {
+	return fmt.Sprintf("%s-member-%s", c.Name, c.Identity)
+}
+
+// newLease returns a lease held by the replica
+func (c *Coordinator) newLease(name string, now time.Time) *coordinationv1.Lease {
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: c.Namespace, Name: name}}
+	if name == c.memberLeaseName() {
+		lease.Labels = map[string]string{GroupLabelKey: c.Name}
+	}
+	c.renew(lease, now)
+	return lease
+}
+
+// renew sets the replica as the holder of the lease and updates its renew time
+func (c *Coordinator) renew(lease *coordinationv1.Lease, now time.Time) {
+	microNow := metav1.NewMicroTime(now)
+	if holderOf(lease) != c.Identity {
+		identity := c.Identity
+		lease.Spec.HolderIdentity = &identity
+		lease.Spec.AcquireTime = &microNow
+		if lease.Spec.LeaseTransitions == nil {
+			lease.Spec.LeaseTransitions = new(int32)
+		}
+		*lease.Spec.LeaseTransitions++
+	}
+	seconds := int32(c.LeaseDuration.Seconds())
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &microNow
+}
+
+// expired returns true if the lease was not renewed within its duration
+func (c *Coordinator) expired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return lease.Spec.RenewTime.Add(duration).Before(now)
+}
+
+func holderOf(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sharding distributes the reconciliation of objects across the replicas of an app.
+//
+// Objects are assigned to a fixed number of shards by the hash of their namespace and name.
+// Each replica claims shards using Leases and only reconciles the objects of the shards it holds,
+// shards are rebalanced when replicas come and go.
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// GroupLabelKey is the label of the leases of the replicas sharing the shards
+	GroupLabelKey = "sharding.alauda.io/group"
+
+	// DefaultLeaseDuration is the default duration of the leases
+	DefaultLeaseDuration = 15 * time.Second
+	// DefaultRenewDeadline is the default duration a shard is considered held without renewing its lease
+	DefaultRenewDeadline = 10 * time.Second
+	// DefaultRetryPeriod is the default interval between renewals of the leases
+	DefaultRetryPeriod = 2 * time.Second
+)
+
+// ShardFor returns the shard of an object using the hash of its namespace and name
+func ShardFor(namespace, name string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	hasher := fnv.New32a()
+	// Hash.Write never returns an error
+	_, _ = hasher.Write([]byte(namespace + "/" + name))
+	return int(hasher.Sum32() % uint32(shards))
+}
+
+// Options configures a Coordinator
+type Options struct {
+	// Name of the group of replicas sharing the shards, used as prefix of the lease names
+	Name string
+	// Namespace of the leases
+	Namespace string
+	// Identity of the replica, usually the pod name
+	Identity string
+	// Shards is the number of shards, it must be the same for all replicas
+	Shards int
+
+	// LeaseDuration is the duration after which leases not renewed can be claimed by other replicas
+	LeaseDuration time.Duration
+	// RenewDeadline is the duration a shard is considered held without renewing its lease,
+	// it must be less than LeaseDuration so that a shard is released before it can be claimed by other replicas
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval between renewals of the leases
+	RetryPeriod time.Duration
+}
+
+// Coordinator claims shards for a replica using Leases.
+// Each replica renews a member lease and all replicas alive share the shards evenly,
+// a shard is claimed once its lease is released or expired.
+type Coordinator struct {
+	Options
+
+	client kubernetes.Interface
+	logger *zap.SugaredLogger
+	now    func() time.Time
+
+	lock sync.RWMutex
+	// owned stores the last renewal time of the shards held
+	owned     map[int]time.Time
+	listeners []func(ctx context.Context, shards []int)
+}
+
+// NewCoordinator returns a coordinator for the replica,
+// options not set use the default values
+func NewCoordinator(clt kubernetes.Interface, logger *zap.SugaredLogger, opts Options) (*Coordinator, error) {
+	if opts.LeaseDuration <= 0 {
+		opts.LeaseDuration = DefaultLeaseDuration
+	}
+	if opts.RenewDeadline <= 0 {
+		opts.RenewDeadline = DefaultRenewDeadline
+	}
+	if opts.RetryPeriod <= 0 {
+		opts.RetryPeriod = DefaultRetryPeriod
+	}
+
+	switch {
+	case opts.Shards <= 0:
+		return nil, fmt.Errorf("shards must be positive, got %d", opts.Shards)
+	case opts.Identity == "":
+		return nil, fmt.Errorf("identity must not be empty")
+	case opts.Namespace == "":
+		return nil, fmt.Errorf("namespace must not be empty")
+	case opts.RenewDeadline >= opts.LeaseDuration:
+		return nil, fmt.Errorf("renew deadline %s must be less than lease duration %s", opts.RenewDeadline, opts.LeaseDuration)
+	}
+	if errs := validation.IsValidLabelValue(opts.Name); opts.Name == "" || len(errs) > 0 {
+		return nil, fmt.Errorf("invalid name %q: %v", opts.Name, errs)
+	}
+
+	return &Coordinator{
+		Options: opts,
+		client:  clt,
+		logger:  logger,
+		now:     time.Now,
+		owned:   map[int]time.Time{},
+	}, nil
+}
+
+// HeldShards returns the shards currently held, sorted
+func (c *Coordinator) HeldShards() []int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	shards := make([]int, 0, len(c.owned))
+	for shard := range c.owned {
+		if c.fresh(shard) {
+			shards = append(shards, shard)
+		}
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+// OwnsShard returns true if the shard is held and its lease was renewed within the renew deadline
+func (c *Coordinator) OwnsShard(shard int) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.fresh(shard)
+}
+
+// Owns returns true if the object with the namespace and name belongs to a shard held by the replica,
+// always returns true for a nil coordinator
+func (c *Coordinator) Owns(namespace, name string) bool {
+	if c == nil {
+		return true
+	}
+	return c.OwnsShard(ShardFor(namespace, name, c.Shards))
+}
+
+// OwnsObject returns true if the object belongs to a shard held by the replica, see Owns
+func (c *Coordinator) OwnsObject(obj client.Object) bool {
+	return c.Owns(obj.GetNamespace(), obj.GetName())
+}
+
+// Predicate returns a predicate accepting only the events of the objects owned by the replica
+func (c *Coordinator) Predicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(c.OwnsObject)
+}
+
+// OnAcquire adds a listener invoked with the shards acquired by the replica,
+// listeners are invoked sequentially and should not block
+func (c *Coordinator) OnAcquire(listener func(ctx context.Context, shards []int)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+// Source returns a source enqueuing the objects of the shards acquired by the replica,
+// so that objects are reconciled after a rebalancing.
+// Objects are listed from reader, usually the cache of the manager, using the type of list.
+// The source never enqueues objects for a nil coordinator.
+func (c *Coordinator) Source(reader client.Reader, list client.ObjectList) source.Source {
+	events := make(chan event.GenericEvent)
+	if c != nil {
+		c.OnAcquire(func(ctx context.Context, shards []int) {
+			go c.enqueue(ctx, reader, list.DeepCopyObject().(client.ObjectList), shards, events)
+		})
+	}
+	return source.Channel(events, &handler.EnqueueRequestForObject{})
+}
+
+// enqueue sends an event for each object of the shards
+func (c *Coordinator) enqueue(ctx context.Context, reader client.Reader, list client.ObjectList, shards []int, events chan<- event.GenericEvent) {
+	if err := reader.List(ctx, list); err != nil {
+		c.logger.Warnw("failed to list objects of acquired shards", "shards", shards, "err", err)
+		return
+	}
+	acquired := map[int]bool{}
+	for _, shard := range shards {
+		acquired[shard] = true
+	}
+	_ = meta.EachListItem(list, func(item runtime.Object) error {
+		obj, ok := item.(client.Object)
+		if !ok || !acquired[ShardFor(obj.GetNamespace(), obj.GetName(), c.Shards)] {
+			return nil
+		}
+		select {
+		case events <- event.GenericEvent{Object: obj}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// fresh returns true if the shard lease was renewed within the renew deadline, the lock must be held
+func (c *Coordinator) fresh(shard int) bool {
+	renewed, ok := c.owned[shard]
+	return ok && c.now().Sub(renewed) < c.RenewDeadline
+}
+
+type coordinatorKey struct{}
+
+// WithCoordinator stores a Coordinator into context
+func WithCoordinator(ctx context.Context, c *Coordinator) context.Context {
+	return context.WithValue(ctx, coordinatorKey{}, c)
+}
+
+// CoordinatorFrom retrieves a Coordinator from context. Returns nil if none
+func CoordinatorFrom(ctx context.Context) *Coordinator {
+	c, _ := ctx.Value(coordinatorKey{}).(*Coordinator)
+	return c
+}
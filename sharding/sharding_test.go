@@ -0,0 +1,134 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newTestCoordinator(g *WithT, clt kubernetes.Interface, identity string, now *time.Time) *Coordinator {
+	c, err := NewCoordinator(clt, zap.NewNop().Sugar(), Options{
+		Name:      "app",
+		Namespace: "system",
+		Identity:  identity,
+		Shards:    4,
+	})
+	g.Expect(err).To(BeNil())
+	c.now = func() time.Time { return *now }
+	return c
+}
+
+func TestShardFor(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(ShardFor("default", "a", 1)).To(Equal(0))
+	g.Expect(ShardFor("default", "a", 8)).To(Equal(ShardFor("default", "a", 8)))
+	for _, name := range []string{"a", "b", "c", "d"} {
+		g.Expect(ShardFor("default", name, 8)).To(BeNumerically("<", 8))
+	}
+}
+
+func TestNewCoordinatorValidation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	clt := fake.NewSimpleClientset()
+
+	_, err := NewCoordinator(clt, zap.NewNop().Sugar(), Options{Name: "app", Namespace: "system", Identity: "a"})
+	g.Expect(err).NotTo(BeNil())
+	_, err = NewCoordinator(clt, zap.NewNop().Sugar(), Options{Name: "app", Namespace: "system", Shards: 1})
+	g.Expect(err).NotTo(BeNil())
+	_, err = NewCoordinator(clt, zap.NewNop().Sugar(), Options{Name: "app", Namespace: "system", Identity: "a", Shards: 1,
+		LeaseDuration: time.Second, RenewDeadline: time.Second})
+	g.Expect(err).NotTo(BeNil())
+}
+
+func TestCoordinatorRebalance(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	clt := fake.NewSimpleClientset()
+	now := time.Now()
+
+	a := newTestCoordinator(g, clt, "a", &now)
+	acquired := [][]int{}
+	a.OnAcquire(func(_ context.Context, shards []int) { acquired = append(acquired, shards) })
+	g.Expect(a.sync(ctx)).To(Succeed())
+	g.Expect(a.HeldShards()).To(Equal([]int{0, 1, 2, 3}))
+	g.Expect(acquired).To(Equal([][]int{{0, 1, 2, 3}}))
+	g.Expect(a.Owns("default", "any")).To(BeTrue())
+
+	// the new replica waits for the shards to be released
+	b := newTestCoordinator(g, clt, "b", &now)
+	g.Expect(b.sync(ctx)).To(Succeed())
+	g.Expect(b.HeldShards()).To(BeEmpty())
+
+	now = now.Add(time.Second)
+	g.Expect(a.sync(ctx)).To(Succeed())
+	g.Expect(a.HeldShards()).To(Equal([]int{0, 2}))
+	g.Expect(b.sync(ctx)).To(Succeed())
+	g.Expect(b.HeldShards()).To(Equal([]int{1, 3}))
+
+	// each object is owned by exactly one replica
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		g.Expect(a.Owns("default", name)).NotTo(Equal(b.Owns("default", name)))
+	}
+
+	// shards are not owned anymore when their lease is not renewed in time
+	now = now.Add(DefaultRenewDeadline)
+	g.Expect(b.HeldShards()).To(BeEmpty())
+
+	// the shards of a replica gone are claimed once its leases expire
+	now = now.Add(DefaultLeaseDuration)
+	g.Expect(a.sync(ctx)).To(Succeed())
+	g.Expect(a.HeldShards()).To(Equal([]int{0, 1, 2, 3}))
+	g.Expect(acquired).To(Equal([][]int{{0, 1, 2, 3}, {1, 3}}))
+}
+
+func TestCoordinatorRelease(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	clt := fake.NewSimpleClientset()
+	now := time.Now()
+
+	a := newTestCoordinator(g, clt, "a", &now)
+	b := newTestCoordinator(g, clt, "b", &now)
+	g.Expect(a.sync(ctx)).To(Succeed())
+	g.Expect(a.release(ctx)).To(Succeed())
+	g.Expect(a.HeldShards()).To(BeEmpty())
+
+	// released shards are claimed right away
+	g.Expect(b.sync(ctx)).To(Succeed())
+	g.Expect(b.HeldShards()).To(Equal([]int{0, 1, 2, 3}))
+}
+
+func TestCoordinatorNil(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var c *Coordinator
+	g.Expect(c.Owns("default", "name")).To(BeTrue())
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "name"}}
+	g.Expect(c.Predicate().Generic(event.GenericEvent{Object: obj})).To(BeTrue())
+	g.Expect(CoordinatorFrom(context.TODO())).To(BeNil())
+}
@@ -20,7 +20,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"errors"
 	"flag"
 	"fmt"
 	"hash/fnv"
@@ -51,12 +50,12 @@ import (
 	kmanager "github.com/AlaudaDevops/pkg/manager"
 	"github.com/AlaudaDevops/pkg/restclient"
 	kscheme "github.com/AlaudaDevops/pkg/scheme"
+	"github.com/AlaudaDevops/pkg/sharding"
 	"github.com/AlaudaDevops/pkg/tracing"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/go-logr/zapr"
 	"github.com/go-resty/resty/v2"
 	"go.uber.org/zap"
-	"golang.org/x/sync/errgroup"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 	kubeclient "knative.dev/pkg/client/injection/kube/client"
@@ -97,8 +96,16 @@ var (
 	WebServerPort      int
 	InsecureSkipVerify bool
 
+	// ClusterProxyHost is the host of the cluster proxy.
+	//
+	// Deprecated: use AppOptions.ClusterProxyHost instead
 	ClusterProxyHost string
+	// ClusterProxyPath is the path of the cluster proxy.
+	//
+	// Deprecated: use AppOptions.ClusterProxyPath instead
 	ClusterProxyPath string
+
+	ShutdownGracePeriod time.Duration
 )
 
 // AppBuilder builds an app using multiple configuration options
@@ -107,6 +114,7 @@ type AppBuilder struct {
 	Name    string
 	Context context.Context
 	Config  *rest.Config
+	Options *AppOptions
 	sync.Once
 
 	startInformers func()
@@ -135,7 +143,10 @@ type AppBuilder struct {
 	container *restful.Container
 	filters   []restful.FilterFunction
 
-	startFunc []func(context.Context) error
+	startFunc []startEntry
+
+	// shutdownGracePeriod overrides the ShutdownGracePeriod flag when set
+	shutdownGracePeriod time.Duration
 
 	initClientOnce sync.Once
 
@@ -143,62 +154,54 @@ type AppBuilder struct {
 	newResourceLock kmanager.ResourceLockFunc
 }
 
-// ParseFlag parse flag needed for App
+// ParseFlag parse flag needed for App into the package level variables
+//
+// Deprecated: use AppOptions.AddFlags and pass the options to App instead
 func ParseFlag() {
-	flag.DurationVar(&Timeout, "kube-api-timeout", DefaultTimeout,
-		"The maximum length of time to wait before giving up on a server request."+
-			"A value of zero means no timeout. DefaultTimeOut: 10s")
-	flag.Float64Var(&QPS, "kube-api-qps", float64(DefaultQPS),
-		"qps indicates the maximum QPS to the master from this client."+
-			"If it's zero, the created RESTClient will use DefaultQPS: 50")
-	flag.IntVar(&Burst, "kube-api-burst", DefaultBurst,
-		"Maximum burst for throttle."+
-			"If it's zero, the created RESTClient will use DefaultBurst: 60.")
-	// controller-runtime is not support ControllerManager Config https://github.com/kubernetes-sigs/controller-runtime/issues/895
-	//flag.StringVar(&ConfigFile, "config", "",
-	//	"The controller will load its initial configuration from this file. "+
-	//		"Omit this flag to use the default configuration values. "+
-	//		"Command-line flags override configuration from this file.")
-	flag.StringVar(&MetricsAddr, "metrics-bind-address", ":8080", "The address the metrics endpoint binds to. "+
-		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
-	flag.StringVar(&ProbeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&EnableLeaderElection, "leader-elect", true,
-		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
-	flag.DurationVar(&LeaderElectionRetryPeriod, "retry-period", 2*time.Second,
-		"retry period is the duration the LeaderElector clients should wait between tries of actions.")
-	flag.DurationVar(&LeaderElectionLeaseDuration, "lease-duration", 15*time.Second,
-		"lease duration is the duration that non-leader candidates will wait to force acquire leadership.")
-	flag.DurationVar(&LeaderElectionRenewDeadline, "renew-deadline", 10*time.Second,
-		"renew deadline is the duration that the acting controlplane will retry refreshing leadership before giving up.")
-
-	flag.BoolVar(&InsecureSkipVerify, "insecure-skip-tls-verify", false,
-		"skip TLS verification and disable cert checking (default: false)")
-	flag.StringVar(&ClusterProxyHost, "cluster-proxy-host", "",
-		"Specify the hostname or IP address of the cluster proxy.")
-	flag.StringVar(&ClusterProxyPath, "cluster-proxy-path", "",
-		"Specify the endpoint path for the cluster proxy, '{name}' as the placeholder for the cluster name.")
-	flag.IntVar(&WebServerPort, "web-server-port", 8100, "http web server port")
+	commandLineOptionsOnce.Do(func() {
+		commandLineOptions.AddFlags(flag.CommandLine)
+	})
 	flag.Parse()
+	commandLineOptions.setGlobals()
 }
 
 // App main constructor entrypoint for AppBuilder
-func App(name string) *AppBuilder {
-	return &AppBuilder{Name: name, startFunc: []func(context.Context) error{}}
+// If opts is given it will be used as is, otherwise flags are parsed
+// from the command line when the app is initialized.
+// Options are validated when the app is initialized, options built with NewAppOptions
+// have valid defaults while a zero AppOptions is invalid.
+func App(name string, opts ...*AppOptions) *AppBuilder {
+	a := &AppBuilder{Name: name, startFunc: []startEntry{}}
+	if len(opts) > 0 {
+		a.Options = opts[0]
+	}
+	return a
 }
 
 func (a *AppBuilder) init() {
 	a.Once.Do(func() {
-		ParseFlag()
+		if a.Options == nil {
+			ParseFlag()
+			a.Options = commandLineOptions
+			if err := a.Options.Load(nil); err != nil {
+				log.Fatal("Error loading app options: ", err)
+			}
+		}
+		// options given to App are used as is but must be valid,
+		// e.g. a zero LazyLoaderInterval would make the lazy loader spin
+		if err := a.Options.Validate(); err != nil {
+			log.Fatal("Invalid app options: ", err)
+		}
+		a.Options.setGlobals()
 		a.Context = ctrl.SetupSignalHandler()
 		a.Context, a.Config = GetConfigOrDie(a.Context)
-		a.Config.Timeout = Timeout
+		a.Config.Timeout = a.Options.Timeout
 
-		if a.Config.QPS < float32(QPS) {
-			a.Config.QPS = float32(QPS)
+		if a.Config.QPS < float32(a.Options.QPS) {
+			a.Config.QPS = float32(a.Options.QPS)
 		}
-		if a.Config.Burst < Burst {
-			a.Config.Burst = Burst
+		if a.Config.Burst < a.Options.Burst {
+			a.Config.Burst = a.Options.Burst
 		}
 		a.Context, a.startInformers = injection.EnableInjectionOrDie(a.Context, a.Config)
 		a.Context = kclient.WithAppConfig(a.Context, a.Config)
@@ -206,13 +209,13 @@ func (a *AppBuilder) init() {
 		restyClient := resty.NewWithClient(kclient.NewHTTPClient())
 		restyClient.SetDisableWarn(true)
 		restyClient.SetTLSClientConfig(&tls.Config{
-			InsecureSkipVerify: InsecureSkipVerify, // nolint: gosec // G402: TLS InsecureSkipVerify set true.
+			InsecureSkipVerify: a.Options.InsecureSkipVerify, // nolint: gosec // G402: TLS InsecureSkipVerify set true.
 		})
 		tracing.WrapTransportForRestyClient(restyClient)
 		a.Context = restclient.WithRESTClient(a.Context, restyClient)
 
 		a.ConfigMapWatcher = sharedmain.SetupConfigMapWatchOrDie(a.Context, a.Logger)
-		a.startFunc = append(a.startFunc, func(ctx context.Context) error {
+		a.AddStartFunc("configmap-watcher", StartPhaseInfrastructure, func(ctx context.Context) error {
 			return a.ConfigMapWatcher.Start(ctx.Done())
 		})
 
@@ -251,7 +254,7 @@ func (a *AppBuilder) initClient(clientVar ctrlclient.Client) {
 				a.Logger.Fatalw("cluster client setup error", "err", err)
 			}
 			clientVar = cluster.GetClient()
-			a.startFunc = append(a.startFunc, func(ctx context.Context) error {
+			a.AddStartFunc("cluster", StartPhaseInfrastructure, func(ctx context.Context) error {
 				err := cluster.Start(ctx)
 				if err != nil {
 					a.Logger.Errorw("cluster start error", "err", err)
@@ -308,16 +311,28 @@ func (a *AppBuilder) Tracing(ops ...tracing.TraceOption) *AppBuilder {
 		log.Fatal("Error reading/parsing tracing configuration: ", err)
 	}
 	a.filters = append(a.filters, tracing.RestfulFilter(a.Name, healthzRoutePath, readyzRoutePath))
+
+	// flushes pending spans once everything else has been stopped
+	a.AddStartFunc("tracing", StartPhaseInfrastructure, func(ctx context.Context) error {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.getShutdownGracePeriod())
+		defer cancel()
+		return t.Shutdown(shutdownCtx)
+	})
 	return a
 }
 
 // ConfigManager add katanomi manager to app context
-func (a *AppBuilder) ConfigManager() *AppBuilder {
+// options can add configuration sources layered on top of the config ConfigMap,
+// e.g. config.WithOverlayConfigMap, config.WithSecret or config.WithNamespaceOverrides
+func (a *AppBuilder) ConfigManager(opts ...config.ManagerOption) *AppBuilder {
 	a.init()
 
 	name := config.Name()
-	configMGR := config.NewManager(a.ConfigMapWatcher, a.Logger, name)
+	opts = append([]config.ManagerOption{config.WithKubeClient(kubeclient.Get(a.Context))}, opts...)
+	configMGR := config.NewManager(a.ConfigMapWatcher, a.Logger, name, opts...)
 	a.Context = config.WithConfigManager(a.Context, configMGR)
+	a.AddStartFunc("config-manager", StartPhaseInfrastructure, configMGR.Start)
 
 	return a
 }
@@ -411,17 +426,20 @@ func (a *AppBuilder) Controllers(ctors ...controllers.SetupChecker) *AppBuilder
 	a.init()
 
 	var err error
+	gracefulShutdownTimeout := a.getShutdownGracePeriod()
 	options := ctrl.Options{
 		Scheme: a.scheme,
 		Metrics: metricsserver.Options{
-			BindAddress: MetricsAddr,
+			BindAddress: a.Options.MetricsAddr,
 		},
-		HealthProbeBindAddress: ProbeAddr,
-		LeaderElection:         EnableLeaderElection,
-		LeaseDuration:          &LeaderElectionLeaseDuration,
-		RetryPeriod:            &LeaderElectionRetryPeriod,
-		RenewDeadline:          &LeaderElectionRenewDeadline,
+		HealthProbeBindAddress: a.Options.ProbeAddr,
+		LeaderElection:         a.Options.EnableLeaderElection,
+		LeaseDuration:          &a.Options.LeaderElectionLeaseDuration,
+		RetryPeriod:            &a.Options.LeaderElectionRetryPeriod,
+		RenewDeadline:          &a.Options.LeaderElectionRenewDeadline,
 		LeaderElectionID:       getLeaderElectionID(a.Name, "alauda.io"),
+		// drains running reconciles and webhooks when the context is cancelled
+		GracefulShutdownTimeout: &gracefulShutdownTimeout,
 	}
 	// If the value is empty, the default behavior will still be used.
 	// Ref: https://github.com/kubernetes-sigs/controller-runtime/blob/b9219528d95974cb4f5b06f86c9b1c9b7d3045a5/pkg/manager/manager.go#L551
@@ -464,8 +482,21 @@ func (a *AppBuilder) Controllers(ctors ...controllers.SetupChecker) *AppBuilder
 		}
 	}
 
-	// TODO: make this interval setup configurable
-	lazyLoader := controllers.NewLazyLoader(a.Context, time.Minute)
+	// controllers declaring their own election ID are elected with the same settings as the manager
+	a.Context = controllers.WithLeaderElectionOptions(a.Context, controllers.LeaderElectionOptions{
+		Enabled:         a.Options.EnableLeaderElection,
+		LeaseDuration:   a.Options.LeaderElectionLeaseDuration,
+		RenewDeadline:   a.Options.LeaderElectionRenewDeadline,
+		RetryPeriod:     a.Options.LeaderElectionRetryPeriod,
+		NewResourceLock: a.newResourceLock,
+	})
+	lazyLoader := controllers.NewLazyLoader(a.Context, a.Options.LazyLoaderInterval)
+	a.Context = controllers.WithLazyLoader(a.Context, lazyLoader)
+	if reporter, ok := lazyLoader.(controllers.LazyLoaderStatusReporter); ok {
+		if err := a.Manager.AddReadyzCheck("controllers", reporter.ReadyzCheck); err != nil {
+			a.Logger.Fatalw("unable to set up controllers ready check", "err", err)
+		}
+	}
 
 	for i := range ctors {
 		controller := ctors[i]
@@ -473,16 +504,20 @@ func (a *AppBuilder) Controllers(ctors ...controllers.SetupChecker) *AppBuilder
 		controllerAtomicLevel := a.LevelManager.Get(name)
 		controllerLogger := a.Logger.Desugar().WithOptions(zap.UpdateCore(controllerAtomicLevel, *a.ZapConfig)).Named(name).Sugar()
 
+		// the settings of the controller are validated even before it is set up
+		if err := controllers.RegisterControllerFlags(config.ConfigManager(a.Context), name); err != nil {
+			controllerLogger.Warnw("failed to register controller flags", "err", err)
+		}
 		if err := lazyLoader.LazyLoad(a.Context, a.Manager, controllerLogger, controller); err != nil {
 			a.Logger.Fatalw("controller setup error", "ctrl", name, "err", err)
 		}
 	}
 
-	a.startFunc = append(a.startFunc, func(ctx context.Context) error {
+	a.AddStartFunc("manager", StartPhaseControllers, func(ctx context.Context) error {
 		return a.Manager.Start(ctx)
 	})
 
-	a.startFunc = append(a.startFunc, func(ctx context.Context) error {
+	a.AddStartFunc("lazyloader", StartPhaseControllers, func(ctx context.Context) error {
 		return lazyLoader.Start(ctx)
 	})
 
@@ -604,6 +639,60 @@ func (a *AppBuilder) Profiling() *AppBuilder {
 	return a
 }
 
+// ShutdownGracePeriod sets the maximum duration given to all start funcs to return
+// once the app is shutting down, overriding AppOptions.ShutdownGracePeriod.
+// Must be called before Controllers to also apply to the controller manager.
+func (a *AppBuilder) ShutdownGracePeriod(gracePeriod time.Duration) *AppBuilder {
+	a.shutdownGracePeriod = gracePeriod
+	return a
+}
+
+func (a *AppBuilder) getShutdownGracePeriod() time.Duration {
+	if a.shutdownGracePeriod > 0 {
+		return a.shutdownGracePeriod
+	}
+	if a.Options != nil && a.Options.ShutdownGracePeriod > 0 {
+		return a.Options.ShutdownGracePeriod
+	}
+	return DefaultShutdownGracePeriod
+}
+
+// AddStartFunc registers a function to be started in the given phase when Run is invoked.
+// Start funcs in lower phases are started first and stopped last on shutdown,
+// the name is only used for logging and errors.
+func (a *AppBuilder) AddStartFunc(name string, phase StartPhase, startFunc StartFunc) *AppBuilder {
+	a.startFunc = append(a.startFunc, startEntry{name: name, phase: phase, start: startFunc})
+	return a
+}
+
+// Sharding enables sharding of controllers across the replicas of the app using the given number of shards.
+// Replicas claim shards using Leases in the system namespace, identified by the POD_NAME env or the hostname.
+// The coordinator is stored in the context and should be called before Controllers,
+// controllers retrieve it using sharding.CoordinatorFrom and opt in using controllers.WithSharding.
+func (a *AppBuilder) Sharding(shards int) *AppBuilder {
+	a.init()
+
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	coordinator, err := sharding.NewCoordinator(kubeclient.Get(a.Context), a.Logger.Named("sharding"), sharding.Options{
+		Name:          a.Name,
+		Namespace:     system.Namespace(),
+		Identity:      identity,
+		Shards:        shards,
+		LeaseDuration: a.Options.LeaderElectionLeaseDuration,
+		RenewDeadline: a.Options.LeaderElectionRenewDeadline,
+		RetryPeriod:   a.Options.LeaderElectionRetryPeriod,
+	})
+	if err != nil {
+		a.Logger.Fatalw("unable to set up sharding", "err", err)
+	}
+	a.Context = sharding.WithCoordinator(a.Context, coordinator)
+	a.AddStartFunc("sharding", StartPhaseInfrastructure, coordinator.Start)
+	return a
+}
+
 // NewResourceLock set a new resource lock
 // Used to change the default behavior in controller-runtime
 func (a *AppBuilder) NewResourceLock(newResourceLock kmanager.ResourceLockFunc) *AppBuilder {
@@ -611,7 +700,10 @@ func (a *AppBuilder) NewResourceLock(newResourceLock kmanager.ResourceLockFunc)
 	return a
 }
 
-// Run starts all
+// Run starts all registered start funcs and the given startFuncs in StartPhaseDefault
+// and blocks until the app context is done or any of them returns an error.
+// The start funcs are then stopped in the reverse order of their phases within the
+// shutdown grace period, and all errors returned by them are aggregated and returned.
 func (a *AppBuilder) Run(startFuncs ...func(context.Context) error) error {
 	defer func() {
 		if a.Logger != nil {
@@ -622,50 +714,47 @@ func (a *AppBuilder) Run(startFuncs ...func(context.Context) error) error {
 	// will init a client if not already initiated
 	a.initClient(nil)
 
+	gracePeriod := a.getShutdownGracePeriod()
+
 	// adds a http server if there are any endpoints registered
 	if a.container != nil {
 		// adds profiling and health checks
 		a.container.Add(route.NewDefaultService(a.Context))
 
-		a.startFunc = append(a.startFunc, func(ctx context.Context) error {
+		a.AddStartFunc("webserver", StartPhaseServers, func(ctx context.Context) error {
 			// TODO: find a better way to get this configuration
 			for _, filter := range a.filters {
 				a.container.Filter(filter)
 			}
 
 			srv := &http.Server{
-				Addr:    fmt.Sprintf(":%d", WebServerPort),
+				Addr:    fmt.Sprintf(":%d", a.Options.WebServerPort),
 				Handler: a.container,
 			}
-			return srv.ListenAndServe()
+			return serveHTTP(ctx, srv, gracePeriod)
 		})
 	}
 
 	a.startInformers()
 
-	eg, egCtx := errgroup.WithContext(a.Context)
-	startFuncs = append(startFuncs, a.startFunc...)
-	for i, st := range startFuncs {
-		var index = i
-		startFunc := st
-		eg.Go(func() error {
-			err := startFunc(egCtx)
-			// TODO: Fatal here, because not all startFunc have completed cancel mechanism by ctx, this will cause eg.Wait() handled and never stopped even some errors happened.
-			// it is not a good solution, we should change it soon.
-			if err != nil {
-				a.Logger.Fatalw("error to start func", "index", index, "err", err)
-			}
-			return nil
-		})
+	for i, startFunc := range startFuncs {
+		a.AddStartFunc(fmt.Sprintf("run-%d", i), StartPhaseDefault, startFunc)
 	}
 
-	// wait until all are done
-	err := eg.Wait()
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		a.Logger.Errorw("Error while running server", zap.Error(err))
+	logger := a.Logger
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
 	}
-
-	return nil
+	l := &lifecycle{
+		entries:     a.startFunc,
+		gracePeriod: gracePeriod,
+		logger:      logger,
+	}
+	err := l.run(a.Context)
+	if err != nil {
+		logger.Errorw("Error while running app", zap.Error(err))
+	}
+	return err
 }
 
 // WebhookSetup method to inject and setup webhooks using the object
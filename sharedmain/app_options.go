@@ -0,0 +1,319 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharedmain
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlaudaDevops/pkg/config"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigFileFlag is the name of the flag used to specify the app config file
+	ConfigFileFlag = "config"
+	// DefaultEnvPrefix is the default prefix of environment variables read by AppOptions.Load
+	DefaultEnvPrefix = "APP_"
+)
+
+// AppOptions stores all options used to build and run an app.
+// Options can be populated from flags, environment variables and a YAML config file,
+// see Load for the precedence rules.
+type AppOptions struct {
+	// EnvPrefix the prefix of environment variables used to set options, see EnvName
+	EnvPrefix string
+	// ConfigFile the path of a YAML file to load options from.
+	// Keys in the file are the flag names, e.g. `kube-api-qps: 80`
+	ConfigFile string
+
+	// Timeout the maximum length of time to wait before giving up on a server request
+	Timeout time.Duration
+	// QPS the maximum QPS to the kubernetes api server
+	QPS float64
+	// Burst maximum burst for throttle to the kubernetes api server
+	Burst int
+	// InsecureSkipVerify skips TLS verification of the REST client
+	InsecureSkipVerify bool
+
+	// MetricsAddr the address the metrics endpoint binds to
+	MetricsAddr string
+	// ProbeAddr the address the health probe endpoint binds to
+	ProbeAddr string
+
+	// EnableLeaderElection enables leader election for the controller manager
+	EnableLeaderElection bool
+	// LeaderElectionRetryPeriod the duration the leader election clients should wait between tries of actions
+	LeaderElectionRetryPeriod time.Duration
+	// LeaderElectionLeaseDuration the duration that non-leader candidates will wait to force acquire leadership
+	LeaderElectionLeaseDuration time.Duration
+	// LeaderElectionRenewDeadline the duration that the acting controlplane will retry refreshing leadership before giving up
+	LeaderElectionRenewDeadline time.Duration
+
+	// WebServerPort the port of the http web server
+	WebServerPort int
+	// ShutdownGracePeriod the maximum duration given to servers and controllers to stop
+	ShutdownGracePeriod time.Duration
+	// LazyLoaderInterval the interval used to check controllers pending on dependencies
+	LazyLoaderInterval time.Duration
+
+	// ClusterProxyHost the hostname or IP address of the cluster proxy
+	ClusterProxyHost string
+	// ClusterProxyPath the endpoint path for the cluster proxy
+	ClusterProxyPath string
+
+	// flagSet holds all flags bound to the fields above
+	flagSet *flag.FlagSet
+	// explicit records flags explicitly set by the user through a command line parser
+	explicit map[string]bool
+}
+
+// NewAppOptions returns AppOptions populated with default values
+func NewAppOptions() *AppOptions {
+	o := &AppOptions{EnvPrefix: DefaultEnvPrefix, explicit: map[string]bool{}}
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.StringVar(&o.ConfigFile, ConfigFileFlag, "",
+		"The app will load its initial configuration from this file. "+
+			"Omit this flag to use the default configuration values. "+
+			"Command-line flags and environment variables override configuration from this file.")
+	fs.DurationVar(&o.Timeout, "kube-api-timeout", DefaultTimeout,
+		"The maximum length of time to wait before giving up on a server request."+
+			"A value of zero means no timeout. DefaultTimeOut: 10s")
+	fs.Float64Var(&o.QPS, "kube-api-qps", float64(DefaultQPS),
+		"qps indicates the maximum QPS to the master from this client."+
+			"If it's zero, the created RESTClient will use DefaultQPS: 50")
+	fs.IntVar(&o.Burst, "kube-api-burst", DefaultBurst,
+		"Maximum burst for throttle."+
+			"If it's zero, the created RESTClient will use DefaultBurst: 60.")
+	fs.StringVar(&o.MetricsAddr, "metrics-bind-address", ":8080", "The address the metrics endpoint binds to. "+
+		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
+	fs.StringVar(&o.ProbeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	fs.BoolVar(&o.EnableLeaderElection, "leader-elect", true,
+		"Enable leader election for controller manager. "+
+			"Enabling this will ensure there is only one active controller manager.")
+	fs.DurationVar(&o.LeaderElectionRetryPeriod, "retry-period", 2*time.Second,
+		"retry period is the duration the LeaderElector clients should wait between tries of actions.")
+	fs.DurationVar(&o.LeaderElectionLeaseDuration, "lease-duration", 15*time.Second,
+		"lease duration is the duration that non-leader candidates will wait to force acquire leadership.")
+	fs.DurationVar(&o.LeaderElectionRenewDeadline, "renew-deadline", 10*time.Second,
+		"renew deadline is the duration that the acting controlplane will retry refreshing leadership before giving up.")
+	fs.BoolVar(&o.InsecureSkipVerify, "insecure-skip-tls-verify", false,
+		"skip TLS verification and disable cert checking (default: false)")
+	fs.StringVar(&o.ClusterProxyHost, "cluster-proxy-host", "",
+		"Specify the hostname or IP address of the cluster proxy.")
+	fs.StringVar(&o.ClusterProxyPath, "cluster-proxy-path", "",
+		"Specify the endpoint path for the cluster proxy, '{name}' as the placeholder for the cluster name.")
+	fs.IntVar(&o.WebServerPort, "web-server-port", 8100, "http web server port")
+	fs.DurationVar(&o.ShutdownGracePeriod, "shutdown-grace-period", DefaultShutdownGracePeriod,
+		"the maximum duration given to servers and controllers to finish their work when the app is shutting down.")
+	fs.DurationVar(&o.LazyLoaderInterval, "lazy-loader-interval", time.Minute,
+		"the interval used to check if controllers pending on dependencies can be started, "+
+			"can be overridden by the "+config.LazyLoaderIntervalKey+" key of the config manager.")
+	o.flagSet = fs
+	return o
+}
+
+// explicitValue marks a flag as explicitly set whenever a command line parser sets it
+type explicitValue struct {
+	flag.Value
+	name     string
+	explicit map[string]bool
+}
+
+// String implements flag.Value
+func (v *explicitValue) String() string {
+	if v == nil || v.Value == nil {
+		return ""
+	}
+	return v.Value.String()
+}
+
+// Set implements flag.Value
+func (v *explicitValue) Set(value string) error {
+	if err := v.Value.Set(value); err != nil {
+		return err
+	}
+	v.explicit[v.name] = true
+	return nil
+}
+
+// IsBoolFlag allows boolean flags to be used without a value
+func (v *explicitValue) IsBoolFlag() bool {
+	boolFlag, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// AddFlags registers all options as flags into fs.
+// When using pflag or cobra, register into a go FlagSet and add it with AddGoFlagSet.
+func (o *AppOptions) AddFlags(fs *flag.FlagSet) {
+	o.flagSet.VisitAll(func(f *flag.Flag) {
+		fs.Var(&explicitValue{Value: f.Value, name: f.Name, explicit: o.explicit}, f.Name, f.Usage)
+	})
+}
+
+// EnvName returns the environment variable name used for a flag,
+// e.g. APP_KUBE_API_QPS for kube-api-qps with the default prefix
+func (o *AppOptions) EnvName(flagName string) string {
+	return o.EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load resolves options from all sources and validates the result.
+// Sources are applied with the following precedence, from highest to lowest:
+// flags explicitly set, environment variables, the config file and default values.
+// lookupEnv is used to read environment variables, defaults to os.LookupEnv if nil.
+func (o *AppOptions) Load(lookupEnv func(string) (string, bool)) error {
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	// the config file itself can also be given through an environment variable
+	if !o.explicit[ConfigFileFlag] {
+		if value, ok := lookupEnv(o.EnvName(ConfigFileFlag)); ok {
+			o.ConfigFile = value
+		}
+	}
+	if o.ConfigFile != "" {
+		if err := o.loadFile(o.ConfigFile); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	o.flagSet.VisitAll(func(f *flag.Flag) {
+		if o.explicit[f.Name] || f.Name == ConfigFileFlag {
+			return
+		}
+		value, ok := lookupEnv(o.EnvName(f.Name))
+		if !ok {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for environment variable %s: %w", value, o.EnvName(f.Name), err))
+		}
+	})
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+
+	return o.Validate()
+}
+
+// loadFile reads the YAML config file and sets all options not explicitly set by flags
+func (o *AppOptions) loadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read app config file %q: %w", file, err)
+	}
+	values := map[string]interface{}{}
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parse app config file %q: %w", file, err)
+	}
+
+	var errs []error
+	for key, value := range values {
+		f := o.flagSet.Lookup(key)
+		if f == nil || key == ConfigFileFlag {
+			errs = append(errs, fmt.Errorf("unknown key %q in app config file %q", key, file))
+			continue
+		}
+		if o.explicit[key] {
+			continue
+		}
+		if err := f.Value.Set(formatValue(value)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %v for key %q in app config file %q: %w", value, key, file, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// formatValue formats a decoded YAML value as a flag value
+func formatValue(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		// avoids exponent notation for large integers
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// Validate checks that all options have valid values
+func (o *AppOptions) Validate() error {
+	var errs []error
+	if o.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("kube-api-timeout must not be negative, got %s", o.Timeout))
+	}
+	if o.QPS < 0 {
+		errs = append(errs, fmt.Errorf("kube-api-qps must not be negative, got %v", o.QPS))
+	}
+	if o.Burst < 0 {
+		errs = append(errs, fmt.Errorf("kube-api-burst must not be negative, got %d", o.Burst))
+	}
+	if o.WebServerPort <= 0 || o.WebServerPort > 65535 {
+		errs = append(errs, fmt.Errorf("web-server-port must be between 1 and 65535, got %d", o.WebServerPort))
+	}
+	if o.LazyLoaderInterval <= 0 {
+		errs = append(errs, fmt.Errorf("lazy-loader-interval must be positive, got %s", o.LazyLoaderInterval))
+	}
+	if o.ShutdownGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("shutdown-grace-period must not be negative, got %s", o.ShutdownGracePeriod))
+	}
+	if o.EnableLeaderElection {
+		if o.LeaderElectionRetryPeriod <= 0 {
+			errs = append(errs, fmt.Errorf("retry-period must be positive, got %s", o.LeaderElectionRetryPeriod))
+		}
+		if o.LeaderElectionRenewDeadline <= o.LeaderElectionRetryPeriod {
+			errs = append(errs, fmt.Errorf("renew-deadline %s must be greater than retry-period %s",
+				o.LeaderElectionRenewDeadline, o.LeaderElectionRetryPeriod))
+		}
+		if o.LeaderElectionLeaseDuration <= o.LeaderElectionRenewDeadline {
+			errs = append(errs, fmt.Errorf("lease-duration %s must be greater than renew-deadline %s",
+				o.LeaderElectionLeaseDuration, o.LeaderElectionRenewDeadline))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+var (
+	// commandLineOptions options bound to flag.CommandLine by ParseFlag
+	commandLineOptions     = NewAppOptions()
+	commandLineOptionsOnce sync.Once
+)
+
+// setGlobals copies options into the deprecated package level variables
+// to keep compatibility with consumers still reading them
+func (o *AppOptions) setGlobals() {
+	ConfigFile = o.ConfigFile
+	Timeout = o.Timeout
+	QPS = o.QPS
+	Burst = o.Burst
+	InsecureSkipVerify = o.InsecureSkipVerify
+	MetricsAddr = o.MetricsAddr
+	ProbeAddr = o.ProbeAddr
+	EnableLeaderElection = o.EnableLeaderElection
+	LeaderElectionRetryPeriod = o.LeaderElectionRetryPeriod
+	LeaderElectionLeaseDuration = o.LeaderElectionLeaseDuration
+	LeaderElectionRenewDeadline = o.LeaderElectionRenewDeadline
+	WebServerPort = o.WebServerPort
+	ShutdownGracePeriod = o.ShutdownGracePeriod
+	ClusterProxyHost = o.ClusterProxyHost
+	ClusterProxyPath = o.ClusterProxyPath
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharedmain
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func envFrom(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestAppOptionsLoad(t *testing.T) {
+	t.Run("default values", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		opts := NewAppOptions()
+
+		g.Expect(opts.Load(envFrom(nil))).To(Succeed())
+		g.Expect(opts.QPS).To(Equal(float64(DefaultQPS)))
+		g.Expect(opts.Burst).To(Equal(DefaultBurst))
+		g.Expect(opts.Timeout).To(Equal(DefaultTimeout))
+		g.Expect(opts.WebServerPort).To(Equal(8100))
+		g.Expect(opts.EnableLeaderElection).To(BeTrue())
+		g.Expect(opts.ShutdownGracePeriod).To(Equal(DefaultShutdownGracePeriod))
+	})
+
+	t.Run("flags override env which overrides config file", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		opts := NewAppOptions()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		opts.AddFlags(fs)
+
+		g.Expect(fs.Parse([]string{
+			"--config", "testdata/appoptions.yaml",
+			"--kube-api-qps", "100",
+		})).To(Succeed())
+		g.Expect(opts.Load(envFrom(map[string]string{
+			"APP_KUBE_API_QPS":     "90",
+			"APP_KUBE_API_TIMEOUT": "30s",
+		}))).To(Succeed())
+
+		// flag
+		g.Expect(opts.QPS).To(Equal(float64(100)))
+		// env
+		g.Expect(opts.Timeout).To(Equal(30 * time.Second))
+		// config file
+		g.Expect(opts.WebServerPort).To(Equal(9100))
+		g.Expect(opts.EnableLeaderElection).To(BeFalse())
+		// default
+		g.Expect(opts.Burst).To(Equal(DefaultBurst))
+	})
+
+	t.Run("config file from env", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		opts := NewAppOptions()
+
+		g.Expect(opts.Load(envFrom(map[string]string{
+			"APP_CONFIG": "testdata/appoptions.yaml",
+		}))).To(Succeed())
+		g.Expect(opts.QPS).To(Equal(float64(80)))
+	})
+
+	t.Run("unknown key in config file", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		g.Expect(os.WriteFile(file, []byte("unknown-key: 1\n"), 0600)).To(Succeed())
+		opts := NewAppOptions()
+		opts.ConfigFile = file
+
+		err := opts.Load(envFrom(nil))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring(`unknown key "unknown-key"`))
+	})
+
+	t.Run("invalid env value", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		opts := NewAppOptions()
+
+		err := opts.Load(envFrom(map[string]string{"APP_KUBE_API_BURST": "many"}))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("APP_KUBE_API_BURST"))
+	})
+}
+
+func TestAppOptionsValidate(t *testing.T) {
+	t.Run("valid default options", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		g.Expect(NewAppOptions().Validate()).To(Succeed())
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		opts := NewAppOptions()
+		opts.QPS = -1
+		opts.WebServerPort = 0
+		opts.LeaderElectionRenewDeadline = time.Minute
+
+		err := opts.Validate()
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("kube-api-qps"))
+		g.Expect(err.Error()).To(ContainSubstring("web-server-port"))
+		g.Expect(err.Error()).To(ContainSubstring("lease-duration"))
+	})
+
+	t.Run("leader election durations are ignored when disabled", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		opts := NewAppOptions()
+		opts.EnableLeaderElection = false
+		opts.LeaderElectionRenewDeadline = time.Minute
+
+		g.Expect(opts.Validate()).To(Succeed())
+	})
+}
+
+func TestApp(t *testing.T) {
+	g := NewGomegaWithT(t)
+	opts := NewAppOptions()
+
+	g.Expect(App("test", opts).Options).To(BeIdenticalTo(opts))
+	g.Expect(App("test").Options).To(BeNil())
+}
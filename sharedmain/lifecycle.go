@@ -0,0 +1,196 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharedmain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// StartPhase defines the order in which start funcs are started and stopped.
+// Start funcs in lower phases are started first and stopped last.
+type StartPhase int
+
+const (
+	// StartPhaseInfrastructure is used by configmap watchers, caches and
+	// tracing which all other components depend on.
+	StartPhaseInfrastructure StartPhase = 0
+	// StartPhaseControllers is used by the controller manager and the controllers lazy loader.
+	StartPhaseControllers StartPhase = 100
+	// StartPhaseServers is used by the http server of the restful container.
+	StartPhaseServers StartPhase = 200
+	// StartPhaseDefault is used by start funcs given directly to Run.
+	StartPhaseDefault StartPhase = 300
+)
+
+// DefaultShutdownGracePeriod is the default time given to all start funcs to return
+// once the app starts shutting down.
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// StartFunc is a function started by the app when Run is invoked.
+// It should block until the given context is done and then release its resources.
+// Returning an error before the context is done will shut down the whole app.
+type StartFunc func(ctx context.Context) error
+
+// startEntry a StartFunc registered in a specific phase
+type startEntry struct {
+	name  string
+	phase StartPhase
+	start StartFunc
+}
+
+// lifecycle starts start funcs ordered by phase and stops them in the reverse order
+type lifecycle struct {
+	entries     []startEntry
+	gracePeriod time.Duration
+	logger      *zap.SugaredLogger
+}
+
+// phaseRun tracks all running start funcs of the same phase
+type phaseRun struct {
+	phase  StartPhase
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// run starts all entries and blocks until ctx is done or any entry returns an error.
+// Afterwards phases are cancelled one by one from the highest to the lowest, waiting
+// for all start funcs of a phase to return before moving on to the next one.
+// The whole shutdown is bound by gracePeriod.
+// All errors returned by start funcs are aggregated in the returned error.
+func (l *lifecycle) run(ctx context.Context) error {
+	entries := append([]startEntry{}, l.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].phase < entries[j].phase
+	})
+
+	var (
+		lock     sync.Mutex
+		errs     []error
+		failed   = make(chan struct{})
+		failOnce sync.Once
+		all      sync.WaitGroup
+		runs     []*phaseRun
+	)
+
+	// the start funcs should keep the values of ctx but only be cancelled
+	// by the lifecycle in the expected order
+	baseCtx := context.WithoutCancel(ctx)
+	var phaseCtx context.Context
+	for _, entry := range entries {
+		if len(runs) == 0 || runs[len(runs)-1].phase != entry.phase {
+			run := &phaseRun{phase: entry.phase}
+			phaseCtx, run.cancel = context.WithCancel(baseCtx)
+			runs = append(runs, run)
+		}
+		run := runs[len(runs)-1]
+		run.wg.Add(1)
+		all.Add(1)
+		go func(ctx context.Context) {
+			defer all.Done()
+			defer run.wg.Done()
+			err := entry.start(ctx)
+			if isShutdownError(err) {
+				return
+			}
+			l.logger.Errorw("start func returned error", "name", entry.name, "phase", entry.phase, "err", err)
+			lock.Lock()
+			errs = append(errs, fmt.Errorf("start func %q: %w", entry.name, err))
+			lock.Unlock()
+			failOnce.Do(func() { close(failed) })
+		}(phaseCtx)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		all.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-ctx.Done():
+		l.logger.Infow("shutting down app", "gracePeriod", l.gracePeriod)
+	case <-failed:
+		l.logger.Infow("shutting down app due to start func error", "gracePeriod", l.gracePeriod)
+	case <-finished:
+	}
+
+	deadline := time.After(l.gracePeriod)
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		run.cancel()
+
+		stopped := make(chan struct{})
+		go func() {
+			run.wg.Wait()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+			l.logger.Debugw("start funcs stopped", "phase", run.phase)
+		case <-deadline:
+			// the deadline is consumed, cancel the remaining phases at once
+			for j := i - 1; j >= 0; j-- {
+				runs[j].cancel()
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			errs = append(errs, fmt.Errorf("shutdown grace period %s exceeded while stopping phase %d", l.gracePeriod, run.phase))
+			return utilerrors.NewAggregate(errs)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	return utilerrors.NewAggregate(errs)
+}
+
+// isShutdownError returns true if err is nil or only reports a regular shutdown
+func isShutdownError(err error) bool {
+	return err == nil || errors.Is(err, http.ErrServerClosed) || errors.Is(err, context.Canceled)
+}
+
+// serveHTTP starts srv and gracefully shuts it down once ctx is done.
+// In-flight requests are given at most gracePeriod to complete.
+func serveHTTP(ctx context.Context, srv *http.Server, gracePeriod time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), gracePeriod)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("http server shutdown: %w", err)
+	}
+	return nil
+}
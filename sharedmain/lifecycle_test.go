@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharedmain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+type stopRecorder struct {
+	lock    sync.Mutex
+	stopped []string
+}
+
+func (r *stopRecorder) blocking(name string) startEntry {
+	return startEntry{name: name, start: func(ctx context.Context) error {
+		<-ctx.Done()
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.stopped = append(r.stopped, name)
+		return ctx.Err()
+	}}
+}
+
+func withPhase(entry startEntry, phase StartPhase) startEntry {
+	entry.phase = phase
+	return entry
+}
+
+func TestLifecycleRun(t *testing.T) {
+	t.Run("stops phases in reverse order when context is done", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		recorder := &stopRecorder{}
+		l := &lifecycle{
+			entries: []startEntry{
+				withPhase(recorder.blocking("servers"), StartPhaseServers),
+				withPhase(recorder.blocking("infra"), StartPhaseInfrastructure),
+				withPhase(recorder.blocking("controllers"), StartPhaseControllers),
+			},
+			gracePeriod: time.Second,
+			logger:      zap.NewNop().Sugar(),
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+
+		g.Expect(l.run(ctx)).To(Succeed())
+		g.Expect(recorder.stopped).To(Equal([]string{"servers", "controllers", "infra"}))
+	})
+
+	t.Run("shuts down and returns the error of a failed start func", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		recorder := &stopRecorder{}
+		l := &lifecycle{
+			entries: []startEntry{
+				withPhase(recorder.blocking("infra"), StartPhaseInfrastructure),
+				{name: "failing", phase: StartPhaseDefault, start: func(ctx context.Context) error {
+					return errors.New("boom")
+				}},
+			},
+			gracePeriod: time.Second,
+			logger:      zap.NewNop().Sugar(),
+		}
+
+		err := l.run(context.Background())
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring(`start func "failing": boom`))
+		g.Expect(recorder.stopped).To(Equal([]string{"infra"}))
+	})
+
+	t.Run("returns an error when the grace period is exceeded", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		release := make(chan struct{})
+		defer close(release)
+		l := &lifecycle{
+			entries: []startEntry{
+				{name: "stuck", phase: StartPhaseDefault, start: func(ctx context.Context) error {
+					<-release
+					return nil
+				}},
+			},
+			gracePeriod: 50 * time.Millisecond,
+			logger:      zap.NewNop().Sugar(),
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := l.run(ctx)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("shutdown grace period"))
+	})
+
+	t.Run("returns when all start funcs completed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		l := &lifecycle{
+			entries: []startEntry{
+				{name: "once", start: func(ctx context.Context) error { return nil }},
+			},
+			gracePeriod: time.Second,
+			logger:      zap.NewNop().Sugar(),
+		}
+
+		g.Expect(l.run(context.Background())).To(Succeed())
+	})
+}
+
+func TestServeHTTP(t *testing.T) {
+	g := NewGomegaWithT(t)
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveHTTP(ctx, srv, time.Second)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	g.Eventually(done).Should(Receive(BeNil()))
+}
@@ -57,6 +57,7 @@ var _ = Describe("ParseFlag", func() {
 				"--lease-duration", "10s",
 				"--renew-deadline", "1s",
 			})
+			commandLineOptions.setGlobals()
 		})
 		It("return configured values", func() {
 			Expect(QPS).To(Equal(float64(80)))
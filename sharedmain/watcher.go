@@ -21,5 +21,6 @@ import (
 )
 
 // DefaultingWatcherWithOnChange is a configmap.DefaultingWatcher that also has an OnChange callback.
+//
 // Deprecated: please use watcher.DefaultWatcherWithOnChange
 type DefaultingWatcherWithOnChange watcher.DefaultingWatcherWithOnChange
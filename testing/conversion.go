@@ -0,0 +1,92 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"math/rand"
+
+	"github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	"github.com/google/go-cmp/cmp"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+// ObjectConverter converts objects between versions, e.g. an admission.ConversionWebhook
+type ObjectConverter interface {
+	Convert(ctx context.Context, src, dst runtime.Object) error
+}
+
+// FuzzConversionRoundTrip fuzzes hub and spoke objects and checks that they are not changed
+// when converted to the other version and back, using the fuzzer functions of the object
+// metadata merged with funcs. The v1alpha1.ConversionDataAnnotationKey annotation added
+// to spokes converted from the hub is ignored.
+// The fuzzer uses a random seed reported by the failures, see FuzzConversionRoundTripWithSeed.
+//
+//	FuzzConversionRoundTrip(g, webhook, scheme, &v1beta1.Build{}, &v1alpha1.Build{}, 100)
+func FuzzConversionRoundTrip(g *WithT, converter ObjectConverter, scheme *runtime.Scheme, hub, spoke runtime.Object, iterations int, funcs ...fuzzer.FuzzerFuncs) {
+	FuzzConversionRoundTripWithSeed(g, converter, scheme, hub, spoke, rand.Int63(), iterations, funcs...)
+}
+
+// FuzzConversionRoundTripWithSeed is the same as FuzzConversionRoundTrip using the given seed,
+// mostly used to reproduce a failure
+func FuzzConversionRoundTripWithSeed(g *WithT, converter ObjectConverter, scheme *runtime.Scheme, hub, spoke runtime.Object, seed int64, iterations int, funcs ...fuzzer.FuzzerFuncs) {
+	ctx := context.Background()
+	f := fuzzer.FuzzerFor(
+		fuzzer.MergeFuzzerFuncs(append([]fuzzer.FuzzerFuncs{metafuzzer.Funcs}, funcs...)...),
+		rand.NewSource(seed),
+		serializer.NewCodecFactory(scheme),
+	)
+
+	roundTrip := func(original, other runtime.Object) {
+		for i := 0; i < iterations; i++ {
+			src := original.DeepCopyObject()
+			f.Fuzz(src)
+			src.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+			fuzzed := src.DeepCopyObject()
+
+			converted := other.DeepCopyObject()
+			g.Expect(converter.Convert(ctx, src, converted)).To(Succeed(), "convert %T to %T, seed %d", src, converted, seed)
+			result := original.DeepCopyObject()
+			g.Expect(converter.Convert(ctx, converted, result)).To(Succeed(), "convert %T to %T, seed %d", converted, result, seed)
+
+			g.Expect(apiequality.Semantic.DeepEqual(src, fuzzed)).To(BeTrue(), "source %T changed by the conversion, seed %d: %s", src, seed, cmp.Diff(fuzzed, src))
+			removeConversionDataAnnotation(fuzzed)
+			removeConversionDataAnnotation(result)
+			result.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+			g.Expect(apiequality.Semantic.DeepEqual(fuzzed, result)).To(BeTrue(), "%T changed by the round trip, seed %d: %s", fuzzed, seed, cmp.Diff(fuzzed, result))
+		}
+	}
+	roundTrip(hub, spoke)
+	roundTrip(spoke, hub)
+}
+
+func removeConversionDataAnnotation(obj runtime.Object) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	annotations := accessor.GetAnnotations()
+	delete(annotations, v1alpha1.ConversionDataAnnotationKey)
+	accessor.SetAnnotations(annotations)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ControllerAttributeKey is the span attribute of the name of the controller
+	ControllerAttributeKey = attribute.Key("controller.name")
+	// NamespaceAttributeKey is the span attribute of the namespace of the reconciled object
+	NamespaceAttributeKey = attribute.Key("controller.request.namespace")
+	// NameAttributeKey is the span attribute of the name of the reconciled object
+	NameAttributeKey = attribute.Key("controller.request.name")
+)
+
+// StartReconcileSpan starts a span for the reconciliation of an object by a controller
+// using the global tracer provider, the span should be ended using EndSpan.
+func StartReconcileSpan(ctx context.Context, controllerName, namespace, name string) (context.Context, trace.Span) {
+	tracer := otel.GetTracerProvider().Tracer(controllerName)
+	return tracer.Start(ctx, "Reconcile "+controllerName,
+		trace.WithAttributes(
+			ControllerAttributeKey.String(controllerName),
+			NamespaceAttributeKey.String(namespace),
+			NameAttributeKey.String(name),
+		),
+		trace.WithSpanKind(trace.SpanKindInternal),
+	)
+}
+
+// EndSpan ends the span, the span is marked as failed if err is not nil
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+	span.End()
+}
@@ -65,6 +65,9 @@ type Tracing struct {
 
 	traceProviderOptions []trace.TracerProviderOption
 	Propagators          []propagation.TextMapPropagator
+
+	// provider the trace provider currently set as global
+	provider traceApi.TracerProvider
 }
 
 // ApplyConfig Apply configuration and reinitialize global tracing.
@@ -89,6 +92,22 @@ func (t *Tracing) ApplyConfig(cfg *Config) {
 	}
 
 	otel.SetTracerProvider(tp)
+	t.provider = tp
+}
+
+// Shutdown flushes all pending spans of the current trace provider and stops it.
+// Should be invoked when the application exits.
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	provider, ok := t.provider.(interface {
+		Shutdown(context.Context) error
+	})
+	if !ok {
+		return nil
+	}
+	return provider.Shutdown(ctx)
 }
 
 // traceProvider construct traceProvider according to the specified configuration.
@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	kconfig "github.com/AlaudaDevops/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// ConfigMapValidator validates the configuration ConfigMap watched by config.Manager,
+// named as config.Name(), rejecting values which are invalid for the flags registered in Registry.
+// When the SYSTEM_NAMESPACE env is set only the ConfigMap in that namespace is validated.
+// Other ConfigMaps are always allowed.
+//
+// It can be added to an app using sharedmain.AppBuilder.Webhooks
+// and is served at the path /validate--v1-configmap.
+//
+// As ConfigMaps are a core type, the ValidatingWebhookConfiguration must be scoped to the configuration ConfigMap,
+// otherwise every ConfigMap write of the cluster goes through the webhook, and its failurePolicy should be Ignore
+// so that an outage of the webhook does not block ConfigMap writes, e.g.:
+//
+//	webhooks:
+//	- name: config.example.io
+//	  clientConfig:
+//	    service: {name: <service>, namespace: <system namespace>, path: /validate--v1-configmap}
+//	  rules:
+//	  - {apiGroups: [""], apiVersions: ["v1"], operations: ["CREATE", "UPDATE"], resources: ["configmaps"]}
+//	  namespaceSelector:
+//	    matchLabels: {kubernetes.io/metadata.name: <system namespace>}
+//	  objectSelector:
+//	    matchLabels: {<label set on the configuration ConfigMap>}
+//	  matchConditions: # Kubernetes 1.28+, alternative to the objectSelector
+//	  - {name: config, expression: "object.metadata.name == '<config name>'"}
+//	  failurePolicy: Ignore
+//	  sideEffects: None
+//	  admissionReviewVersions: ["v1"]
+type ConfigMapValidator struct {
+	corev1.ConfigMap `json:",inline"`
+
+	// Registry used to validate the values, config.DefaultRegistry is used if nil
+	Registry *kconfig.Registry `json:"-"`
+}
+
+var _ Validator = &ConfigMapValidator{}
+
+// NewConfigMapValidator returns a validator for the configuration ConfigMap
+func NewConfigMapValidator(registry *kconfig.Registry) *ConfigMapValidator {
+	return &ConfigMapValidator{Registry: registry}
+}
+
+// DeepCopyObject copies the ConfigMap keeping the registry
+func (v *ConfigMapValidator) DeepCopyObject() runtime.Object {
+	return &ConfigMapValidator{
+		ConfigMap: *v.ConfigMap.DeepCopy(),
+		Registry:  v.Registry,
+	}
+}
+
+// ValidateCreate validates all values of the ConfigMap
+func (v *ConfigMapValidator) ValidateCreate(ctx context.Context) error {
+	if !v.isConfig() {
+		return nil
+	}
+	if errs := ValidateConfigData(v.registry(), v.Data, nil); errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// ValidateUpdate validates the values added or changed in the ConfigMap,
+// so that values which became invalid after a flag registration do not block other changes.
+func (v *ConfigMapValidator) ValidateUpdate(ctx context.Context, old runtime.Object) error {
+	if !v.isConfig() {
+		return nil
+	}
+	var oldData map[string]string
+	if oldConfigMap, ok := old.(*ConfigMapValidator); ok {
+		oldData = oldConfigMap.Data
+	}
+	if errs := ValidateConfigData(v.registry(), v.Data, oldData); errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// ValidateDelete allows deleting the ConfigMap, default values are used instead
+func (v *ConfigMapValidator) ValidateDelete(ctx context.Context) error {
+	return nil
+}
+
+// GetLoggerName returns the logger name of the webhook
+func (v *ConfigMapValidator) GetLoggerName() string {
+	return "configmap-webhook-validation"
+}
+
+// SetupWebhookWithManager does nothing, the webhook is registered by SetupRegisterWithManager
+// because ConfigMap is not a custom type handled by the webhook builder of controller-runtime.
+func (v *ConfigMapValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return nil
+}
+
+// SetupRegisterWithManager registers the validating webhook in the webhook server of the manager,
+// see ConfigMapValidator for the scoping and failurePolicy the webhook configuration requires
+func (v *ConfigMapValidator) SetupRegisterWithManager(ctx context.Context, mgr ctrl.Manager) {
+	gvk := corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	mgr.GetWebhookServer().Register(generateValidatePath(gvk), ValidatingWebhookFor(ctx, v, nil, nil, nil))
+	logging.FromContext(ctx).Infow("registered config validating webhook", "path", generateValidatePath(gvk), "configmap", kconfig.Name())
+}
+
+func (v *ConfigMapValidator) registry() *kconfig.Registry {
+	if v.Registry == nil {
+		return kconfig.DefaultRegistry
+	}
+	return v.Registry
+}
+
+// isConfig returns true if the ConfigMap is the configuration watched by config.Manager
+func (v *ConfigMapValidator) isConfig() bool {
+	if v.Name != kconfig.Name() {
+		return false
+	}
+	namespace := os.Getenv(system.NamespaceEnvKey)
+	return namespace == "" || v.Namespace == namespace
+}
+
+// ValidateConfigData validates configuration values using the flags registered in registry.
+// When oldData is not nil only added or changed values are validated.
+// Errors are returned for each invalid key under the data field.
+func ValidateConfigData(registry *kconfig.Registry, data, oldData map[string]string) (errs *apis.FieldError) {
+	keys := make([]string, 0, len(data))
+	for key, value := range data {
+		if oldValue, ok := oldData[key]; ok && oldValue == value {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := registry.Validate(key, kconfig.FeatureValue(data[key])); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(data[key], "", err.Error()).ViaFieldKey("data", key))
+		}
+	}
+	return errs
+}
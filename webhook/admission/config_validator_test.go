@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"testing"
+
+	kconfig "github.com/AlaudaDevops/pkg/config"
+	kscheme "github.com/AlaudaDevops/pkg/scheme"
+
+	"github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestConfigMapValidator(t *testing.T) {
+	ctx := kscheme.WithScheme(context.Background(), scheme.Scheme)
+	registry := kconfig.NewRegistry()
+	registry.MustRegister(
+		kconfig.FlagSpec{Key: "timeout", Type: kconfig.FlagTypeDuration, Default: "1m", Min: "1s"},
+		kconfig.FlagSpec{Key: "enabled", Type: kconfig.FlagTypeBool, Default: "false"},
+	)
+	webhook := ValidatingWebhookFor(ctx, NewConfigMapValidator(registry), nil, nil, nil)
+
+	request := func(operation admissionv1.Operation, object, oldObject string) admission.Request {
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: operation}}
+		req.Object = runtime.RawExtension{Raw: []byte(object)}
+		if oldObject != "" {
+			req.OldObject = runtime.RawExtension{Raw: []byte(oldObject)}
+		}
+		return req
+	}
+
+	table := map[string]struct {
+		Request  admission.Request
+		Allowed  bool
+		Messages []string
+	}{
+		"valid config": {
+			Request: request(admissionv1.Create, `{"metadata":{"name":"alaudadevops-config"},"data":{"timeout":"30s","enabled":"true","other":"x"}}`, ""),
+			Allowed: true,
+		},
+		"invalid config values": {
+			Request:  request(admissionv1.Create, `{"metadata":{"name":"alaudadevops-config"},"data":{"timeout":"30 s","enabled":"yes"}}`, ""),
+			Messages: []string{"data[timeout]", "data[enabled]", `"30 s"`},
+		},
+		"value out of range": {
+			Request:  request(admissionv1.Create, `{"metadata":{"name":"alaudadevops-config"},"data":{"timeout":"10ms"}}`, ""),
+			Messages: []string{"data[timeout]", "greater than or equal to"},
+		},
+		"other configmap": {
+			Request: request(admissionv1.Create, `{"metadata":{"name":"other"},"data":{"timeout":"30 s"}}`, ""),
+			Allowed: true,
+		},
+		"update with unchanged invalid value": {
+			Request: request(admissionv1.Update,
+				`{"metadata":{"name":"alaudadevops-config"},"data":{"timeout":"30 s","enabled":"true"}}`,
+				`{"metadata":{"name":"alaudadevops-config"},"data":{"timeout":"30 s"}}`),
+			Allowed: true,
+		},
+		"update with invalid value": {
+			Request: request(admissionv1.Update,
+				`{"metadata":{"name":"alaudadevops-config"},"data":{"timeout":"1x"}}`,
+				`{"metadata":{"name":"alaudadevops-config"},"data":{"timeout":"30s"}}`),
+			Messages: []string{"data[timeout]"},
+		},
+	}
+
+	for name, test := range table {
+		t.Run(name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			response := webhook.Handle(context.TODO(), test.Request)
+			g.Expect(response.Allowed).To(gomega.Equal(test.Allowed), "%v", response.Result)
+			for _, message := range test.Messages {
+				g.Expect(response.Result.Message).To(gomega.ContainSubstring(message))
+			}
+		})
+	}
+}
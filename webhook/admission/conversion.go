@@ -0,0 +1,327 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	kconfig "github.com/AlaudaDevops/pkg/config"
+	"github.com/AlaudaDevops/pkg/sharedmain"
+	"go.uber.org/zap"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/pkg/logging"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// ConvertFunc converts the src object into the dst object of another version
+type ConvertFunc func(ctx context.Context, src, dst runtime.Object) error
+
+// ConversionWebhook converts the versions of a kind using a hub and spoke model:
+// every spoke version is converted to and from the hub version, which is the storage version,
+// and conversions between spokes go through the hub.
+// The webhook is served under "/convert-<group>-<kind>", e.g. "/convert-example-io-widget",
+// which should be configured as the conversion webhook path of the CRD.
+type ConversionWebhook interface {
+	runtime.Object
+	sharedmain.WebhookSetup
+	sharedmain.WebhookRegisterSetup
+	// WithSpoke registers a spoke version, convertTo converts the spoke to the hub
+	// and convertFrom converts the hub to the spoke
+	WithSpoke(spoke runtime.Object, convertTo, convertFrom ConvertFunc) ConversionWebhook
+	WithLoggerName(loggerName string) ConversionWebhook
+	// Convert converts src into dst, both should be the hub or a registered spoke
+	Convert(ctx context.Context, src, dst runtime.Object) error
+}
+
+type spokeConversion struct {
+	spoke       runtime.Object
+	convertTo   ConvertFunc
+	convertFrom ConvertFunc
+}
+
+type conversionWebhook struct {
+	runtime.Object
+	LoggerName string
+	spokes     []spokeConversion
+}
+
+// NewConversionWebhook returns a conversion webhook for the kind of the hub object
+func NewConversionWebhook(hub runtime.Object) ConversionWebhook {
+	return &conversionWebhook{
+		Object: hub,
+	}
+}
+
+func (w *conversionWebhook) WithSpoke(spoke runtime.Object, convertTo, convertFrom ConvertFunc) ConversionWebhook {
+	w.spokes = append(w.spokes, spokeConversion{spoke: spoke, convertTo: convertTo, convertFrom: convertFrom})
+	return w
+}
+
+func (w *conversionWebhook) WithLoggerName(loggerName string) ConversionWebhook {
+	w.LoggerName = loggerName
+	return w
+}
+
+func (w *conversionWebhook) GetLoggerName() string {
+	if w.LoggerName != "" {
+		return w.LoggerName
+	}
+	if w.Object != nil {
+		typeName := strings.ToLower(reflect.TypeOf(w.Object).Elem().Name())
+
+		return fmt.Sprintf("%s-webhook-conversion", typeName)
+	}
+
+	return "webhook-conversion"
+}
+
+// SetupWebhookWithManager does nothing, the webhook is registered by SetupRegisterWithManager
+func (w *conversionWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return nil
+}
+
+func (w *conversionWebhook) SetupRegisterWithManager(ctx context.Context, mgr ctrl.Manager) {
+	log := logging.FromContext(ctx)
+
+	if w.Object == nil {
+		log.Fatalw("webhook hub object required")
+		return
+	}
+
+	err := RegisterConversionWebhookFor(ctx, mgr, w)
+	if err != nil {
+		log.Fatalw("register webhook failed", "err", err)
+	}
+}
+
+func (w *conversionWebhook) Convert(ctx context.Context, src, dst runtime.Object) error {
+	hub := src
+	if !w.isHub(src) {
+		from, err := w.spokeFor(src)
+		if err != nil {
+			return err
+		}
+		hub = w.newHub()
+		if err := from.convertTo(ctx, src, hub); err != nil {
+			return err
+		}
+		// data restored from the spoke must not be stored in the hub
+		if accessor, ok := hub.(metav1.Object); ok {
+			removeConversionData(accessor)
+		}
+	}
+
+	if w.isHub(dst) {
+		// src is copied so that dst does not share its maps and slices
+		reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(hub.DeepCopyObject()).Elem())
+		return nil
+	}
+	to, err := w.spokeFor(dst)
+	if err != nil {
+		return err
+	}
+	return to.convertFrom(ctx, hub, dst)
+}
+
+func (w *conversionWebhook) isHub(obj runtime.Object) bool {
+	return reflect.TypeOf(obj) == reflect.TypeOf(w.Object)
+}
+
+func (w *conversionWebhook) newHub() runtime.Object {
+	return reflect.New(reflect.TypeOf(w.Object).Elem()).Interface().(runtime.Object)
+}
+
+func (w *conversionWebhook) spokeFor(obj runtime.Object) (spokeConversion, error) {
+	for _, spoke := range w.spokes {
+		if reflect.TypeOf(obj) == reflect.TypeOf(spoke.spoke) {
+			return spoke, nil
+		}
+	}
+	return spokeConversion{}, fmt.Errorf("type %T is neither the hub nor a registered spoke of %T", obj, w.Object)
+}
+
+// RegisterConversionWebhookFor registers a conversion webhook for the kind of the hub of the webhook
+func RegisterConversionWebhookFor(ctx context.Context, mgr ctrl.Manager, webhook ConversionWebhook) (err error) {
+	var gvk schema.GroupVersionKind
+	if gvk, err = apiutil.GVKForObject(webhook.DeepCopyObject(), mgr.GetScheme()); err != nil {
+		return
+	}
+	mgr.GetWebhookServer().Register(
+		generateConvertPath(gvk.GroupKind()),
+		newConversionHandler(ctx, webhook, mgr.GetScheme()),
+	)
+	return
+}
+
+// conversionHandler serves the ConversionReview requests of the API server
+type conversionHandler struct {
+	webhook ConversionWebhook
+	scheme  *runtime.Scheme
+
+	*zap.SugaredLogger
+	ctx context.Context
+}
+
+func newConversionHandler(ctx context.Context, webhook ConversionWebhook, scheme *runtime.Scheme) http.Handler {
+	return &conversionHandler{
+		webhook:       webhook,
+		scheme:        scheme,
+		SugaredLogger: logging.FromContext(ctx),
+		ctx:           ctx,
+	}
+}
+
+// ServeHTTP handles conversion requests
+func (h *conversionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	review := &apiextensionsv1.ConversionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+		h.Errorw("failed to decode conversion review", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "conversion review request is empty", http.StatusBadRequest)
+		return
+	}
+
+	ctx := logging.WithLogger(r.Context(), h.SugaredLogger)
+	if configM := kconfig.ConfigManager(h.ctx); configM != nil {
+		ctx = kconfig.WithConfigManager(ctx, configM)
+	}
+	review.Response = h.convert(ctx, review.Request)
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		h.Errorw("failed to encode conversion review", "err", err)
+	}
+}
+
+// convert converts all the objects of the request, the response fails if any of them fails
+func (h *conversionHandler) convert(ctx context.Context, req *apiextensionsv1.ConversionRequest) *apiextensionsv1.ConversionResponse {
+	resp := &apiextensionsv1.ConversionResponse{UID: req.UID}
+	desired, err := schema.ParseGroupVersion(req.DesiredAPIVersion)
+	if err != nil {
+		resp.Result = conversionFailure(err)
+		return resp
+	}
+	for _, obj := range req.Objects {
+		raw, err := h.convertRaw(ctx, obj.Raw, desired)
+		if err != nil {
+			h.Errorw("failed to convert object", "desiredAPIVersion", req.DesiredAPIVersion, "err", err)
+			resp.Result = conversionFailure(err)
+			resp.ConvertedObjects = nil
+			return resp
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, runtime.RawExtension{Raw: raw})
+	}
+	resp.Result = metav1.Status{Status: metav1.StatusSuccess}
+	return resp
+}
+
+func (h *conversionHandler) convertRaw(ctx context.Context, raw []byte, desired schema.GroupVersion) ([]byte, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, err
+	}
+	srcGVK := typeMeta.GroupVersionKind()
+	src, err := h.scheme.New(srcGVK)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, src); err != nil {
+		return nil, err
+	}
+	dstGVK := desired.WithKind(srcGVK.Kind)
+	dst, err := h.scheme.New(dstGVK)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.webhook.Convert(ctx, src, dst); err != nil {
+		return nil, err
+	}
+	dst.GetObjectKind().SetGroupVersionKind(dstGVK)
+	return json.Marshal(dst)
+}
+
+func conversionFailure(err error) metav1.Status {
+	return metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+}
+
+// MarshalConversionData stores lossy in the v1alpha1.ConversionDataAnnotationKey annotation of dst.
+// Should be used when converting the hub to a spoke which cannot represent all the fields of the hub,
+// lossy only holds those fields so that they are restored by UnmarshalConversionData
+// when the spoke is converted back to the hub.
+//
+//	MarshalConversionData(buildLossyFields{Timeout: hub.Spec.Timeout}, spoke)
+func MarshalConversionData(lossy interface{}, dst metav1.Object) error {
+	data, err := json.Marshal(lossy)
+	if err != nil {
+		return err
+	}
+	// the annotations are copied as they may be shared with the source of the conversion
+	annotations := map[string]string{}
+	for key, value := range dst.GetAnnotations() {
+		annotations[key] = value
+	}
+	annotations[v1alpha1.ConversionDataAnnotationKey] = string(data)
+	dst.SetAnnotations(annotations)
+	return nil
+}
+
+// UnmarshalConversionData restores into lossy the fields stored by MarshalConversionData in from,
+// returns false if from has no conversion data. The annotation is removed from the hub by the
+// ConversionWebhook after the conversion.
+func UnmarshalConversionData(from metav1.Object, lossy interface{}) (bool, error) {
+	data, ok := from.GetAnnotations()[v1alpha1.ConversionDataAnnotationKey]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal([]byte(data), lossy); err != nil {
+		return false, fmt.Errorf("unmarshal conversion data: %w", err)
+	}
+	return true, nil
+}
+
+// removeConversionData removes the conversion data annotation,
+// the annotations are copied as they may be shared with the source of the conversion
+func removeConversionData(obj metav1.Object) {
+	if _, ok := obj.GetAnnotations()[v1alpha1.ConversionDataAnnotationKey]; !ok {
+		return
+	}
+	var annotations map[string]string
+	for key, value := range obj.GetAnnotations() {
+		if key == v1alpha1.ConversionDataAnnotationKey {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	obj.SetAnnotations(annotations)
+}
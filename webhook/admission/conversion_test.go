@@ -0,0 +1,175 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AlaudaDevops/pkg/apis/meta/v1alpha1"
+	ktesting "github.com/AlaudaDevops/pkg/testing"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var widgetGroupKind = schema.GroupKind{Group: "test.io", Kind: "Widget"}
+
+// widgetV2 is the hub version
+type widgetV2 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              widgetV2Spec `json:"spec"`
+}
+
+type widgetV2Spec struct {
+	Replicas int32  `json:"replicas"`
+	Color    string `json:"color,omitempty"`
+}
+
+func (w *widgetV2) DeepCopyObject() runtime.Object {
+	out := *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+
+// widgetV1 is a spoke version without color
+type widgetV1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              widgetV1Spec `json:"spec"`
+}
+
+type widgetV1Spec struct {
+	Replicas int32 `json:"replicas"`
+}
+
+func (w *widgetV1) DeepCopyObject() runtime.Object {
+	out := *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+
+// widgetV1Lossy are the fields of the hub which cannot be represented in widgetV1
+type widgetV1Lossy struct {
+	Color string `json:"color,omitempty"`
+}
+
+func newWidgetConversionWebhook() (ConversionWebhook, *runtime.Scheme) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(widgetGroupKind.WithVersion("v2"), &widgetV2{})
+	scheme.AddKnownTypeWithName(widgetGroupKind.WithVersion("v1"), &widgetV1{})
+
+	webhook := NewConversionWebhook(&widgetV2{}).WithSpoke(&widgetV1{},
+		func(ctx context.Context, src, dst runtime.Object) error {
+			spoke, hub := src.(*widgetV1), dst.(*widgetV2)
+			hub.ObjectMeta = spoke.ObjectMeta
+			hub.Spec.Replicas = spoke.Spec.Replicas
+			lossy := &widgetV1Lossy{}
+			if ok, err := UnmarshalConversionData(spoke, lossy); err != nil || !ok {
+				return err
+			}
+			hub.Spec.Color = lossy.Color
+			return nil
+		},
+		func(ctx context.Context, src, dst runtime.Object) error {
+			hub, spoke := src.(*widgetV2), dst.(*widgetV1)
+			spoke.ObjectMeta = hub.ObjectMeta
+			spoke.Spec.Replicas = hub.Spec.Replicas
+			return MarshalConversionData(widgetV1Lossy{Color: hub.Spec.Color}, spoke)
+		},
+	)
+	return webhook, scheme
+}
+
+func TestConversionWebhookRoundTrip(t *testing.T) {
+	g := NewGomegaWithT(t)
+	webhook, scheme := newWidgetConversionWebhook()
+	g.Expect(webhook.GetLoggerName()).To(Equal("widgetv2-webhook-conversion"))
+
+	ktesting.FuzzConversionRoundTrip(g, webhook, scheme, &widgetV2{}, &widgetV1{}, 50)
+	ktesting.FuzzConversionRoundTripWithSeed(g, webhook, scheme, &widgetV2{}, &widgetV1{}, 1, 10)
+
+	// lossy fields are restored from the annotation and the annotation is not stored in the hub
+	hub := &widgetV2{ObjectMeta: metav1.ObjectMeta{Name: "widget"}, Spec: widgetV2Spec{Replicas: 2, Color: "red"}}
+	spoke := &widgetV1{}
+	g.Expect(webhook.Convert(context.TODO(), hub, spoke)).To(Succeed())
+	g.Expect(spoke.Annotations).To(HaveKeyWithValue(v1alpha1.ConversionDataAnnotationKey, `{"color":"red"}`))
+	g.Expect(hub.Annotations).To(BeNil())
+	restored := &widgetV2{}
+	g.Expect(webhook.Convert(context.TODO(), spoke, restored)).To(Succeed())
+	g.Expect(restored).To(Equal(hub))
+
+	// converting to the hub does not share the content of the source
+	hub.Labels = map[string]string{"a": "b"}
+	copied := &widgetV2{}
+	g.Expect(webhook.Convert(context.TODO(), hub, copied)).To(Succeed())
+	copied.Labels["a"] = "c"
+	g.Expect(hub.Labels).To(Equal(map[string]string{"a": "b"}))
+
+	g.Expect(webhook.Convert(context.TODO(), hub, &metav1.PartialObjectMetadata{})).NotTo(Succeed())
+}
+
+func TestConversionHandler(t *testing.T) {
+	g := NewGomegaWithT(t)
+	webhook, scheme := newWidgetConversionWebhook()
+	handler := newConversionHandler(context.TODO(), webhook, scheme)
+	g.Expect(generateConvertPath(widgetGroupKind)).To(Equal("/convert-test-io-widget"))
+
+	review := func(desiredAPIVersion string, objects ...runtime.Object) *apiextensionsv1.ConversionResponse {
+		req := &apiextensionsv1.ConversionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"},
+			Request:  &apiextensionsv1.ConversionRequest{UID: "uid", DesiredAPIVersion: desiredAPIVersion},
+		}
+		for _, obj := range objects {
+			req.Request.Objects = append(req.Request.Objects, runtime.RawExtension{Object: obj})
+		}
+		body, err := json.Marshal(req)
+		g.Expect(err).To(BeNil())
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/convert-test-io-widget", bytes.NewReader(body)))
+		g.Expect(recorder.Code).To(Equal(http.StatusOK))
+		resp := &apiextensionsv1.ConversionReview{}
+		g.Expect(json.Unmarshal(recorder.Body.Bytes(), resp)).To(Succeed())
+		g.Expect(resp.Response.UID).To(BeEquivalentTo("uid"))
+		return resp.Response
+	}
+
+	spoke := &widgetV1{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "test.io/v1", Kind: "Widget"},
+		ObjectMeta: metav1.ObjectMeta{Name: "widget"},
+		Spec:       widgetV1Spec{Replicas: 3},
+	}
+	resp := review("test.io/v2", spoke)
+	g.Expect(resp.Result.Status).To(Equal(metav1.StatusSuccess))
+	g.Expect(resp.ConvertedObjects).To(HaveLen(1))
+	hub := &widgetV2{}
+	g.Expect(json.Unmarshal(resp.ConvertedObjects[0].Raw, hub)).To(Succeed())
+	g.Expect(hub.APIVersion).To(Equal("test.io/v2"))
+	g.Expect(hub.Spec.Replicas).To(Equal(int32(3)))
+
+	resp = review("test.io/v3", spoke)
+	g.Expect(resp.Result.Status).To(Equal(metav1.StatusFailure))
+	g.Expect(resp.ConvertedObjects).To(BeEmpty())
+}
@@ -36,6 +36,10 @@ func generateValidatePath(gvk schema.GroupVersionKind) string {
 		gvk.Version + "-" + strings.ToLower(gvk.Kind)
 }
 
+func generateConvertPath(gk schema.GroupKind) string {
+	return "/convert-" + strings.Replace(gk.Group, ".", "-", -1) + "-" + strings.ToLower(gk.Kind)
+}
+
 // SubjectFromRequest returns a user based on the request information
 func SubjectFromRequest(req admission.Request) *rbacv1.Subject {
 	sub := &rbacv1.Subject{}
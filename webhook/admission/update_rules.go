@@ -0,0 +1,99 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+
+	"github.com/AlaudaDevops/pkg/apis/validation"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ImmutableFields returns a ValidateUpdateFunc denying updates which change any of the fields
+// selected by dot separated paths, e.g. "spec.type", with an Invalid error listing the changed fields.
+// Returns an error if a path cannot be resolved, see validation.ValidateFieldPath.
+func ImmutableFields(paths ...string) (ValidateUpdateFunc, error) {
+	if err := validateFieldPaths(paths...); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, obj runtime.Object, old runtime.Object, req admission.Request) error {
+		return invalidUpdateError(obj, req, validation.ValidateImmutableFields(obj, old, paths...))
+	}, nil
+}
+
+// MustImmutableFields is the same as ImmutableFields but panics on error,
+// mostly used when building webhooks with constant paths
+//
+//	NewValidatorWebhook(&v1alpha1.Build{}).WithValidateUpdate(MustImmutableFields("spec.type", "spec.source"))
+func MustImmutableFields(paths ...string) ValidateUpdateFunc {
+	validate, err := ImmutableFields(paths...)
+	if err != nil {
+		panic(err)
+	}
+	return validate
+}
+
+// FieldTransitions returns a ValidateUpdateFunc denying updates which change the enum field
+// selected by the dot separated path, e.g. "spec.state", to a value not allowed by transitions.
+// Returns an error if the path cannot be resolved, see validation.ValidateFieldPath.
+func FieldTransitions(path string, transitions validation.Transitions) (ValidateUpdateFunc, error) {
+	if err := validateFieldPaths(path); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, obj runtime.Object, old runtime.Object, req admission.Request) error {
+		return invalidUpdateError(obj, req, validation.ValidateFieldTransition(obj, old, path, transitions))
+	}, nil
+}
+
+// MustFieldTransitions is the same as FieldTransitions but panics on error,
+// mostly used when building webhooks with constant paths
+//
+//	MustFieldTransitions("spec.state", validation.Transitions{"Pending": {"Running"}, "Running": {"Done"}})
+func MustFieldTransitions(path string, transitions validation.Transitions) ValidateUpdateFunc {
+	validate, err := FieldTransitions(path, transitions)
+	if err != nil {
+		panic(err)
+	}
+	return validate
+}
+
+// validateFieldPaths returns an error if any path cannot be resolved,
+// so that invalid rules are rejected when they are built instead of never denying updates
+func validateFieldPaths(paths ...string) error {
+	for _, path := range paths {
+		if err := validation.ValidateFieldPath(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidUpdateError returns an Invalid error for the object of the request if errs is not empty
+func invalidUpdateError(obj runtime.Object, req admission.Request, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	name := req.Name
+	if accessor, err := meta.Accessor(obj); err == nil && accessor.GetName() != "" {
+		name = accessor.GetName()
+	}
+	return validation.ReturnInvalidError(schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}, name, errs)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2023 The AlaudaDevops Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AlaudaDevops/pkg/apis/validation"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestUpdateRules(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	req := admission.Request{AdmissionRequest: v1.AdmissionRequest{
+		Operation: v1.Update,
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+	}}
+
+	old := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod"}, Spec: corev1.PodSpec{NodeName: "node-1"}, Status: corev1.PodStatus{Phase: corev1.PodPending}}
+	immutable := MustImmutableFields("spec.nodeName")
+	transitions := MustFieldTransitions("status.phase", validation.Transitions{string(corev1.PodPending): {string(corev1.PodRunning)}})
+
+	updated := old.DeepCopy()
+	updated.Status.Phase = corev1.PodRunning
+	g.Expect(immutable(ctx, updated, old, req)).To(Succeed())
+	g.Expect(transitions(ctx, updated, old, req)).To(Succeed())
+
+	updated.Spec.NodeName = "node-2"
+	err := immutable(ctx, updated, old, req)
+	g.Expect(errors.IsInvalid(err)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring(`Pod "pod" is invalid: spec.nodeName`))
+	g.Expect(convertToResponse(err).Result.Details.Causes).To(HaveLen(1))
+
+	err = transitions(ctx, old, updated, req)
+	g.Expect(errors.IsInvalid(err)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring(`cannot change from "Running"`))
+
+	// paths which cannot be resolved are rejected when building the rules
+	_, err = ImmutableFields("spec.nodeName", "spec.containers[0].image")
+	g.Expect(err).NotTo(BeNil())
+	_, err = FieldTransitions("status.conditions[*].type", nil)
+	g.Expect(err).NotTo(BeNil())
+	g.Expect(func() { MustImmutableFields("spec.containers[0].image") }).To(Panic())
+	g.Expect(func() { MustFieldTransitions("status.conditions[*].type", nil) }).To(Panic())
+}
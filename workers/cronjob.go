@@ -43,4 +43,7 @@ type cronJob struct {
 
 	// entryID for cron EntryID
 	entryID cron.EntryID
+
+	// spec is the cron spec of the current entry
+	spec string
 }
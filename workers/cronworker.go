@@ -34,6 +34,10 @@ var ConfigWatcherFunc = func(cw *CronWorker) func(c *config.Config) {
 	return func(c *config.Config) {
 		for i, job := range cw.jobs {
 			spec := metav1alpha1.DataMap(c.Data).MustStringVal(job.name, "0 0 * * *")
+			if job.entryID > 0 && job.spec == spec {
+				// the entry is only rebuilt when its spec changed
+				continue
+			}
 			newEntryID, err := cw.cron.AddJob(spec, job.funcJob)
 			if err != nil {
 				cw.Errorw("config watcher update cron job error", "err", err)
@@ -43,6 +47,7 @@ var ConfigWatcherFunc = func(cw *CronWorker) func(c *config.Config) {
 				cw.cron.Remove(job.entryID)
 			}
 			cw.jobs[i].entryID = newEntryID
+			cw.jobs[i].spec = spec
 			cw.Debugf("ConfigWatcherFunc fallback: set job %s cron spec to %s", job.name, spec)
 		}
 	}
@@ -52,11 +57,16 @@ var ConfigWatcherFunc = func(cw *CronWorker) func(c *config.Config) {
 type CronWorker struct {
 	Runners []JobRunnable
 
+	// ElectionID elects the worker using its own lease instead of the lease of the manager when not empty
+	ElectionID string
+
 	jobs []*cronJob
 
 	*zap.SugaredLogger
 	cron    *cron.Cron
 	watcher config.Watcher
+	// subscription to the changes of the cron specs, canceled when the worker stops
+	subscription *config.Subscription
 }
 
 // NeedLeaderElection indicates cron worker
@@ -64,11 +74,19 @@ func (cw *CronWorker) NeedLeaderElection() bool {
 	return true
 }
 
+// LeaderElectionID returns the ElectionID of the worker, see controllers.LeaderElectionDeclarer
+func (cw *CronWorker) LeaderElectionID() string {
+	return cw.ElectionID
+}
+
 // Start starts cron and waits for context cancellation
 func (cw *CronWorker) Start(ctx context.Context) error {
 	cw.cron.Start()
 	<-ctx.Done()
 	cw.cron.Stop()
+	if cw.subscription != nil {
+		cw.subscription.Cancel()
+	}
 	return nil
 }
 
@@ -84,6 +102,7 @@ func (cw *CronWorker) Setup(ctx context.Context, manager manager.Manager, logger
 	}
 
 	cw.cron = cron.New()
+	cw.jobs = nil
 	cw.SugaredLogger = logger.With("component", cw.Name())
 	ctx = logging.WithLogger(ctx, logger)
 
@@ -99,7 +118,19 @@ func (cw *CronWorker) Setup(ctx context.Context, manager manager.Manager, logger
 	}
 
 	if kMgr := config.ConfigManager(ctx); kMgr != nil {
-		kMgr.AddWatcher(config.NewConfigWatcher(ConfigWatcherFunc(cw)))
+		jobNames := make([]string, 0, len(cw.jobs))
+		for _, job := range cw.jobs {
+			jobNames = append(jobNames, job.name)
+		}
+		watch := ConfigWatcherFunc(cw)
+		if cw.subscription != nil {
+			// the worker is set up again, e.g. by the lazy loader
+			cw.subscription.Cancel()
+		}
+		// only changes of the cron specs of the jobs are relevant
+		cw.subscription = kMgr.Subscribe(cw.Name(), func(change config.ConfigChange) {
+			watch(change.New)
+		}, config.WithKeys(jobNames...))
 	}
 
 	return manager.Add(cw)
@@ -136,11 +136,37 @@ var _ = Describe("Test.CronWorker", func() {
 					Namespace: ns,
 				},
 				Data: map[string]string{
-					"xx": "yy",
+					fr.JobName(): "* * * * *",
 				},
 			})
 			<-stopChan
 			Expect(fr.result).To(Equal("ok"))
 		})
+
+		It("stops watching the config when stopped", func() {
+			go func() {
+				if err := watcher.Start(make(chan struct{})); err != nil {
+					logger.Fatal("failed to start watcher", zap.Error(err))
+				}
+			}()
+			Expect(worker.Setup(ctx, manager, logger)).To(Succeed())
+			Expect(worker.subscription).NotTo(BeNil())
+
+			startCtx, cancel := context.WithCancel(ctx)
+			cancel()
+			Expect(worker.Start(startCtx)).To(Succeed())
+
+			watcher.OnChange(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      cmName,
+					Namespace: ns,
+				},
+				Data: map[string]string{
+					fr.JobName(): "* * * * *",
+				},
+			})
+			Consistently(stopChan, "100ms").ShouldNot(Receive())
+			Expect(fr.result).To(BeEmpty())
+		})
 	})
 })